@@ -0,0 +1,176 @@
+// Package agenda aggregates open work across the daily todo archive.
+//
+// An agenda is built from every file matching the configured pattern and
+// lists incomplete, pushed and highlighted todos grouped by the date of the
+// file they live in. Items can be completed in place or pulled into today's
+// file; both actions are expressed as control requests so that a running TUI
+// session editing the same file can apply them itself.
+package agenda
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"td-file/config"
+	"td-file/control"
+	"td-file/parser"
+)
+
+// Item is a single agenda entry.
+type Item struct {
+	File config.DatedFile
+	Todo parser.Todo
+	// Ancestors holds the text of each parent, outermost first.
+	Ancestors []string
+}
+
+// Ref returns a stable reference to the item for use on the command line,
+// e.g. "2024-06-01:3" or "/path/todos.md:3" for undated files.
+func (it Item) Ref() string {
+	return fileLabel(it.File) + ":" + strconv.Itoa(it.Todo.LineNumber)
+}
+
+// Group holds the items from one source file.
+type Group struct {
+	File  config.DatedFile
+	Items []Item
+}
+
+// Label returns the group heading: the file's date, or its path when the
+// file has no date.
+func (g Group) Label() string {
+	return fileLabel(g.File)
+}
+
+func fileLabel(f config.DatedFile) string {
+	if f.Date.IsZero() {
+		return f.Path
+	}
+	return f.Date.Format(parser.DateLayout)
+}
+
+// Include reports whether a todo belongs on the agenda.
+func Include(t *parser.Todo) bool {
+	return !t.State.Closed() || t.Highlighted
+}
+
+// Build scans files and returns one group per file that has agenda items,
+// in the order given. Warnings are prefixed with the file they came from.
+func Build(files []config.DatedFile) ([]Group, []string, error) {
+	var groups []Group
+	var warnings []string
+	for _, f := range files {
+		blocks, warn, err := parser.ExtractTdBlocksWithWarnings(f.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		todos, warn2 := parser.ParseTodosWithWarnings(blocks)
+		for _, w := range append(warn, warn2...) {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", f.Path, w))
+		}
+		g := Group{File: f}
+		var walk func(nodes []*parser.Todo, ancestors []string)
+		walk = func(nodes []*parser.Todo, ancestors []string) {
+			for _, n := range nodes {
+				if Include(n) {
+					t := *n
+					t.Children, t.Parent = nil, nil
+					g.Items = append(g.Items, Item{File: f, Todo: t, Ancestors: append([]string(nil), ancestors...)})
+				}
+				walk(n.Children, append(ancestors, n.Text))
+			}
+		}
+		walk(parser.BuildTree(todos), nil)
+		if len(g.Items) > 0 {
+			groups = append(groups, g)
+		}
+	}
+	return groups, warnings, nil
+}
+
+// Items flattens groups into a single list in display order.
+func Items(groups []Group) []Item {
+	var out []Item
+	for _, g := range groups {
+		out = append(out, g.Items...)
+	}
+	return out
+}
+
+// Find resolves a reference produced by Item.Ref.
+func Find(groups []Group, ref string) (Item, error) {
+	for _, it := range Items(groups) {
+		if it.Ref() == ref {
+			return it, nil
+		}
+	}
+	return Item{}, fmt.Errorf("no agenda item %q", ref)
+}
+
+// Complete toggles the item between completed and incomplete in its source
+// file and returns the todo with its new state. The request fails if the file
+// has changed since the agenda was built.
+func Complete(it Item, apply control.Applier) (parser.Todo, error) {
+	t := it.Todo
+	t.State = parser.Completed
+	if it.Todo.State == parser.Completed {
+		t.State = parser.Incomplete
+	}
+	err := apply(control.Request{
+		Op:    control.OpSetState,
+		Path:  it.File.Path,
+		Line:  t.LineNumber,
+		Text:  t.Text,
+		State: t.State.String(),
+	})
+	return t, err
+}
+
+// Pull copies the item into the last block of today's file as a new
+// incomplete root todo, marks the original as pushed, and returns the copy.
+func Pull(it Item, todayPath string, apply control.Applier) (parser.Todo, error) {
+	pulled := parser.Todo{Text: it.Todo.Text, State: parser.Incomplete, Highlighted: it.Todo.Highlighted}
+	if it.File.Path == todayPath {
+		return pulled, fmt.Errorf("item is already in today's file")
+	}
+	blocks, err := parser.ExtractTdBlocks(todayPath)
+	if err != nil {
+		return pulled, err
+	}
+	if len(blocks) == 0 {
+		return pulled, fmt.Errorf("%s has no :td block to pull into", todayPath)
+	}
+	if err := apply(control.Request{
+		Op:    control.OpSetState,
+		Path:  it.File.Path,
+		Line:  it.Todo.LineNumber,
+		Text:  it.Todo.Text,
+		State: parser.Pushed.String(),
+	}); err != nil {
+		return pulled, err
+	}
+	return pulled, apply(control.Request{
+		Op:    control.OpAdd,
+		Path:  todayPath,
+		Block: len(blocks) - 1,
+		Todos: []parser.Todo{pulled},
+	})
+}
+
+// Since returns the files dated on or after from; undated files are kept.
+func Since(files []config.DatedFile, from time.Time) []config.DatedFile {
+	var out []config.DatedFile
+	for _, f := range files {
+		if f.Date.IsZero() || !f.Date.Before(from) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Context renders the ancestor path of an item, e.g. "Release › Deploy".
+func (it Item) Context() string {
+	return strings.Join(it.Ancestors, " › ")
+}
@@ -0,0 +1,114 @@
+package agenda_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"td-file/agenda"
+	"td-file/config"
+	"td-file/control"
+	"td-file/parser"
+)
+
+func writeDaily(t *testing.T, dir, date, content string) config.DatedFile {
+	t.Helper()
+	path := filepath.Join(dir, "todos-"+date+".md")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+	d, _ := time.ParseInLocation("2006-01-02", date, time.Local)
+	return config.DatedFile{Path: path, Date: d}
+}
+
+func TestBuild(t *testing.T) {
+	dir := t.TempDir()
+	files := []config.DatedFile{
+		writeDaily(t, dir, "2024-06-01", ":td\n- [x] Done\n  - [ ] Straggler\n- [>] Pushed\n:td\n"),
+		writeDaily(t, dir, "2024-06-02", ":td\n- [x] All done\n:td\n"),
+		writeDaily(t, dir, "2024-06-03", ":td\n- [ ] Open *\n:td\n"),
+	}
+	groups, warnings, err := agenda.Build(files)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups (empty day skipped), got %d", len(groups))
+	}
+	if groups[0].Label() != "2024-06-01" || len(groups[0].Items) != 2 {
+		t.Fatalf("unexpected first group: %+v", groups[0])
+	}
+	straggler := groups[0].Items[0]
+	if straggler.Todo.Text != "Straggler" || straggler.Context() != "Done" || straggler.Ref() != "2024-06-01:2" {
+		t.Errorf("unexpected item: %+v (ref %s)", straggler, straggler.Ref())
+	}
+	if !groups[1].Items[0].Todo.Highlighted {
+		t.Errorf("expected highlight to be carried through")
+	}
+}
+
+func TestComplete(t *testing.T) {
+	dir := t.TempDir()
+	f := writeDaily(t, dir, "2024-06-01", "# Notes\n:td\n- [ ] A\n- [ ] B\n:td\n")
+	groups, _, err := agenda.Build([]config.DatedFile{f})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	it, err := agenda.Find(groups, "2024-06-01:2")
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if done, err := agenda.Complete(it, control.Direct); err != nil || done.State != parser.Completed {
+		t.Fatalf("Complete = %v, %v", done.State, err)
+	}
+	content, _ := os.ReadFile(f.Path)
+	if string(content) != "# Notes\n:td\n- [ ] A\n- [x] B\n:td\n" {
+		t.Errorf("unexpected file content: %q", content)
+	}
+}
+
+func TestPull(t *testing.T) {
+	dir := t.TempDir()
+	old := writeDaily(t, dir, "2024-06-01", ":td\n- [ ] Carry me\n:td\n")
+	today := writeDaily(t, dir, "2024-06-02", ":td\n- [ ] Fresh\n:td\n")
+	groups, _, err := agenda.Build([]config.DatedFile{old, today})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	it, err := agenda.Find(groups, "2024-06-01:1")
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if _, err := agenda.Pull(it, today.Path, control.Direct); err != nil {
+		t.Fatalf("Pull failed: %v", err)
+	}
+	oldContent, _ := os.ReadFile(old.Path)
+	if !strings.Contains(string(oldContent), "- [>] Carry me") {
+		t.Errorf("expected source to be marked pushed, got %q", oldContent)
+	}
+	todayContent, _ := os.ReadFile(today.Path)
+	if !strings.Contains(string(todayContent), "- [ ] Fresh\n- [ ] Carry me\n") {
+		t.Errorf("expected item appended to today's file, got %q", todayContent)
+	}
+	if _, err := agenda.Pull(agenda.Item{File: today, Todo: it.Todo}, today.Path, control.Direct); err == nil {
+		t.Error("expected error pulling an item from today's file into itself")
+	}
+}
+
+func TestComplete_StaleItem(t *testing.T) {
+	dir := t.TempDir()
+	f := writeDaily(t, dir, "2024-06-01", ":td\n- [ ] A\n:td\n")
+	groups, _, _ := agenda.Build([]config.DatedFile{f})
+	it := agenda.Items(groups)[0]
+	if err := os.WriteFile(f.Path, []byte(":td\n- [ ] Changed\n:td\n"), 0644); err != nil {
+		t.Fatalf("failed to rewrite file: %v", err)
+	}
+	if _, err := agenda.Complete(it, control.Direct); err == nil {
+		t.Error("expected error completing an item whose file changed")
+	}
+}
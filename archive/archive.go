@@ -0,0 +1,246 @@
+// Package archive moves finished todos out of the :td blocks into an
+// archive section, either at the end of the same file or in a separate
+// archive file.
+//
+// Archived todos keep their subtree and are stamped with the date they
+// were finished (a done: field, or cancelled: for cancelled todos) if they
+// have none. The top line of each entry notes where it came from, e.g.
+//
+//	## Archive
+//	- [x] Deploy done:2024-06-07 (in Work › Release)
+//	  - [x] Tag the build done:2024-06-07
+//
+// The archive section is plain markdown outside any :td block, so archived
+// todos are never parsed again.
+package archive
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"td-file/config"
+	"td-file/control"
+	"td-file/parser"
+	"td-file/sync"
+)
+
+// DefaultHeading is the heading of the archive section.
+const DefaultHeading = "Archive"
+
+// Target returns the file and heading finished todos from todoPath are
+// archived under.
+func Target(cfg *config.Config, todoPath string) (path, heading string) {
+	path, heading = todoPath, DefaultHeading
+	if cfg == nil || cfg.Archive == nil {
+		return path, heading
+	}
+	if cfg.Archive.Heading != "" {
+		heading = cfg.Archive.Heading
+	}
+	if f := cfg.Archive.File; f != "" {
+		if strings.HasPrefix(f, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				f = filepath.Join(home, f[2:])
+			}
+		}
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(filepath.Dir(todoPath), f)
+		}
+		path = f
+	}
+	return path, heading
+}
+
+// finished reports whether n and all of its descendants are closed.
+func finished(n *parser.Todo) bool {
+	if !n.State.Closed() {
+		return false
+	}
+	for _, c := range n.Children {
+		if !finished(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Finished returns the outermost todos under nodes (including nodes
+// themselves) that are closed together with all their descendants.
+func Finished(nodes []*parser.Todo) []*parser.Todo {
+	var out []*parser.Todo
+	for _, n := range nodes {
+		if finished(n) {
+			out = append(out, n)
+		} else {
+			out = append(out, Finished(n.Children)...)
+		}
+	}
+	return out
+}
+
+// OlderThan returns the todos from Finished(roots) that were finished more
+// than days days before now. Todos with no finish date count as finished on
+// fileDate, the date of the daily file they are in; if that is zero too they
+// are left alone and counted in undated. Finish dates are only stamped when
+// done_dates is on, and it is off by default, so outside daily files most
+// todos are undated until it is turned on.
+func OlderThan(roots []*parser.Todo, days int, now, fileDate time.Time) (out []*parser.Todo, undated int) {
+	y, m, d := now.Date()
+	cutoff := time.Date(y, m, d-days, 0, 0, 0, 0, now.Location())
+	for _, n := range Finished(roots) {
+		at, ok := finishedOn(n)
+		if !ok && !fileDate.IsZero() {
+			at, ok = fileDate, true
+		}
+		switch {
+		case !ok:
+			undated++
+		case at.Before(cutoff):
+			out = append(out, n)
+		}
+	}
+	return out, undated
+}
+
+// dateKey is the field recording when a todo in state s was finished.
+func dateKey(s parser.TodoState) string {
+	if s == parser.Cancelled {
+		return "cancelled"
+	}
+	return "done"
+}
+
+func finishedOn(n *parser.Todo) (time.Time, bool) {
+	return parser.ParseMetadata(n.Text).Date(dateKey(n.State))
+}
+
+// Lines renders nodes and their subtrees as archive entries, stamping
+// undated todos with now. blocks, if given, name the block each entry came
+// from.
+func Lines(nodes []*parser.Todo, blocks []parser.Block, now time.Time) []string {
+	var out []string
+	var walk func(n *parser.Todo, depth int, context string)
+	walk = func(n *parser.Todo, depth int, context string) {
+		t := *n
+		if _, ok := finishedOn(n); !ok {
+			t.Text = parser.SetField(t.Text, dateKey(n.State), now.Format(parser.DateLayout))
+		}
+		if context != "" {
+			t.Text += " (in " + context + ")"
+		}
+		t.Indent, t.IndentLevel = strings.Repeat("  ", depth), depth*2
+		out = append(out, parser.FormatTodo(t))
+		for _, c := range n.Children {
+			walk(c, depth+1, "")
+		}
+	}
+	for _, n := range nodes {
+		var path []string
+		for p := n.Parent; p != nil; p = p.Parent {
+			path = append([]string{p.Text}, path...)
+		}
+		if n.Block >= 0 && n.Block < len(blocks) {
+			if label := blocks[n.Block].Label(); label != "" {
+				path = append([]string{label}, path...)
+			}
+		}
+		walk(n, 0, strings.Join(path, " › "))
+	}
+	return out
+}
+
+var headingRe = regexp.MustCompile(`^ {0,3}(#{1,6})[ \t]+(.*?)[ \t#]*$`)
+
+// Insert returns content with lines added to the end of the section headed
+// heading, which is created at the end of the document if it does not
+// exist. Headings inside :td blocks are ignored.
+func Insert(content, heading string, lines []string) string {
+	doc := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	if content == "" {
+		doc = nil
+	}
+	blocks, _ := parser.FindBlocks(doc)
+	inBlock := func(i int) bool {
+		for _, b := range blocks {
+			if i >= b.Start && i <= b.End {
+				return true
+			}
+		}
+		return false
+	}
+	start, level := -1, 0
+	for i, line := range doc {
+		m := headingRe.FindStringSubmatch(line)
+		if m == nil || inBlock(i) {
+			continue
+		}
+		if start >= 0 && len(m[1]) <= level {
+			return strings.Join(insertAt(doc, lastContent(doc, start, i), lines), "\n") + "\n"
+		}
+		if start < 0 && strings.EqualFold(m[2], heading) {
+			start, level = i, len(m[1])
+		}
+	}
+	if start >= 0 {
+		return strings.Join(insertAt(doc, lastContent(doc, start, len(doc)), lines), "\n") + "\n"
+	}
+	if len(doc) > 0 && strings.TrimSpace(doc[len(doc)-1]) != "" {
+		doc = append(doc, "")
+	}
+	doc = append(doc, "## "+heading, "")
+	return strings.Join(append(doc, lines...), "\n") + "\n"
+}
+
+// lastContent returns the index after the last non-blank line of the
+// section from start (its heading) up to end.
+func lastContent(doc []string, start, end int) int {
+	i := end
+	for i > start+1 && strings.TrimSpace(doc[i-1]) == "" {
+		i--
+	}
+	return i
+}
+
+func insertAt(doc []string, at int, lines []string) []string {
+	out := append([]string{}, doc[:at]...)
+	if at > 0 && headingRe.MatchString(doc[at-1]) {
+		out = append(out, "")
+	}
+	out = append(out, lines...)
+	return append(out, doc[at:]...)
+}
+
+// Write adds lines to the archive section of path.
+func Write(path, heading string, lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return sync.EditFile(path, func(content []byte) ([]byte, error) {
+		return []byte(Insert(string(content), heading, lines)), nil
+	})
+}
+
+// Move archives nodes from the todo file at todoPath: it writes them to the
+// archive first, so that a failure never loses them, then removes them from
+// the file through apply.
+func Move(todoPath string, nodes []*parser.Todo, blocks []parser.Block, cfg *config.Config, now time.Time, apply control.Applier) error {
+	if len(nodes) == 0 {
+		return fmt.Errorf("nothing finished to archive")
+	}
+	path, heading := Target(cfg, todoPath)
+	if err := Write(path, heading, Lines(nodes, blocks, now)); err != nil {
+		return err
+	}
+	batch := control.Request{Op: control.OpBatch, Path: todoPath}
+	for _, n := range nodes {
+		batch.Requests = append(batch.Requests, control.Request{Op: control.OpDelete, Path: todoPath, Line: n.LineNumber, Text: n.Text})
+	}
+	return apply(batch)
+}
@@ -0,0 +1,119 @@
+package archive_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"td-file/archive"
+	"td-file/config"
+	"td-file/control"
+	"td-file/parser"
+)
+
+var now = time.Date(2024, 6, 10, 9, 0, 0, 0, time.Local)
+
+func tree(lines ...string) []*parser.Todo {
+	return parser.BuildTree(parser.ParseTodos([][]string{lines}))
+}
+
+func texts(nodes []*parser.Todo) string {
+	var out []string
+	for _, n := range nodes {
+		out = append(out, n.Text)
+	}
+	return strings.Join(out, ",")
+}
+
+func TestFinished(t *testing.T) {
+	roots := tree(
+		"- [ ] Release",
+		"  - [x] Build",
+		"    - [x] Compile",
+		"  - [ ] Deploy",
+		"  - [-] Announce",
+		"- [x] Done",
+		"  - [ ] Open child",
+	)
+	if got := texts(archive.Finished(roots)); got != "Build,Announce" {
+		t.Errorf("Finished = %s", got)
+	}
+}
+
+func TestOlderThan(t *testing.T) {
+	roots := tree(
+		"- [x] Old done:2024-06-01",
+		"- [x] Recent done:2024-06-09",
+		"- [-] Old cancel cancelled:2024-05-01",
+		"- [x] Undated",
+	)
+	got, undated := archive.OlderThan(roots, 7, now, time.Time{})
+	if texts(got) != "Old done:2024-06-01,Old cancel cancelled:2024-05-01" || undated != 1 {
+		t.Errorf("OlderThan = %s, %d undated", texts(got), undated)
+	}
+	// In a daily file, undated todos count as finished on the file's date.
+	fileDate := time.Date(2024, 6, 2, 0, 0, 0, 0, time.Local)
+	got, undated = archive.OlderThan(roots, 7, now, fileDate)
+	if texts(got) != "Old done:2024-06-01,Old cancel cancelled:2024-05-01,Undated" || undated != 0 {
+		t.Errorf("OlderThan with a file date = %s, %d undated", texts(got), undated)
+	}
+}
+
+func TestLines(t *testing.T) {
+	roots := tree(
+		"- [ ] Release",
+		"  - [x] Build",
+		"    - [-] Compile",
+		"- [x] Done done:2024-06-01",
+	)
+	blocks := []parser.Block{{Name: "Work"}}
+	got := strings.Join(archive.Lines(archive.Finished(roots), blocks, now), "\n")
+	want := "- [x] Build done:2024-06-10 (in Work › Release)\n" +
+		"  - [-] Compile cancelled:2024-06-10\n" +
+		"- [x] Done done:2024-06-01 (in Work)"
+	if got != want {
+		t.Errorf("Lines:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestInsert(t *testing.T) {
+	entry := []string{"- [x] New"}
+	cases := []struct{ name, in, want string }{
+		{"new section", ":td\n- [ ] A\n:td\n", ":td\n- [ ] A\n:td\n\n## Archive\n\n- [x] New\n"},
+		{"empty file", "", "## Archive\n\n- [x] New\n"},
+		{"existing section", "# Day\n:td\n:td\n\n## Archive\n\n- [x] Old\n\n## Notes\ntext\n",
+			"# Day\n:td\n:td\n\n## Archive\n\n- [x] Old\n- [x] New\n\n## Notes\ntext\n"},
+		{"empty section", "## archive\n", "## archive\n\n- [x] New\n"},
+		{"heading inside a block", ":td\n## Archive\n:td\n", ":td\n## Archive\n:td\n\n## Archive\n\n- [x] New\n"},
+	}
+	for _, c := range cases {
+		if got := archive.Insert(c.in, "Archive", entry); got != c.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestMove(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "todos.md")
+	os.WriteFile(path, []byte(":td\n- [ ] A\n  - [x] B done:2024-06-09\n- [x] C done:2024-06-09\n:td\n"), 0644)
+	blocks, _, _ := parser.ExtractBlocks(path)
+	roots := parser.BuildTree(parser.ParseTodos(parser.BlockLines(blocks)))
+	cfg := &config.Config{Archive: &config.ArchiveConfig{File: "archive.md", Heading: "Done"}}
+
+	if err := archive.Move(path, archive.Finished(roots), blocks, cfg, now, control.Direct); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != ":td\n- [ ] A\n:td\n" {
+		t.Errorf("todo file:\n%s", got)
+	}
+	want := "## Done\n\n- [x] B done:2024-06-09 (in A)\n- [x] C done:2024-06-09\n"
+	if got, _ := os.ReadFile(filepath.Join(dir, "archive.md")); string(got) != want {
+		t.Errorf("archive file:\n%s", got)
+	}
+	if err := archive.Move(path, nil, blocks, cfg, now, control.Direct); err == nil {
+		t.Error("archiving nothing should be an error")
+	}
+}
@@ -0,0 +1,219 @@
+package caldav_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"td-file/caldav"
+	"td-file/caldav/caldavtest"
+	"td-file/control"
+	"td-file/ical"
+	"td-file/parser"
+)
+
+type fixture struct {
+	t      *testing.T
+	srv    *caldavtest.Server
+	syncer *caldav.Syncer
+	path   string
+}
+
+func newFixture(t *testing.T, content string) *fixture {
+	t.Helper()
+	srv := caldavtest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.Username, srv.Password = "ann", "secret"
+	dir := t.TempDir()
+	path := filepath.Join(dir, "todos.md")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &fixture{t: t, srv: srv, path: path, syncer: &caldav.Syncer{
+		Client:    &caldav.Client{URL: srv.CollectionURL(), Username: "ann", Password: "secret"},
+		Path:      path,
+		StatePath: filepath.Join(dir, "state.json"),
+	}}
+}
+
+func (f *fixture) sync() caldav.Report {
+	f.t.Helper()
+	blocks, err := parser.ExtractTdBlocks(f.path)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	rep, err := f.syncer.Sync(parser.ParseTodos(blocks), control.Direct)
+	if err != nil {
+		f.t.Fatalf("Sync failed: %v", err)
+	}
+	return rep
+}
+
+func (f *fixture) file() string {
+	data, _ := os.ReadFile(f.path)
+	return string(data)
+}
+
+// edit rewrites the todo file with fn applied to its contents.
+func (f *fixture) edit(fn func(string) string) {
+	os.WriteFile(f.path, []byte(fn(f.file())), 0644)
+}
+
+// remote returns the VTODO stored on the server for uid.
+func (f *fixture) remote(uid string) ical.VTodo {
+	f.t.Helper()
+	data, ok := f.srv.Get(uid + ".ics")
+	if !ok {
+		f.t.Fatalf("no object for %s on the server", uid)
+	}
+	items, err := ical.Decode(strings.NewReader(data))
+	if err != nil || len(items) != 1 {
+		f.t.Fatalf("bad object %q: %v", data, err)
+	}
+	return items[0]
+}
+
+// putRemote stores v on the server as a calendar app would.
+func (f *fixture) putRemote(v ical.VTodo) {
+	var b strings.Builder
+	ical.Encode(&b, []ical.VTodo{v}, time.Now())
+	f.srv.Put(v.UID+".ics", b.String())
+}
+
+func TestSync_FirstRun(t *testing.T) {
+	f := newFixture(t, ":td\n- [ ] Plan trip uid:p1\n  - [x] Book flights uid:b1\n:td\n")
+	f.putRemote(ical.VTodo{UID: "r1", Summary: "Renew passport", Status: "NEEDS-ACTION", Due: "2024-07-01"})
+
+	rep := f.sync()
+	if rep.Pushed != 2 || rep.Pulled != 1 {
+		t.Errorf("unexpected report %s", rep)
+	}
+	if got := f.remote("b1"); got.Status != "COMPLETED" || got.RelatedTo != "p1" {
+		t.Errorf("child not pushed correctly: %+v", got)
+	}
+	if !strings.Contains(f.file(), "- [ ] Renew passport due:2024-07-01 uid:r1\n") {
+		t.Errorf("remote todo not pulled:\n%s", f.file())
+	}
+	if rep := f.sync(); rep.Pushed+rep.Pulled+rep.DeletedLocal+rep.DeletedRemote != 0 {
+		t.Errorf("second sync should be a no-op, got %s", rep)
+	}
+}
+
+func TestSync_AssignsUIDs(t *testing.T) {
+	f := newFixture(t, ":td\n- [ ] Water plants\n:td\n")
+	if rep := f.sync(); rep.Pushed != 1 {
+		t.Fatalf("unexpected report %s", rep)
+	}
+	uid := parser.ParseMetadata(f.file()).Fields["uid"]
+	if uid == "" || f.remote(uid).Summary != "Water plants" {
+		t.Errorf("todo should be pushed under its new uid, file:\n%s\nserver: %v", f.file(), f.srv.Names())
+	}
+}
+
+func TestSync_OneSidedChanges(t *testing.T) {
+	f := newFixture(t, ":td\n- [ ] Call mum uid:a\n- [ ] Pay rent uid:b\n:td\n")
+	f.sync()
+
+	remote := f.remote("a")
+	remote.Status = "COMPLETED"
+	f.putRemote(remote)
+	f.edit(func(s string) string { return strings.Replace(s, "Pay rent", "Pay rent and bills", 1) })
+
+	rep := f.sync()
+	if rep.Pulled != 1 || rep.Pushed != 1 || rep.Conflicts != 0 {
+		t.Errorf("unexpected report %s", rep)
+	}
+	if !strings.Contains(f.file(), "- [x] Call mum uid:a") {
+		t.Errorf("remote completion not pulled:\n%s", f.file())
+	}
+	if got := f.remote("b").Summary; got != "Pay rent and bills" {
+		t.Errorf("local edit not pushed, server has %q", got)
+	}
+	if len(rep.Local.States) != 1 || rep.Local.States[0].Todo.State != parser.Completed {
+		t.Errorf("state change should be reported for hooks: %+v", rep.Local)
+	}
+}
+
+func TestSync_Conflicts(t *testing.T) {
+	for _, tc := range []struct {
+		policy, want, summary string
+	}{
+		{"", "- [x] Buy milk and eggs due:2024-06-02 uid:m", "Buy milk and eggs"},
+		{caldav.ConflictRemote, "- [x] Buy oat milk due:2024-06-02 uid:m", "Buy oat milk"},
+	} {
+		f := newFixture(t, ":td\n- [ ] Buy milk uid:m\n:td\n")
+		f.syncer.Conflict = tc.policy
+		f.sync()
+
+		// Both sides rename; the server also completes it and the local
+		// side adds a due date.
+		remote := f.remote("m")
+		remote.Summary, remote.Status = "Buy oat milk", "COMPLETED"
+		f.putRemote(remote)
+		f.edit(func(s string) string { return strings.Replace(s, "Buy milk", "Buy milk and eggs due:2024-06-02", 1) })
+
+		rep := f.sync()
+		if rep.Conflicts != 1 {
+			t.Errorf("policy %q: expected one conflict, got %s", tc.policy, rep)
+		}
+		if !strings.Contains(f.file(), tc.want+"\n") {
+			t.Errorf("policy %q: want %q in:\n%s", tc.policy, tc.want, f.file())
+		}
+		got := f.remote("m")
+		if got.Summary != tc.summary || got.Due != "2024-06-02" || got.Status != "COMPLETED" {
+			t.Errorf("policy %q: server not updated with the merge: %+v", tc.policy, got)
+		}
+	}
+}
+
+func TestSync_Deletions(t *testing.T) {
+	f := newFixture(t, ":td\n- [ ] Keep uid:k\n- [ ] Gone remotely uid:r\n- [ ] Gone locally uid:l\n- [ ] Edited then deleted uid:e\n:td\n")
+	f.sync()
+
+	f.srv.Delete("r.ics")
+	f.srv.Delete("e.ics")
+	f.edit(func(s string) string {
+		s = strings.Replace(s, "- [ ] Gone locally uid:l\n", "", 1)
+		return strings.Replace(s, "Edited then deleted", "Edited", 1)
+	})
+
+	rep := f.sync()
+	if rep.DeletedLocal != 1 || rep.DeletedRemote != 1 || rep.Pushed != 1 {
+		t.Errorf("unexpected report %s", rep)
+	}
+	if want := ":td\n- [ ] Keep uid:k\n- [ ] Edited uid:e\n:td\n"; f.file() != want {
+		t.Errorf("file:\n%s\nwant:\n%s", f.file(), want)
+	}
+	if got := strings.Join(f.srv.Names(), ","); got != "e.ics,k.ics" {
+		t.Errorf("server has %s", got)
+	}
+}
+
+func TestSync_KeepsPushedState(t *testing.T) {
+	f := newFixture(t, ":td\n- [>] Tomorrow uid:t\n:td\n")
+	f.sync()
+	// A calendar app rewrites the object without our X- property.
+	remote := f.remote("t")
+	remote.State = ""
+	remote.Summary = "Tomorrow, really"
+	f.putRemote(remote)
+
+	f.sync()
+	if !strings.Contains(f.file(), "- [>] Tomorrow, really uid:t") {
+		t.Errorf("pushed state lost:\n%s", f.file())
+	}
+}
+
+func TestSync_Errors(t *testing.T) {
+	f := newFixture(t, ":td\n- [ ] A uid:a\n:td\n")
+	f.syncer.Client.Password = "wrong"
+	blocks, _ := parser.ExtractTdBlocks(f.path)
+	if _, err := f.syncer.Sync(parser.ParseTodos(blocks), control.Direct); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected an auth error, got %v", err)
+	}
+	if len(f.srv.Names()) != 0 {
+		t.Error("nothing should be pushed without credentials")
+	}
+}
@@ -0,0 +1,178 @@
+// Package caldavtest provides an in-memory CalDAV collection for tests. It
+// implements just enough of RFC 4791 for package caldav: calendar-query
+// REPORTs returning every object, and GET, PUT and DELETE with ETag
+// preconditions and optional basic auth.
+package caldavtest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Server is a fake CalDAV server with a single collection at /tasks/.
+type Server struct {
+	*httptest.Server
+	// Username and Password, if set, are required on every request.
+	Username, Password string
+
+	mu      sync.Mutex
+	objects map[string]object // by href
+	version int
+}
+
+type object struct {
+	data string
+	etag string
+}
+
+// NewServer starts a server. Call Close when done.
+func NewServer() *Server {
+	s := &Server{objects: map[string]object{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// CollectionURL is the URL to give caldav.Client.
+func (s *Server) CollectionURL() string {
+	return s.URL + "/tasks/"
+}
+
+// Put stores an object directly, as another client would, and returns its
+// new ETag. name is relative to the collection.
+func (s *Server) Put(name, data string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store("/tasks/"+name, data)
+}
+
+// Get returns the object called name, if it exists.
+func (s *Server) Get(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects["/tasks/"+name]
+	return obj.data, ok
+}
+
+// Delete removes the object called name, as another client would.
+func (s *Server) Delete(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, "/tasks/"+name)
+}
+
+// Names lists the objects in the collection, sorted.
+func (s *Server) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for href := range s.objects {
+		names = append(names, strings.TrimPrefix(href, "/tasks/"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Server) store(href, data string) string {
+	s.version++
+	etag := fmt.Sprintf(`"%d"`, s.version)
+	s.objects[href] = object{data: data, etag: etag}
+	return etag
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if s.Username != "" || s.Password != "" {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != s.Username || pass != s.Password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="caldavtest"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if !strings.HasPrefix(r.URL.Path, "/tasks/") {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	href := r.URL.Path
+	obj, exists := s.objects[href]
+	switch r.Method {
+	case "REPORT":
+		if href != "/tasks/" {
+			http.Error(w, "REPORT only on the collection", http.StatusBadRequest)
+			return
+		}
+		s.report(w)
+	case http.MethodGet:
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", obj.etag)
+		w.Header().Set("Content-Type", "text/calendar")
+		fmt.Fprint(w, obj.data)
+	case http.MethodPut:
+		if m := r.Header.Get("If-Match"); m != "" && (!exists || m != obj.etag) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("ETag", s.store(href, string(body)))
+		if exists {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
+	case http.MethodDelete:
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		if m := r.Header.Get("If-Match"); m != "" && m != obj.etag {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		delete(s.objects, href)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) report(w http.ResponseWriter) {
+	hrefs := make([]string, 0, len(s.objects))
+	for href := range s.objects {
+		hrefs = append(hrefs, href)
+	}
+	sort.Strings(hrefs)
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n")
+	fmt.Fprint(w, `<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">`)
+	for _, href := range hrefs {
+		obj := s.objects[href]
+		fmt.Fprintf(w, "<d:response><d:href>%s</d:href><d:propstat><d:prop><d:getetag>%s</d:getetag><c:calendar-data>%s</c:calendar-data></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>",
+			escape(href), escape(obj.etag), escape(obj.data))
+	}
+	fmt.Fprint(w, "</d:multistatus>")
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
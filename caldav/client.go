@@ -0,0 +1,176 @@
+// Package caldav syncs a todo file with a task list on a CalDAV server
+// (RFC 4791). Each todo is stored on the server as its own calendar object
+// holding one VTODO, named after the todo's uid: field; see package ical for
+// how todos map to VTODOs.
+package caldav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrPrecondition is returned when an If-Match or If-None-Match condition
+// fails, i.e. the object changed on the server since it was listed.
+var ErrPrecondition = errors.New("caldav: object changed on the server")
+
+// Client talks to one calendar collection.
+type Client struct {
+	// URL is the collection URL, e.g. https://dav.example.com/cal/tasks/.
+	URL      string
+	Username string
+	Password string
+	HTTP     *http.Client
+}
+
+// Object is a calendar object resource.
+type Object struct {
+	Href string // absolute path on the server
+	ETag string
+	Data string // iCalendar text
+}
+
+func (c *Client) do(method, target string, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	client := c.HTTP
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		resp.Body.Close()
+		return nil, ErrPrecondition
+	case resp.StatusCode >= 300:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("caldav: %s %s: %s %s", method, target, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// resolve turns an href from the server into an absolute URL.
+func (c *Client) resolve(href string) (string, error) {
+	base, err := url.Parse(c.URL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// Href returns the object path for a todo UID within the collection.
+func (c *Client) Href(uid string) (string, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(u.Path, "/") + "/" + url.PathEscape(uid) + ".ics", nil
+}
+
+const queryBody = `<?xml version="1.0" encoding="utf-8"?>
+<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:prop><d:getetag/><c:calendar-data/></d:prop>
+  <c:filter><c:comp-filter name="VCALENDAR"><c:comp-filter name="VTODO"/></c:comp-filter></c:filter>
+</c:calendar-query>`
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ETag string `xml:"DAV: getetag"`
+				Data string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// List returns every object in the collection that contains a VTODO.
+func (c *Client) List() ([]Object, error) {
+	resp, err := c.do("REPORT", c.URL, []byte(queryBody), http.Header{
+		"Depth":        {"1"},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("caldav: bad REPORT response: %w", err)
+	}
+	var out []Object
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") || ps.Prop.Data == "" {
+				continue
+			}
+			out = append(out, Object{Href: r.Href, ETag: ps.Prop.ETag, Data: ps.Prop.Data})
+		}
+	}
+	return out, nil
+}
+
+// Put stores data at href. An empty etag creates the object and fails with
+// ErrPrecondition if it already exists; otherwise the object must still have
+// that ETag. It returns the new ETag, which may be empty if the server does
+// not report one.
+func (c *Client) Put(href, data, etag string) (string, error) {
+	target, err := c.resolve(href)
+	if err != nil {
+		return "", err
+	}
+	header := http.Header{"Content-Type": {"text/calendar; charset=utf-8"}}
+	if etag == "" {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", etag)
+	}
+	resp, err := c.do(http.MethodPut, target, []byte(data), header)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+// Delete removes the object at href if it still has etag.
+func (c *Client) Delete(href, etag string) error {
+	target, err := c.resolve(href)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-Match", etag)
+	}
+	resp, err := c.do(http.MethodDelete, target, nil, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,349 @@
+package caldav
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"td-file/config"
+	"td-file/control"
+	"td-file/ical"
+	"td-file/parser"
+)
+
+// Conflict policies: which side wins a field changed on both sides since the
+// last sync.
+const (
+	ConflictLocal  = "local"
+	ConflictRemote = "remote"
+)
+
+// Syncer reconciles one todo file with one collection.
+//
+// It remembers, per UID, the object's href and ETag and the VTODO as of the
+// last sync. Comparing both sides against that base tells which side changed:
+//
+//   - changed on one side only: the change is copied to the other side;
+//   - changed on both: fields changed on one side are merged, and fields
+//     changed on both are decided by Conflict;
+//   - deleted on one side and unchanged on the other: deleted on both;
+//   - deleted on one side and changed on the other: the change wins and the
+//     todo is recreated;
+//   - new on either side: copied to the other.
+type Syncer struct {
+	Client *Client
+	// Path is the todo file; StatePath stores the sync state between runs.
+	Path      string
+	StatePath string
+	Conflict  string
+	// Block is the 0-based :td block that todos created on the server are
+	// added to.
+	Block int
+}
+
+// Report says what a sync did.
+type Report struct {
+	Pulled, Pushed              int
+	DeletedLocal, DeletedRemote int
+	Conflicts                   int
+	// Deferred counts server objects that changed while syncing; the next
+	// sync picks them up.
+	Deferred int
+	// Local lists the changes made to the todo file, for firing hooks.
+	Local   ical.Result
+	Deleted []parser.Todo
+}
+
+func (r Report) String() string {
+	s := fmt.Sprintf("pulled %d, pushed %d, deleted %d locally and %d remotely", r.Pulled, r.Pushed, r.DeletedLocal, r.DeletedRemote)
+	if r.Conflicts > 0 {
+		s += fmt.Sprintf(", %d conflict(s) resolved", r.Conflicts)
+	}
+	if r.Deferred > 0 {
+		s += fmt.Sprintf(", %d deferred to the next sync", r.Deferred)
+	}
+	return s
+}
+
+// entry is the sync state of one UID.
+type entry struct {
+	Href string
+	ETag string
+	Todo ical.VTodo
+}
+
+// StatePath returns where the sync state for a todo file is kept, under
+// config.StateDir.
+func StatePath(todoPath string) (string, error) {
+	return config.StateFile("caldav", todoPath)
+}
+
+func (s *Syncer) load() (map[string]entry, error) {
+	base := map[string]entry{}
+	data, err := os.ReadFile(s.StatePath)
+	if os.IsNotExist(err) {
+		return base, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &base); err != nil {
+		return nil, fmt.Errorf("caldav: corrupt sync state %s: %w", s.StatePath, err)
+	}
+	return base, nil
+}
+
+func (s *Syncer) save(next map[string]entry) error {
+	data, err := json.MarshalIndent(next, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.StatePath), 0700); err != nil {
+		return err
+	}
+	tmp := s.StatePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.StatePath)
+}
+
+type remoteTodo struct {
+	obj  Object
+	todo ical.VTodo
+}
+
+type push struct {
+	todo       ical.VTodo
+	href, etag string
+	base       *entry // restored if the push fails
+}
+
+// Sync reconciles todos (the current contents of s.Path) with the server.
+// Local changes are made through apply as a single batch, before anything
+// is written to the server.
+func (s *Syncer) Sync(todos []parser.Todo, apply control.Applier) (Report, error) {
+	var rep Report
+	base, err := s.load()
+	if err != nil {
+		return rep, err
+	}
+	if req, ok := ical.AssignUIDs(s.Path, todos); ok {
+		if err := apply(req); err != nil {
+			return rep, err
+		}
+		if todos, err = control.Apply(todos, req); err != nil {
+			return rep, err
+		}
+	}
+
+	objects, err := s.Client.List()
+	if err != nil {
+		return rep, err
+	}
+	remote := map[string]remoteTodo{}
+	var order []string
+	for _, obj := range objects {
+		items, err := ical.Decode(strings.NewReader(obj.Data))
+		if err != nil || len(items) == 0 {
+			continue // not ours to understand; leave it alone
+		}
+		if _, dup := remote[items[0].UID]; !dup {
+			order = append(order, items[0].UID)
+		}
+		remote[items[0].UID] = remoteTodo{obj: obj, todo: items[0]}
+	}
+	local := map[string]ical.VTodo{}
+	for _, v := range ical.FromTodos(todos) {
+		local[v.UID] = v
+	}
+
+	next := map[string]entry{}
+	var pull []ical.VTodo
+	var pushes []push
+	var deleteLocal []string
+	var deleteRemote []entry
+	var errs []error
+
+	visit := func(uid string) {
+		l, hasL := local[uid]
+		r, hasR := remote[uid]
+		b, hasB := base[uid]
+		if hasR && hasB && r.todo.State == "" && r.todo.Status == b.Todo.Status {
+			// The server dropped X-TD-FILE-STATE; that is not an edit.
+			r.todo.State = b.Todo.State
+		}
+		switch {
+		case hasL && hasR:
+			lChanged := !hasB || l != b.Todo
+			rChanged := !hasB || r.obj.ETag != b.ETag
+			merged := l
+			switch {
+			case lChanged && rChanged:
+				var conflicts int
+				merged, conflicts = s.merge(b.Todo, l, r.todo, hasB)
+				if conflicts > 0 {
+					rep.Conflicts++
+				}
+			case rChanged:
+				merged = r.todo
+				merged.RelatedTo = l.RelatedTo // nesting is edited locally
+			}
+			if merged != l {
+				pull = append(pull, merged)
+				rep.Pulled++
+			}
+			if merged != r.todo {
+				pushes = append(pushes, push{todo: merged, href: r.obj.Href, etag: r.obj.ETag, base: entryPtr(b, hasB)})
+				rep.Pushed++
+			} else {
+				next[uid] = entry{Href: r.obj.Href, ETag: r.obj.ETag, Todo: merged}
+			}
+		case hasL:
+			if hasB && l == b.Todo {
+				deleteLocal = append(deleteLocal, uid)
+				rep.DeletedLocal++
+				return
+			}
+			href, err := s.Client.Href(uid)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", uid, err))
+				if hasB {
+					next[uid] = b
+				}
+				return
+			}
+			pushes = append(pushes, push{todo: l, href: href})
+			rep.Pushed++
+		case hasR:
+			if hasB && r.obj.ETag == b.ETag {
+				deleteRemote = append(deleteRemote, entry{Href: r.obj.Href, ETag: r.obj.ETag, Todo: b.Todo})
+				rep.DeletedRemote++
+				return
+			}
+			pull = append(pull, r.todo)
+			rep.Pulled++
+			next[uid] = entry{Href: r.obj.Href, ETag: r.obj.ETag, Todo: r.todo}
+		}
+	}
+	seen := map[string]bool{}
+	for _, v := range ical.FromTodos(todos) {
+		seen[v.UID] = true
+		visit(v.UID)
+	}
+	for _, uid := range order {
+		if !seen[uid] {
+			visit(uid)
+		}
+	}
+
+	req, res := ical.Merge(s.Path, todos, pull, s.Block)
+	steps, deleted := deletions(todos, deleteLocal)
+	req.Requests = append(req.Requests, steps...)
+	if len(req.Requests) > 0 {
+		if err := apply(req); err != nil {
+			return rep, err
+		}
+	}
+	rep.Local, rep.Deleted = res, deleted
+
+	stamp := time.Now()
+	for _, p := range pushes {
+		var buf strings.Builder
+		ical.Encode(&buf, []ical.VTodo{p.todo}, stamp)
+		etag, err := s.Client.Put(p.href, buf.String(), p.etag)
+		if err == nil {
+			next[p.todo.UID] = entry{Href: p.href, ETag: etag, Todo: p.todo}
+			continue
+		}
+		rep.Pushed--
+		if p.base != nil {
+			next[p.todo.UID] = *p.base
+		}
+		if errors.Is(err, ErrPrecondition) {
+			rep.Deferred++
+		} else {
+			errs = append(errs, err)
+		}
+	}
+	for _, d := range deleteRemote {
+		if err := s.Client.Delete(d.Href, d.ETag); err != nil {
+			next[d.Todo.UID] = d
+			rep.DeletedRemote--
+			if errors.Is(err, ErrPrecondition) {
+				rep.Deferred++
+			} else {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if err := s.save(next); err != nil {
+		errs = append(errs, err)
+	}
+	return rep, errors.Join(errs...)
+}
+
+func entryPtr(e entry, ok bool) *entry {
+	if !ok {
+		return nil
+	}
+	return &e
+}
+
+// merge combines two edited versions of a todo field by field.
+func (s *Syncer) merge(base, l, r ical.VTodo, hasBase bool) (ical.VTodo, int) {
+	out := l
+	conflicts := 0
+	pick := func(lv, rv, bv any) bool {
+		lChanged, rChanged := !hasBase || lv != bv, !hasBase || rv != bv
+		switch {
+		case lv == rv || !rChanged:
+			return false
+		case !lChanged:
+			return true
+		}
+		conflicts++
+		return s.Conflict == ConflictRemote
+	}
+	if pick(l.Summary, r.Summary, base.Summary) {
+		out.Summary = r.Summary
+	}
+	if pick([2]string{l.Status, l.State}, [2]string{r.Status, r.State}, [2]string{base.Status, base.State}) {
+		out.Status, out.State = r.Status, r.State
+	}
+	if pick(l.Due, r.Due, base.Due) {
+		out.Due = r.Due
+	}
+	if pick(l.Priority, r.Priority, base.Priority) {
+		out.Priority = r.Priority
+	}
+	return out, conflicts
+}
+
+// deletions returns delete steps for the todos with the given UIDs, skipping
+// those inside a subtree that is already being deleted.
+func deletions(todos []parser.Todo, uids []string) ([]control.Request, []parser.Todo) {
+	doomed := map[string]bool{}
+	for _, uid := range uids {
+		doomed[uid] = true
+	}
+	var steps []control.Request
+	var deleted []parser.Todo
+	var walk func(nodes []*parser.Todo)
+	walk = func(nodes []*parser.Todo) {
+		for _, t := range nodes {
+			if doomed[parser.ParseMetadata(t.Text).Fields[ical.UIDField]] {
+				steps = append(steps, control.Request{Op: control.OpDelete, Line: t.LineNumber, Text: t.Text})
+				deleted = append(deleted, *t)
+				continue
+			}
+			walk(t.Children)
+		}
+	}
+	walk(parser.BuildTree(todos))
+	return steps, deleted
+}
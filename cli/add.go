@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"td-file/control"
+	"td-file/hooks"
+	"td-file/parser"
+)
+
+func runAdd(args []string, stdout io.Writer) error {
+	var path, state string
+	var parent, block int
+	var highlight bool
+	fs := newFlagSet("add", &path)
+	fs.IntVar(&parent, "parent", 0, "Add as the last child of the todo at this line")
+	fs.IntVar(&block, "block", 1, "Append to this :td block (1-based); ignored with --parent")
+	fs.StringVar(&state, "state", "incomplete", "Initial state: incomplete, completed, cancelled, pushed or any other registered state")
+	fs.BoolVar(&highlight, "highlight", false, "Highlight the new todo")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	text := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if text == "" || !parser.SingleLine(text) {
+		return fmt.Errorf("usage: td-file add [--parent LINE] [--block N] [--state STATE] [--highlight] TEXT")
+	}
+	s, err := parser.ParseState(state)
+	if err != nil {
+		return err
+	}
+	path, err = resolvePath(path)
+	if err != nil {
+		return err
+	}
+	if parent == 0 {
+		blocks, err := parser.ExtractTdBlocks(path)
+		if err != nil {
+			return err
+		}
+		if block < 1 || block > len(blocks) {
+			return fmt.Errorf("%s has %d :td block(s); --block %d is out of range", path, len(blocks), block)
+		}
+	}
+	todo := parser.Todo{Text: text, State: s, Highlighted: highlight}
+	h := newHooks()
+	defer h.Wait()
+	if err := routed(h)(control.Request{
+		Op:     control.OpAdd,
+		Path:   path,
+		Todos:  []parser.Todo{todo},
+		Block:  block - 1,
+		Parent: parent,
+	}); err != nil {
+		return err
+	}
+	h.Fire(hooks.Add, path, &todo)
+	fmt.Fprintf(stdout, "Added %s\n", parser.FormatTodo(todo))
+	return nil
+}
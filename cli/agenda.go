@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"td-file/agenda"
+	"td-file/config"
+	"td-file/hooks"
+	"td-file/output"
+	"td-file/parser"
+	"td-file/query"
+)
+
+type agendaItem struct {
+	Ref       string        `json:"ref"`
+	Todo      output.Record `json:"todo"`
+	Ancestors []string      `json:"ancestors"`
+}
+
+type agendaResult struct {
+	SchemaVersion int          `json:"schema_version"`
+	Items         []agendaItem `json:"items"`
+	Warnings      []string     `json:"warnings"`
+}
+
+func runAgenda(args []string, stdout io.Writer) error {
+	var path, format, since string
+	fs := newFlagSet("agenda", &path)
+	fs.StringVar(&format, "format", "text", "Output format: text or json")
+	fs.StringVar(&since, "since", "", "Only scan files dated on or after this date (e.g. -7d)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	files, err := queryFiles(path)
+	if err != nil {
+		return err
+	}
+	if since != "" {
+		from, err := query.ResolveDate(since, time.Now())
+		if err != nil {
+			return err
+		}
+		files = agenda.Since(files, from)
+	}
+	groups, warnings, err := agenda.Build(files)
+	if err != nil {
+		return err
+	}
+
+	switch action := fs.Arg(0); action {
+	case "":
+	case "complete", "pull":
+		if fs.NArg() != 2 {
+			return fmt.Errorf("usage: td-file agenda %s REF", action)
+		}
+		it, err := agenda.Find(groups, fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		h := newHooks()
+		defer h.Wait()
+		if action == "complete" {
+			done, err := agenda.Complete(it, routed(h))
+			if err != nil {
+				return err
+			}
+			h.StateChanged(it.File.Path, &done, it.Todo.State)
+			return nil
+		}
+		today, err := config.ResolveTodoPath(cfg)
+		if err != nil {
+			return err
+		}
+		pulled, err := agenda.Pull(it, today, routed(h))
+		if err != nil {
+			return err
+		}
+		pushed := it.Todo
+		pushed.State = parser.Pushed
+		h.StateChanged(it.File.Path, &pushed, it.Todo.State)
+		h.Fire(hooks.Add, today, &pulled)
+		return nil
+	default:
+		return fmt.Errorf("unknown agenda action %q (want complete or pull)", action)
+	}
+
+	switch format {
+	case "json":
+		result := agendaResult{SchemaVersion: output.SchemaVersion, Items: []agendaItem{}, Warnings: warnings}
+		if result.Warnings == nil {
+			result.Warnings = []string{}
+		}
+		for _, it := range agenda.Items(groups) {
+			t := it.Todo
+			rec := output.NewRecord(it.File.Path, it.File.Date, &t)
+			rec.Depth = len(it.Ancestors)
+			ancestors := it.Ancestors
+			if ancestors == nil {
+				ancestors = []string{}
+			}
+			result.Items = append(result.Items, agendaItem{Ref: it.Ref(), Todo: rec, Ancestors: ancestors})
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	case "text", "":
+		for i, g := range groups {
+			if i > 0 {
+				fmt.Fprintln(stdout)
+			}
+			fmt.Fprintln(stdout, g.Label())
+			for _, it := range g.Items {
+				text := it.Todo.Text
+				if it.Todo.Highlighted {
+					text += " *"
+				}
+				line := fmt.Sprintf("  %-16s - [%s] %s", it.Ref(), output.Marker(it.Todo.State.String()), text)
+				if ctx := it.Context(); ctx != "" {
+					line += "  (" + ctx + ")"
+				}
+				fmt.Fprintln(stdout, line)
+			}
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(stdout, "Warning: %s\n", w)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown format %q (want text or json)", format)
+}
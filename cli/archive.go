@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"td-file/archive"
+	"td-file/config"
+	"td-file/parser"
+)
+
+func runArchive(args []string, stdout io.Writer) error {
+	var path string
+	var line, olderThan int
+	fs := newFlagSet("archive", &path)
+	fs.IntVar(&line, "line", 0, "Archive the finished todos in the subtree of the todo at this line")
+	fs.IntVar(&olderThan, "older-than", -1, "Archive every finished todo completed more than this many days ago")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if (line == 0) == (olderThan < 0) || fs.NArg() > 0 {
+		return fmt.Errorf("usage: td-file archive --line LINE | --older-than DAYS")
+	}
+	path, err := resolvePath(path)
+	if err != nil {
+		return err
+	}
+	blocks, _, err := parser.ExtractBlocks(path)
+	if err != nil {
+		return err
+	}
+	roots := parser.BuildTree(parser.ParseTodos(parser.BlockLines(blocks)))
+	now := time.Now()
+	var nodes []*parser.Todo
+	if line != 0 {
+		n := parser.Find(roots, line)
+		if n == nil {
+			return fmt.Errorf("no todo at line %d", line)
+		}
+		nodes = archive.Finished([]*parser.Todo{n})
+	}
+	cfg := userConfig()
+	if olderThan >= 0 {
+		fileDate, _ := config.FileDate(cfg, path)
+		var undated int
+		nodes, undated = archive.OlderThan(roots, olderThan, now, fileDate)
+		if len(nodes) == 0 && undated > 0 {
+			return fmt.Errorf("%d finished todo(s) have no done: date to compare (enable done_dates, or use --line)", undated)
+		}
+	}
+	h := newHooks()
+	defer h.Wait()
+	if err := archive.Move(path, nodes, blocks, cfg, now, routed(h)); err != nil {
+		return err
+	}
+	target, _ := archive.Target(cfg, path)
+	fmt.Fprintf(stdout, "Archived %d todo(s) to %s\n", len(nodes), target)
+	return nil
+}
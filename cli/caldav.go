@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"td-file/caldav"
+	"td-file/hooks"
+)
+
+func runCalDAV(args []string, stdout io.Writer) error {
+	if len(args) == 0 || args[0] != "sync" {
+		return fmt.Errorf("usage: td-file caldav sync [--block N]")
+	}
+	var path string
+	var block int
+	fs := newFlagSet("caldav sync", &path)
+	fs.IntVar(&block, "block", 1, "Add todos created on the server to this :td block (1-based)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	cfg := userConfig()
+	if cfg == nil || cfg.CalDAV == nil || cfg.CalDAV.URL == "" {
+		return fmt.Errorf("caldav.url is not set in the config file")
+	}
+	switch cfg.CalDAV.Conflict {
+	case "", caldav.ConflictLocal, caldav.ConflictRemote:
+	default:
+		return fmt.Errorf("caldav.conflict must be %q or %q", caldav.ConflictLocal, caldav.ConflictRemote)
+	}
+	path, err := resolvePath(path)
+	if err != nil {
+		return err
+	}
+	todos, _, err := loadTodos(path)
+	if err != nil {
+		return err
+	}
+	state, err := caldav.StatePath(path)
+	if err != nil {
+		return err
+	}
+	user, pass := cfg.CalDAV.Credentials()
+	syncer := &caldav.Syncer{
+		Client:    &caldav.Client{URL: cfg.CalDAV.URL, Username: user, Password: pass},
+		Path:      path,
+		StatePath: state,
+		Conflict:  cfg.CalDAV.Conflict,
+		Block:     block - 1,
+	}
+	h := newHooks()
+	defer h.Wait()
+	rep, err := syncer.Sync(todos, routed(h))
+	for i := range rep.Local.States {
+		h.StateChanged(path, &rep.Local.States[i].Todo, rep.Local.States[i].Previous)
+	}
+	for i := range rep.Local.Added {
+		h.Fire(hooks.Add, path, &rep.Local.Added[i])
+	}
+	for i := range rep.Deleted {
+		h.Fire(hooks.Delete, path, &rep.Deleted[i])
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Synced %s: %s\n", path, rep)
+	return nil
+}
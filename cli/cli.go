@@ -0,0 +1,162 @@
+// Package cli implements the non-interactive td-file subcommands.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"td-file/config"
+	"td-file/control"
+	"td-file/hooks"
+	"td-file/parser"
+)
+
+type command struct {
+	usage string
+	run   func(args []string, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"add":     {"add [--parent LINE] [--block N] [--state STATE] [--highlight] TEXT  Add a todo, via the running TUI if there is one", runAdd},
+	"archive": {"archive --line LINE | --older-than DAYS  Move finished todos to the archive section or file", runArchive},
+	"agenda":  {"agenda [--since DATE] [--format text|json] [complete|pull REF]  List, complete or pull open work across daily files", runAgenda},
+	"caldav":  {"caldav sync [--block N]  Two-way sync with the CalDAV task list in the config", runCalDAV},
+	"export":  {"export --to FORMAT [-o FILE]  Export todos as todotxt, json, csv, html or markdown-checklist", runExport},
+	"ical":    {"ical export [-o FILE] [--assign-uids] | ical import [--block N] [--dry-run] FILE  Sync todos with iCalendar VTODOs", runICal},
+	"import":  {"import --from FORMAT [--block N] [--dry-run] FILE  Append tasks from todotxt, taskwarrior or markdown", runImport},
+	"list":    {"list [--format text|json|ndjson] [--hide-done]  Print the todos in the current file", runList},
+	"serve":   {"serve [--addr 127.0.0.1:PORT] [--token TOKEN]  Serve the todo file over a local HTTP/JSON API", runServe},
+	"query":   {"query [--format text|json|ndjson] EXPR  Search todos across all daily files", runQuery},
+}
+
+// IsCommand reports whether name is a known subcommand or an installed
+// plugin. Built-in names are checked first; the plugin directory is only
+// read for names that could be a plugin (see findPlugin).
+func IsCommand(name string) bool {
+	if _, ok := commands[name]; ok {
+		return true
+	}
+	_, ok := findPlugin(name)
+	return ok
+}
+
+// Run executes the named subcommand with the remaining arguments. Names that
+// are not built in are looked up in the plugin directory.
+func Run(name string, args []string, stdout io.Writer) error {
+	cmd, ok := commands[name]
+	if !ok {
+		p, found := findPlugin(name)
+		if !found {
+			return fmt.Errorf("unknown command %q", name)
+		}
+		cmd = command{run: func(args []string, stdout io.Writer) error {
+			return runPlugin(p, args, stdout)
+		}}
+	}
+	if cfg := userConfig(); cfg != nil {
+		if err := parser.Configure(cfg.ParserSettings()); err != nil {
+			return err
+		}
+	}
+	if err := cmd.run(args, stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return nil
+}
+
+// Usage writes a summary of the available subcommands to w.
+func Usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  td-file %s\n", commands[name].usage)
+	}
+	if found := installedPlugins(); len(found) > 0 {
+		fmt.Fprintln(w, "\nPlugins:")
+		for _, p := range found {
+			fmt.Fprintf(w, "  td-file %s [-f FILE] [-- ARGS]  (%s)\n", p.Name, p.Path)
+		}
+	}
+}
+
+// newFlagSet returns a flag set that understands the global -f/-todo-file
+// override, storing it in path.
+func newFlagSet(name string, path *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(path, "todo-file", "", "Path to todo file (overrides config)")
+	fs.StringVar(path, "f", "", "Path to todo file (shorthand, overrides config)")
+	return fs
+}
+
+// resolvePath returns the todo file to operate on: the flag override if set,
+// otherwise the path resolved from the user's config.
+func resolvePath(override string) (string, error) {
+	if override != "" {
+		return override, nil
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load config: %w", err)
+	}
+	return config.ResolveTodoPath(cfg)
+}
+
+// loadTodos reads and parses every :td block in path.
+func loadTodos(path string) ([]parser.Todo, []string, error) {
+	blocks, warnings, err := parser.ExtractTdBlocksWithWarnings(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	todos, warn2 := parser.ParseTodosWithWarnings(blocks)
+	return todos, append(warnings, warn2...), nil
+}
+
+// userConfig loads the user's config file without creating a default one;
+// it returns nil if there is none.
+func userConfig() *config.Config {
+	path, err := config.GetConfigPath()
+	if err != nil {
+		return nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil
+	}
+	return cfg
+}
+
+// newHooks returns the hook runner configured in the user's config file, if
+// there is one. Hook failures are printed to stderr.
+func newHooks() *hooks.Runner {
+	cfg := userConfig()
+	if cfg == nil {
+		return nil
+	}
+	return hooks.New(cfg.Hooks, func(err error) {
+		fmt.Fprintln(os.Stderr, "Warning:", err)
+	})
+}
+
+// routed returns the control.Applier used by subcommands that modify a todo
+// file: the change goes through a running TUI session if there is one, and
+// on_save fires when this process wrote the file itself.
+func routed(h *hooks.Runner) control.Applier {
+	return func(req control.Request) error {
+		handled, err := control.Route(req)
+		if err == nil && !handled {
+			h.Fire(hooks.Save, req.Path, nil)
+		}
+		return err
+	}
+}
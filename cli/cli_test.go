@@ -0,0 +1,379 @@
+package cli_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"td-file/caldav/caldavtest"
+	"td-file/cli"
+	"td-file/config"
+	"td-file/control"
+	"td-file/output"
+	"td-file/parser"
+)
+
+func writeTodoFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "todos.md")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+	return path
+}
+
+func TestList_JSON(t *testing.T) {
+	path := writeTodoFile(t, ":td\n- [ ] A\n  - [x] B\n:td\n")
+	var buf bytes.Buffer
+	if err := cli.Run("list", []string{"-f", path, "--format", "json"}, &buf); err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	var doc output.Document
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if doc.File != path || len(doc.Todos) != 1 || len(doc.Todos[0].Children) != 1 {
+		t.Errorf("unexpected document: %+v", doc)
+	}
+}
+
+func TestList_Text(t *testing.T) {
+	path := writeTodoFile(t, ":td\n- [ ] A\n  - [x] B\n:td\n")
+	var buf bytes.Buffer
+	if err := cli.Run("list", []string{"-f", path}, &buf); err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	want := "- [ ] A\n  - [x] B\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestList_HideDone(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	path := writeTodoFile(t, ":td\n- [ ] A\n  - [x] B\n- [>] C\n- [x] D\n  - [ ] E\n:td\n")
+	var buf bytes.Buffer
+	if err := cli.Run("list", []string{"-f", path, "--hide-done"}, &buf); err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	want := "- [ ] A\n- [x] D\n  - [ ] E\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	path := writeTodoFile(t, ":td\n- [ ] A\n  - [x] B done:2000-01-01\n- [x] C\n:td\n")
+	if err := cli.Run("archive", []string{"-f", path}, &bytes.Buffer{}); err == nil {
+		t.Error("archive needs --line or --older-than")
+	}
+	var buf bytes.Buffer
+	if err := cli.Run("archive", []string{"-f", path, "--older-than", "30"}, &buf); err != nil {
+		t.Fatalf("archive failed: %v", err)
+	}
+	want := ":td\n- [ ] A\n- [x] C\n:td\n\n## Archive\n\n- [x] B done:2000-01-01 (in A)\n"
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	err := cli.Run("archive", []string{"-f", path, "--older-than", "30"}, &buf)
+	if err == nil || !strings.Contains(err.Error(), "no done: date") {
+		t.Errorf("expected an error naming the undated todo, got %v", err)
+	}
+	if err := cli.Run("archive", []string{"-f", path, "--line", "2"}, &buf); err != nil {
+		t.Fatalf("archive --line failed: %v", err)
+	}
+	if got, _ := os.ReadFile(path); !strings.HasPrefix(string(got), ":td\n- [ ] A\n:td\n") || strings.Count(string(got), "- [x] C done:") != 1 {
+		t.Errorf("archive --line left:\n%s", got)
+	}
+}
+
+func TestRun_UnknownCommand(t *testing.T) {
+	if cli.IsCommand("nope") {
+		t.Error("expected nope not to be a command")
+	}
+	if err := cli.Run("nope", nil, &bytes.Buffer{}); err == nil {
+		t.Error("expected error for unknown command")
+	}
+}
+
+func TestQuery_AcrossDailyFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	if err := config.SaveConfig(&config.Config{BaseDir: dir, FilePattern: "todos-{YYYY-MM-DD}.md"}); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	files := map[string]string{
+		"todos-2024-06-01.md": ":td\n- [ ] Deploy #work\n- [x] Deploy docs #work\n:td\n",
+		"todos-2024-06-02.md": ":td\n- [ ] Groceries\n  - [ ] Deploy snacks\n:td\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := cli.Run("query", []string{"state:incomplete deploy"}, &buf); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	want := "2024-06-01:1  - [ ] Deploy #work\n2024-06-02:2  - [ ] Deploy snacks\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := cli.Run("query", []string{"--format", "json", "date:2024-06-02"}, &buf); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	var result struct {
+		Matches []output.Record `json:"matches"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(result.Matches) != 2 || result.Matches[1].Parent != 1 || result.Matches[1].Depth != 1 {
+		t.Errorf("unexpected matches: %+v", result.Matches)
+	}
+}
+
+func TestImport_DryRunAndWrite(t *testing.T) {
+	path := writeTodoFile(t, ":td\n- [ ] Existing\n:td\n\n:td\n- [ ] Second\n:td\n")
+	src := filepath.Join(t.TempDir(), "tasks.txt")
+	if err := os.WriteFile(src, []byte("(A) Imported +work\n"), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := cli.Run("import", []string{"-f", path, "--from", "todotxt", "--block", "2", "--dry-run", src}, &buf); err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "- [ ] Imported #work *") {
+		t.Errorf("dry run output missing todo: %q", buf.String())
+	}
+	unchanged, _ := os.ReadFile(path)
+	if strings.Contains(string(unchanged), "Imported") {
+		t.Fatalf("dry run modified the file: %q", unchanged)
+	}
+	if err := cli.Run("import", []string{"-f", path, "--from", "todotxt", "--block", "2", src}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	content, _ := os.ReadFile(path)
+	want := ":td\n- [ ] Existing\n:td\n\n:td\n- [ ] Second\n- [ ] Imported #work *\n:td\n"
+	if string(content) != want {
+		t.Errorf("got %q, want %q", content, want)
+	}
+	if err := cli.Run("import", []string{"-f", path, "--from", "todotxt", "--block", "3", src}, &bytes.Buffer{}); err == nil {
+		t.Error("expected error for out-of-range block")
+	}
+}
+
+func TestAdd(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	path := writeTodoFile(t, ":td\n- [ ] A\n:td\n")
+	if err := cli.Run("add", []string{"-f", path, "--parent", "1", "Child", "task"}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if err := cli.Run("add", []string{"-f", path, "--state", "done", "--highlight", "Root"}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	content, _ := os.ReadFile(path)
+	if want := ":td\n- [ ] A\n  - [ ] Child task\n- [x] Root *\n:td\n"; string(content) != want {
+		t.Errorf("got %q, want %q", content, want)
+	}
+
+	// With a session listening, the request is handed to it instead.
+	got := make(chan control.Request, 1)
+	ln, err := control.Listen(path, func(req control.Request) control.Response {
+		got <- req
+		return control.Response{Handled: true}
+	})
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	if err := cli.Run("add", []string{"-f", path, "Routed"}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("routed add failed: %v", err)
+	}
+	if req := <-got; req.Todos[0].Text != "Routed" {
+		t.Errorf("session received %+v", req)
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(content) {
+		t.Errorf("routed add should leave the file to the session, got %q", after)
+	}
+}
+
+func TestAdd_FiresHooks(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	out := filepath.Join(t.TempDir(), "events")
+	hooks := &config.HooksConfig{
+		OnAdd:  `echo "add $TD_FILE_TEXT" >> ` + out,
+		OnSave: `echo save >> ` + out,
+	}
+	if err := config.SaveConfig(&config.Config{FilePath: "unused.md", Hooks: hooks}); err != nil {
+		t.Fatalf("SaveConfig failed: %v", err)
+	}
+	path := writeTodoFile(t, ":td\n:td\n")
+	if err := cli.Run("add", []string{"-f", path, "Call", "bank"}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	if !strings.Contains(string(got), "add Call bank\n") || !strings.Contains(string(got), "save\n") {
+		t.Errorf("unexpected hook log %q", got)
+	}
+}
+
+func TestRun_Plugin(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	dir := t.TempDir()
+	script := "#!/bin/sh\n" +
+		`echo '{"mutations":[{"op":"add","line":1,"text":"'"$1"'"}],"panel":"added"}'` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "sub"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{FilePath: "unused.md", Plugins: &config.PluginConfig{Dir: dir}}
+	if err := config.SaveConfig(cfg); err != nil {
+		t.Fatalf("SaveConfig failed: %v", err)
+	}
+	if !cli.IsCommand("sub") || cli.IsCommand("missing") {
+		t.Fatal("plugin should be a command")
+	}
+	path := writeTodoFile(t, ":td\n- [ ] Parent\n:td\n")
+	if cli.IsCommand(path) || cli.IsCommand("./sub") {
+		t.Error("paths should never be plugins")
+	}
+	var out bytes.Buffer
+	if err := cli.Run("sub", []string{"-f", path, "Child"}, &out); err != nil {
+		t.Fatalf("plugin failed: %v", err)
+	}
+	if out.String() != "added\n" {
+		t.Errorf("panel not printed, got %q", out.String())
+	}
+	got, _ := os.ReadFile(path)
+	if string(got) != ":td\n- [ ] Parent\n  - [ ] Child\n:td\n" {
+		t.Errorf("unexpected file:\n%s", got)
+	}
+}
+
+func TestICal_ExportImport(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	path := writeTodoFile(t, ":td\n- [ ] Launch due:2024-06-07\n  - [ ] Book venue\n:td\n")
+	ics := filepath.Join(t.TempDir(), "todos.ics")
+	if err := cli.Run("ical", []string{"export", "-f", path, "-o", ics}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	original, _ := os.ReadFile(path)
+	if strings.Contains(string(original), "uid:") {
+		t.Fatalf("export should leave the file alone, got:\n%s", original)
+	}
+	first, _ := os.ReadFile(ics)
+	var again bytes.Buffer
+	if err := cli.Run("ical", []string{"export", "-f", path}, &again); err != nil {
+		t.Fatalf("second export failed: %v", err)
+	}
+	uids := func(s string) string {
+		var out []string
+		for _, line := range strings.Split(s, "\r\n") {
+			if strings.HasPrefix(line, "UID:") {
+				out = append(out, line)
+			}
+		}
+		return strings.Join(out, ",")
+	}
+	if uids(string(first)) != uids(again.String()) {
+		t.Errorf("UIDs changed between exports")
+	}
+
+	// Complete the child in the "calendar" and add a new task.
+	edited := strings.Replace(string(first), "SUMMARY:Book venue\r\nSTATUS:NEEDS-ACTION", "SUMMARY:Book venue\r\nSTATUS:COMPLETED", 1)
+	edited = strings.Replace(edited, "END:VCALENDAR", "BEGIN:VTODO\r\nUID:new-1\r\nSUMMARY:Order cake\r\nEND:VTODO\r\nEND:VCALENDAR", 1)
+	os.WriteFile(ics, []byte(edited), 0644)
+	var out bytes.Buffer
+	if err := cli.Run("ical", []string{"import", "-f", path, ics}, &out); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "1 added, 1 updated") {
+		t.Errorf("unexpected summary %q", out.String())
+	}
+	got, _ := os.ReadFile(path)
+	if !strings.Contains(string(got), "  - [x] Book venue\n") || !strings.Contains(string(got), "- [ ] Order cake uid:new-1\n:td") {
+		t.Errorf("unexpected file:\n%s", got)
+	}
+	out.Reset()
+	if err := cli.Run("ical", []string{"import", "-f", path, ics}, &out); err != nil || !strings.Contains(out.String(), "up to date") {
+		t.Errorf("re-import should be a no-op, got %q, %v", out.String(), err)
+	}
+
+	if err := cli.Run("ical", []string{"export", "-f", path, "--assign-uids"}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("export --assign-uids failed: %v", err)
+	}
+	if got, _ := os.ReadFile(path); strings.Count(string(got), "uid:") != 3 {
+		t.Errorf("--assign-uids should save UIDs, got:\n%s", got)
+	}
+}
+
+func TestCalDAV_Sync(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("MY_DAV_USER", "ann")
+	t.Setenv("MY_DAV_PASS", "secret")
+	srv := caldavtest.NewServer()
+	defer srv.Close()
+	srv.Username, srv.Password = "ann", "secret"
+	cfg := &config.Config{FilePath: "unused.md", CalDAV: &config.CalDAVConfig{
+		URL: srv.CollectionURL(), UsernameEnv: "MY_DAV_USER", PasswordEnv: "MY_DAV_PASS",
+	}}
+	if err := config.SaveConfig(cfg); err != nil {
+		t.Fatalf("SaveConfig failed: %v", err)
+	}
+	path := writeTodoFile(t, ":td\n- [ ] Local task uid:l1\n:td\n")
+	var out bytes.Buffer
+	if err := cli.Run("caldav", []string{"sync", "-f", path}, &out); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "pushed 1") {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	if _, ok := srv.Get("l1.ics"); !ok {
+		t.Errorf("todo not pushed, server has %v", srv.Names())
+	}
+	t.Setenv("MY_DAV_PASS", "")
+	if err := cli.Run("caldav", []string{"sync", "-f", path}, &out); err == nil {
+		t.Error("expected an auth error without a password")
+	}
+}
+
+func TestRun_MetadataSyntax(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	defer parser.Configure(parser.Settings{})
+	path := writeTodoFile(t, ":td\n- [ ] Ship it 📅 2024-06-07\n:td\n")
+
+	config.SaveConfig(&config.Config{FilePath: path, MetadataSyntax: "emoji"})
+	if err := cli.Run("list", []string{"-f", path}, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "metadata syntax") {
+		t.Errorf("expected a config error, got %v", err)
+	}
+
+	config.SaveConfig(&config.Config{FilePath: path, MetadataSyntax: "obsidian", DoneDates: true})
+	var out bytes.Buffer
+	if err := cli.Run("list", []string{"-f", path, "--format", "json"}, &out); err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	var doc output.Document
+	json.Unmarshal(out.Bytes(), &doc)
+	if len(doc.Todos) != 1 || doc.Todos[0].Fields["due"] != "2024-06-07" {
+		t.Errorf("emoji due date not exported: %+v", doc.Todos)
+	}
+	if err := control.Direct(control.Request{Op: control.OpSetState, Path: path, Line: 1, State: "completed"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); !strings.Contains(string(got), "📅 2024-06-07 ✅ ") {
+		t.Errorf("completion date not stamped:\n%s", got)
+	}
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"td-file/export"
+	"td-file/output"
+)
+
+func runExport(args []string, stdout io.Writer) error {
+	var path, to, out string
+	fs := newFlagSet("export", &path)
+	fs.StringVar(&to, "to", "", "Export format: "+strings.Join(export.Names(), ", "))
+	fs.StringVar(&out, "o", "", "Write to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if to == "" {
+		return fmt.Errorf("missing --to (want one of %s)", strings.Join(export.Names(), ", "))
+	}
+	exporter, err := export.Get(to)
+	if err != nil {
+		return err
+	}
+	path, err = resolvePath(path)
+	if err != nil {
+		return err
+	}
+	todos, warnings, err := loadTodos(path)
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, "Warning:", w)
+	}
+	doc := output.NewDocument(path, todos, warnings)
+	if out == "" {
+		return exporter.Export(stdout, doc)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := exporter.Export(f, doc); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
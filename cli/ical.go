@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"td-file/control"
+	"td-file/hooks"
+	"td-file/ical"
+	"td-file/parser"
+)
+
+func runICal(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: td-file ical export|import [flags]")
+	}
+	switch args[0] {
+	case "export":
+		return runICalExport(args[1:], stdout)
+	case "import":
+		return runICalImport(args[1:], stdout)
+	}
+	return fmt.Errorf("unknown ical action %q (want export or import)", args[0])
+}
+
+// runICalExport writes the file's todos as VTODOs without touching the
+// file. Todos without a uid: field get a derived UID (see ical.UIDs), or,
+// with --assign-uids, a random one that is saved to the file so that it
+// survives edits to the todo.
+func runICalExport(args []string, stdout io.Writer) error {
+	var path, out string
+	var assign bool
+	fs := newFlagSet("ical export", &path)
+	fs.StringVar(&out, "o", "", "Write to this file instead of stdout")
+	fs.BoolVar(&assign, "assign-uids", false, "Save a uid: field to todos that have none")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	path, err := resolvePath(path)
+	if err != nil {
+		return err
+	}
+	todos, warnings, err := loadTodos(path)
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, "Warning:", w)
+	}
+	if req, ok := ical.AssignUIDs(path, todos); ok && assign {
+		h := newHooks()
+		defer h.Wait()
+		if err := routed(h)(req); err != nil {
+			return err
+		}
+		if todos, err = control.Apply(todos, req); err != nil {
+			return err
+		}
+	}
+
+	items := ical.FromTodos(ical.WithUIDs(todos))
+	if out == "" {
+		return ical.Encode(stdout, items, time.Now())
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := ical.Encode(f, items, time.Now()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runICalImport updates todos from VTODOs with matching UIDs and adds the
+// rest.
+func runICalImport(args []string, stdout io.Writer) error {
+	var path string
+	var block int
+	var dryRun bool
+	fs := newFlagSet("ical import", &path)
+	fs.IntVar(&block, "block", 1, "Add new todos to this :td block of the target file (1-based)")
+	fs.BoolVar(&dryRun, "dry-run", false, "Show what would change without writing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: td-file ical import [--block N] [--dry-run] FILE.ics")
+	}
+	src, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	items, err := ical.Decode(src)
+	src.Close()
+	if err != nil {
+		return err
+	}
+
+	path, err = resolvePath(path)
+	if err != nil {
+		return err
+	}
+	todos, _, err := loadTodos(path)
+	if err != nil {
+		return err
+	}
+	blocks, err := parser.ExtractTdBlocks(path)
+	if err != nil {
+		return err
+	}
+	if block < 1 || block > len(blocks) {
+		return fmt.Errorf("%s has %d :td block(s); --block %d is out of range", path, len(blocks), block)
+	}
+
+	req, res := ical.Merge(path, todos, items, block-1)
+	summary := fmt.Sprintf("%d added, %d updated", len(res.Added), res.Updated)
+	if dryRun {
+		fmt.Fprintf(stdout, "Would apply to %s: %s\n", path, summary)
+		return nil
+	}
+	if len(req.Requests) == 0 {
+		fmt.Fprintf(stdout, "%s is up to date\n", path)
+		return nil
+	}
+	h := newHooks()
+	defer h.Wait()
+	if err := routed(h)(req); err != nil {
+		return err
+	}
+	for i := range res.States {
+		h.StateChanged(path, &res.States[i].Todo, res.States[i].Previous)
+	}
+	for i := range res.Added {
+		h.Fire(hooks.Add, path, &res.Added[i])
+	}
+	fmt.Fprintf(stdout, "Imported into %s: %s\n", path, summary)
+	return nil
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"td-file/control"
+	"td-file/hooks"
+	"td-file/importer"
+	"td-file/parser"
+)
+
+func runImport(args []string, stdout io.Writer) error {
+	var path, from string
+	var block int
+	var dryRun bool
+	fs := newFlagSet("import", &path)
+	fs.StringVar(&from, "from", "", "Import format: "+strings.Join(importer.Names(), ", "))
+	fs.IntVar(&block, "block", 1, "Append to this :td block of the target file (1-based)")
+	fs.BoolVar(&dryRun, "dry-run", false, "Show what would be inserted without writing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if from == "" || fs.NArg() != 1 {
+		return fmt.Errorf("usage: td-file import --from %s [--block N] [--dry-run] FILE", strings.Join(importer.Names(), "|"))
+	}
+	imp, err := importer.Get(from)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	imported, err := imp.Import(src)
+	src.Close()
+	if err != nil {
+		return err
+	}
+
+	path, err = resolvePath(path)
+	if err != nil {
+		return err
+	}
+	blocks, err := parser.ExtractTdBlocks(path)
+	if err != nil {
+		return err
+	}
+	if block < 1 || block > len(blocks) {
+		return fmt.Errorf("%s has %d :td block(s); --block %d is out of range", path, len(blocks), block)
+	}
+
+	if dryRun {
+		fmt.Fprintf(stdout, "Would append %d todo(s) to block %d of %s:\n", len(imported), block, path)
+		for _, t := range imported {
+			fmt.Fprintln(stdout, parser.FormatTodo(t))
+		}
+		return nil
+	}
+	h := newHooks()
+	defer h.Wait()
+	if err := routed(h)(control.Request{
+		Op:    control.OpAdd,
+		Path:  path,
+		Todos: imported,
+		Block: block - 1,
+	}); err != nil {
+		return err
+	}
+	for i := range imported {
+		h.Fire(hooks.Add, path, &imported[i])
+	}
+	fmt.Fprintf(stdout, "Imported %d todo(s) into block %d of %s\n", len(imported), block, path)
+	return nil
+}
@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"io"
+
+	"td-file/output"
+	"td-file/parser"
+)
+
+func runList(args []string, stdout io.Writer) error {
+	var path, format string
+	var hideDone bool
+	fs := newFlagSet("list", &path)
+	fs.StringVar(&format, "format", "text", "Output format: text, json or ndjson")
+	fs.BoolVar(&hideDone, "hide-done", false, "Leave out completed, cancelled and pushed todos (see hide_states)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	path, err := resolvePath(path)
+	if err != nil {
+		return err
+	}
+	todos, warnings, err := loadTodos(path)
+	if err != nil {
+		return err
+	}
+	if hideDone {
+		hidden, err := userConfig().Hidden()
+		if err != nil {
+			return err
+		}
+		todos = parser.Visible(todos, hidden)
+	}
+	return output.Write(stdout, format, output.NewDocument(path, todos, warnings))
+}
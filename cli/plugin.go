@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"td-file/config"
+	"td-file/plugins"
+)
+
+func installedPlugins() []plugins.Plugin {
+	dir, err := config.PluginDir(userConfig())
+	if err != nil {
+		return nil
+	}
+	found, _ := plugins.Discover(dir)
+	return found
+}
+
+// findPlugin looks name up in the plugin directory. Flags, paths and the
+// names of existing files are never plugins, so they are rejected without
+// loading the config.
+func findPlugin(name string) (plugins.Plugin, bool) {
+	if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, `/\`) {
+		return plugins.Plugin{}, false
+	}
+	if _, err := os.Stat(name); err == nil {
+		return plugins.Plugin{}, false
+	}
+	for _, p := range installedPlugins() {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return plugins.Plugin{}, false
+}
+
+// runPlugin invokes a plugin as a subcommand: its mutations are applied as a
+// single batch (through a running session if there is one) and its panel is
+// printed.
+func runPlugin(p plugins.Plugin, args []string, stdout io.Writer) error {
+	var path string
+	fs := newFlagSet(p.Name, &path)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	path, err := resolvePath(path)
+	if err != nil {
+		return err
+	}
+	todos, _, err := loadTodos(path)
+	if err != nil {
+		return err
+	}
+	resp, err := p.Run(plugins.NewRequest(plugins.InvokedByCommand, fs.Args(), path, todos, 0))
+	if err != nil {
+		return err
+	}
+	if len(resp.Mutations) > 0 {
+		req, err := resp.Request(path, todos)
+		if err != nil {
+			return fmt.Errorf("plugin %s: %w", p.Name, err)
+		}
+		h := newHooks()
+		defer h.Wait()
+		if err := routed(h)(req); err != nil {
+			return fmt.Errorf("plugin %s: %w", p.Name, err)
+		}
+	}
+	if resp.Panel != "" {
+		fmt.Fprintln(stdout, strings.TrimRight(resp.Panel, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"td-file/config"
+	"td-file/output"
+	"td-file/parser"
+	"td-file/query"
+)
+
+// queryResult is the JSON document emitted by `td-file query --format json`.
+type queryResult struct {
+	SchemaVersion int             `json:"schema_version"`
+	Query         string          `json:"query"`
+	Matches       []output.Record `json:"matches"`
+	Warnings      []string        `json:"warnings"`
+}
+
+func runQuery(args []string, stdout io.Writer) error {
+	var path, format string
+	fs := newFlagSet("query", &path)
+	fs.StringVar(&format, "format", "text", "Output format: text, json or ndjson")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	q, err := query.Parse(strings.Join(fs.Args(), " "))
+	if err != nil {
+		return err
+	}
+
+	files, err := queryFiles(path)
+	if err != nil {
+		return err
+	}
+	result := queryResult{SchemaVersion: output.SchemaVersion, Query: q.String(), Matches: []output.Record{}, Warnings: []string{}}
+	now := time.Now()
+	for _, f := range files {
+		todos, warnings, err := loadTodos(f.Path)
+		if err != nil {
+			return err
+		}
+		for _, w := range warnings {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s: %s", f.Path, w))
+		}
+		env := query.Env{Now: now, FileDate: f.Date}
+		for _, t := range query.Select(q, parser.BuildTree(todos), env) {
+			result.Matches = append(result.Matches, output.NewRecord(f.Path, f.Date, t))
+		}
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	case "ndjson":
+		enc := json.NewEncoder(stdout)
+		for _, r := range result.Matches {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "text", "":
+		for _, r := range result.Matches {
+			prefix := r.File
+			if r.Date != "" {
+				prefix = r.Date
+			}
+			text := r.Text
+			if r.Highlighted {
+				text += " *"
+			}
+			fmt.Fprintf(stdout, "%s:%d  - [%s] %s\n", prefix, r.Line, output.Marker(r.State), text)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown format %q (want one of %s)", format, strings.Join(output.Formats, ", "))
+}
+
+// queryFiles returns the files a query should scan: the -f override, or every
+// daily file matching the configured pattern.
+func queryFiles(override string) ([]config.DatedFile, error) {
+	if override != "" {
+		date, _ := config.FileDate(userConfig(), override)
+		return []config.DatedFile{{Path: override, Date: date}}, nil
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	return config.ListTodoFiles(cfg)
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"td-file/server"
+	"td-file/sync"
+)
+
+func runServe(args []string, stdout io.Writer) error {
+	var path, addr, token string
+	fs := newFlagSet("serve", &path)
+	fs.StringVar(&addr, "addr", "127.0.0.1:7878", "Address to listen on")
+	fs.StringVar(&token, "token", os.Getenv("TD_FILE_TOKEN"), "API token (default $TD_FILE_TOKEN, or a random token)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	path, err := resolvePath(path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	if token == "" {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		token = hex.EncodeToString(buf)
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			fmt.Fprintf(os.Stderr, "Warning: %s is not a loopback address; the API will be reachable from other machines\n", addr)
+		}
+	}
+
+	syncer := sync.NewFileSynchronizer(path)
+	if err := syncer.Start(); err != nil {
+		return err
+	}
+	defer syncer.Stop()
+
+	srv := server.New(path, token)
+	stop := make(chan struct{})
+	defer close(stop)
+	go srv.Watch(syncer.ReloadCh, stop)
+
+	httpServer := &http.Server{Addr: addr, Handler: srv.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		shutdown, done := context.WithTimeout(context.Background(), 2*time.Second)
+		defer done()
+		httpServer.Shutdown(shutdown)
+	}()
+
+	fmt.Fprintf(stdout, "Serving %s on http://%s\nToken: %s\n", path, addr, token)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
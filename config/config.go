@@ -1,21 +1,210 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
+	"td-file/parser"
+
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	FilePath    string `yaml:"file_path"`
-	FilePattern string `yaml:"file_pattern"`
-	BaseDir     string `yaml:"base_directory"`
+	FilePath    string        `yaml:"file_path"`
+	FilePattern string        `yaml:"file_pattern"`
+	BaseDir     string        `yaml:"base_directory"`
+	Git         *GitConfig    `yaml:"git,omitempty"`
+	Hooks       *HooksConfig  `yaml:"hooks,omitempty"`
+	Plugins     *PluginConfig `yaml:"plugins,omitempty"`
+	CalDAV      *CalDAVConfig `yaml:"caldav,omitempty"`
+	// MetadataSyntax is "plain" (key:value, the default) or "obsidian"
+	// (Obsidian Tasks emoji) for fields td-file adds to todo text.
+	MetadataSyntax string `yaml:"metadata_syntax,omitempty"`
+	// DoneDates records the completion date on todos when they are
+	// completed. It is off by default.
+	DoneDates bool `yaml:"done_dates,omitempty"`
+	// TabWidth is the number of columns a tab counts for when working out
+	// how deeply a todo is nested (default 4).
+	TabWidth int `yaml:"tab_width,omitempty"`
+	// States adds checkbox states, or changes the icon, key or style of
+	// existing ones; see parser.Configure.
+	States []parser.StateDef `yaml:"states,omitempty"`
+	// HideStates are the states hidden by H in the TUI and by
+	// --hide-done (default completed, cancelled and pushed).
+	HideStates []string `yaml:"hide_states,omitempty"`
+	// HideDone starts the TUI with those states hidden.
+	HideDone bool            `yaml:"hide_done,omitempty"`
+	Archive  *ArchiveConfig  `yaml:"archive,omitempty"`
+	Progress *ProgressConfig `yaml:"progress,omitempty"`
+	// Cascade spreads state changes made in the TUI to other todos in the
+	// tree; see parser.Cascade.
+	Cascade parser.Cascade `yaml:"cascade,omitempty"`
+}
+
+// ProgressConfig controls the [done/total] counts shown on parent todos.
+type ProgressConfig struct {
+	// Bar adds a small progress bar after the counts.
+	Bar bool `yaml:"bar"`
+	// Cancelled is how cancelled todos count: "exclude" (default), "done"
+	// or "open".
+	Cancelled string `yaml:"cancelled"`
+	// AutoComplete completes a parent once all of its children are done.
+	AutoComplete bool `yaml:"auto_complete"`
+	// ConfirmParent asks before completing a parent with open children.
+	ConfirmParent bool `yaml:"confirm_parent"`
+}
+
+// ArchiveConfig says where `td-file archive` and the TUI's X key move
+// finished todos.
+type ArchiveConfig struct {
+	// File is the archive file, relative to the todo file's directory;
+	// empty means a section at the end of the todo file itself.
+	File string `yaml:"file"`
+	// Heading names the archive section (default "Archive").
+	Heading string `yaml:"heading"`
 }
 
+// ParserSettings returns the settings to pass to parser.Configure.
+func (c *Config) ParserSettings() parser.Settings {
+	if c == nil {
+		return parser.Settings{}
+	}
+	return parser.Settings{Syntax: c.MetadataSyntax, StampDone: c.DoneDates, TabWidth: c.TabWidth, States: c.States}
+}
+
+// Hidden returns the states named by HideStates, or parser.DefaultHidden.
+// parser.Configure must have registered the states first.
+func (c *Config) Hidden() (map[parser.TodoState]bool, error) {
+	hidden := make(map[parser.TodoState]bool)
+	if c == nil || len(c.HideStates) == 0 {
+		for _, s := range parser.DefaultHidden {
+			hidden[s] = true
+		}
+		return hidden, nil
+	}
+	for _, name := range c.HideStates {
+		s, err := parser.ParseState(name)
+		if err != nil {
+			return nil, fmt.Errorf("hide_states: %w", err)
+		}
+		hidden[s] = true
+	}
+	return hidden, nil
+}
+
+// CalDAVConfig points `td-file caldav sync` at a task list on a CalDAV server.
+// Credentials are read from the environment, never from the config file.
+type CalDAVConfig struct {
+	// URL is the calendar collection holding the tasks.
+	URL string `yaml:"url"`
+	// UsernameEnv and PasswordEnv name the environment variables holding the
+	// credentials (default TD_FILE_CALDAV_USERNAME and
+	// TD_FILE_CALDAV_PASSWORD).
+	UsernameEnv string `yaml:"username_env"`
+	PasswordEnv string `yaml:"password_env"`
+	// Conflict decides which side wins when a field was changed both locally
+	// and on the server since the last sync: "local" (default) or "remote".
+	Conflict string `yaml:"conflict"`
+}
+
+// Credentials returns the CalDAV username and password from the environment.
+func (c *CalDAVConfig) Credentials() (username, password string) {
+	userEnv, passEnv := c.UsernameEnv, c.PasswordEnv
+	if userEnv == "" {
+		userEnv = "TD_FILE_CALDAV_USERNAME"
+	}
+	if passEnv == "" {
+		passEnv = "TD_FILE_CALDAV_PASSWORD"
+	}
+	return os.Getenv(userEnv), os.Getenv(passEnv)
+}
+
+// StateDir returns the directory for td-file's own bookkeeping (sync state
+// and the like): $XDG_STATE_HOME/td-file, or ~/.local/state/td-file.
+func StateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "td-file"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(home, ".local", "state", "td-file"), nil
+}
+
+// StateFile returns the file under StateDir/kind that holds kind's state
+// for the todo file at todoPath, named after a hash of its absolute path.
+func StateFile(kind, todoPath string) (string, error) {
+	abs, err := filepath.Abs(todoPath)
+	if err != nil {
+		return "", err
+	}
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(dir, kind, hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// PluginConfig locates external plugins and binds them to TUI keys.
+type PluginConfig struct {
+	// Dir holds plugin executables; defaults to a "plugins" directory next
+	// to the config file.
+	Dir string `yaml:"directory"`
+	// Keys maps a TUI key (as Bubbletea names it, e.g. "ctrl+t" or "T") to
+	// a plugin name.
+	Keys map[string]string `yaml:"keys"`
+}
+
+// PluginDir returns the directory searched for plugins.
+func PluginDir(cfg *Config) (string, error) {
+	if cfg != nil && cfg.Plugins != nil && cfg.Plugins.Dir != "" {
+		return cfg.Plugins.Dir, nil
+	}
+	path, err := GetConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "plugins"), nil
+}
+
+// HooksConfig names shell commands to run on todo lifecycle events. Each
+// command is run with `sh -c`, receives the event as JSON on stdin and in
+// TD_FILE_* environment variables; see package hooks.
+type HooksConfig struct {
+	OnComplete    string `yaml:"on_complete"`
+	OnAdd         string `yaml:"on_add"`
+	OnDelete      string `yaml:"on_delete"`
+	OnStateChange string `yaml:"on_state_change"`
+	OnSave        string `yaml:"on_save"`
+	// Timeout kills a hook that runs longer than this (default 10s).
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// GitConfig enables committing changes to base_directory when it is a git
+// repository.
+type GitConfig struct {
+	// AutoCommit commits changed files once no write has happened for
+	// QuietPeriod.
+	AutoCommit  bool          `yaml:"auto_commit"`
+	QuietPeriod time.Duration `yaml:"quiet_period"`
+	// PullOnStart runs `git pull --rebase` before the todo file is read.
+	PullOnStart bool `yaml:"pull_on_start"`
+	// Push pushes after each auto-commit.
+	Push bool `yaml:"push"`
+}
+
+// DefaultQuietPeriod is used when git.quiet_period is unset.
+const DefaultQuietPeriod = 30 * time.Second
+
 // GetConfigPath returns the path to the config file
 func GetConfigPath() (string, error) {
 	// Try XDG config directory first
@@ -113,3 +302,78 @@ func ResolveTodoPath(cfg *Config) (string, error) {
 	}
 	return "", fmt.Errorf("no file_path or file_pattern specified in config")
 }
+
+// DatedFile is a todo file discovered in BaseDir together with the date
+// encoded in its name by FilePattern.
+type DatedFile struct {
+	Path string
+	Date time.Time
+}
+
+// ListTodoFiles returns every file in cfg.BaseDir whose name matches
+// cfg.FilePattern, oldest first. When only FilePath is configured it returns
+// that single file with a zero date.
+func ListTodoFiles(cfg *Config) ([]DatedFile, error) {
+	if cfg.FilePattern == "" || !strings.Contains(cfg.FilePattern, "{YYYY-MM-DD}") {
+		path, err := ResolveTodoPath(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return []DatedFile{{Path: path}}, nil
+	}
+	re, err := datePattern(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dir := cfg.BaseDir
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read base directory: %w", err)
+	}
+	var files []DatedFile
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if date, ok := matchDate(re, e.Name()); ok {
+			files = append(files, DatedFile{Path: filepath.Join(dir, e.Name()), Date: date})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Date.Before(files[j].Date) })
+	return files, nil
+}
+
+// FileDate returns the date in the name of path if it is a daily file
+// matching cfg.FilePattern.
+func FileDate(cfg *Config, path string) (time.Time, bool) {
+	if cfg == nil || !strings.Contains(cfg.FilePattern, "{YYYY-MM-DD}") {
+		return time.Time{}, false
+	}
+	re, err := datePattern(cfg)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return matchDate(re, filepath.Base(path))
+}
+
+// datePattern compiles cfg.FilePattern into a regexp capturing the date.
+func datePattern(cfg *Config) (*regexp.Regexp, error) {
+	parts := strings.SplitN(cfg.FilePattern, "{YYYY-MM-DD}", 2)
+	re, err := regexp.Compile("^" + regexp.QuoteMeta(parts[0]) + `(\d{4}-\d{2}-\d{2})` + regexp.QuoteMeta(parts[1]) + "$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid file_pattern: %w", err)
+	}
+	return re, nil
+}
+
+func matchDate(re *regexp.Regexp, name string) (time.Time, bool) {
+	m := re.FindStringSubmatch(name)
+	if m == nil {
+		return time.Time{}, false
+	}
+	date, err := time.ParseInLocation("2006-01-02", m[1], time.Local)
+	return date, err == nil
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"td-file/config"
+	"td-file/parser"
 )
 
 func TestGetConfigPath(t *testing.T) {
@@ -147,3 +148,61 @@ func TestResolveTodoPath_Error(t *testing.T) {
 		t.Error("expected error for missing file_path and file_pattern, got nil")
 	}
 }
+
+func TestListTodoFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"todos-2024-06-02.md", "todos-2024-06-01.md", "todos-latest.md", "notes.md", "todos-2024-13-01.md"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	cfg := &config.Config{FilePattern: "todos-{YYYY-MM-DD}.md", BaseDir: dir}
+	files, err := config.ListTodoFiles(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files, got %+v", files)
+	}
+	if filepath.Base(files[0].Path) != "todos-2024-06-01.md" || files[0].Date.Format("2006-01-02") != "2024-06-01" {
+		t.Errorf("unexpected first file: %+v", files[0])
+	}
+}
+
+func TestFileDate(t *testing.T) {
+	cfg := &config.Config{FilePattern: "todos-{YYYY-MM-DD}.md"}
+	if d, ok := config.FileDate(cfg, "/notes/todos-2024-06-01.md"); !ok || d.Format("2006-01-02") != "2024-06-01" {
+		t.Errorf("FileDate = %v, %v", d, ok)
+	}
+	if _, ok := config.FileDate(cfg, "/notes/todos-latest.md"); ok {
+		t.Error("a file without a date should have none")
+	}
+	if _, ok := config.FileDate(&config.Config{FilePath: "todos-2024-06-01.md"}, "todos-2024-06-01.md"); ok {
+		t.Error("without a daily file_pattern no file has a date")
+	}
+}
+
+func TestListTodoFiles_FilePath(t *testing.T) {
+	files, err := config.ListTodoFiles(&config.Config{FilePath: "/foo/bar.md"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "/foo/bar.md" || !files[0].Date.IsZero() {
+		t.Errorf("unexpected files: %+v", files)
+	}
+}
+
+func TestHidden(t *testing.T) {
+	var cfg *config.Config
+	hidden, err := cfg.Hidden()
+	if err != nil || len(hidden) != 3 || !hidden[parser.Pushed] {
+		t.Errorf("default hidden states %v, err %v", hidden, err)
+	}
+	hidden, err = (&config.Config{HideStates: []string{"done"}}).Hidden()
+	if err != nil || len(hidden) != 1 || !hidden[parser.Completed] {
+		t.Errorf("configured hidden states %v, err %v", hidden, err)
+	}
+	if _, err := (&config.Config{HideStates: []string{"nope"}}).Hidden(); err == nil {
+		t.Error("an unknown state should be an error")
+	}
+}
@@ -0,0 +1,307 @@
+// Package control lets CLI invocations hand mutations to a running TUI
+// session instead of racing it for the file.
+//
+// A session listens on a Unix socket derived from the absolute path of the
+// todo file it is editing. Routed first tries that socket; if no session is
+// listening, or the session is showing a different file, it falls back to
+// Direct, a locked read-modify-write through sync.UpdateFile. Either way the
+// request is applied with Apply, so both paths behave identically.
+//
+// The wire protocol is one JSON Request per connection, answered by one JSON
+// Response.
+package control
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"td-file/parser"
+	"td-file/sync"
+)
+
+// Operations understood by Apply.
+const (
+	// OpAdd inserts Todos (IndentLevel relative to the insertion point) at the
+	// end of Block, or as the last children of the todo at Parent.
+	OpAdd = "add"
+	// OpSetState sets the state of the todo at Line, whose text must be Text
+	// unless Text is empty.
+	OpSetState = "set_state"
+	// OpSetText replaces the text of the todo at Line, which must be Old
+	// unless Old is empty, with Text.
+	OpSetText = "set_text"
+	// OpDelete removes the todo at Line and its descendants. The todo's text
+	// must be Text unless Text is empty.
+	OpDelete = "delete"
+	// OpBatch applies Requests in order, all or nothing. Lines refer to the
+	// list as it was before the batch: Apply keeps existing todos' line
+	// numbers and numbers added todos above all of them.
+	OpBatch = "batch"
+)
+
+// Request describes a single mutation of a todo file.
+type Request struct {
+	Op     string        `json:"op"`
+	Path   string        `json:"path"`
+	Todos  []parser.Todo `json:"todos,omitempty"`
+	Block  int           `json:"block,omitempty"`
+	Parent int           `json:"parent,omitempty"`
+	Line   int           `json:"line,omitempty"`
+	Text   string        `json:"text,omitempty"`
+	Old    string        `json:"old,omitempty"` // expected text for OpSetText
+	State  string        `json:"state,omitempty"`
+	// Requests holds the steps of an OpBatch.
+	Requests []Request `json:"requests,omitempty"`
+}
+
+// Response reports the outcome of a request sent to a session.
+type Response struct {
+	// Handled is false when the session is not editing the requested file;
+	// the caller should then write the file directly.
+	Handled bool   `json:"handled"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Applier performs a request against the todo file named in req.Path.
+type Applier func(req Request) error
+
+// timeout bounds how long a client waits for a session to answer.
+const timeout = 5 * time.Second
+
+// Apply performs req on a flat todo list and returns the new list.
+func Apply(todos []parser.Todo, req Request) ([]parser.Todo, error) {
+	switch req.Op {
+	case OpAdd:
+		if req.Parent == 0 {
+			return parser.InsertIntoBlock(todos, req.Todos, req.Block), nil
+		}
+		i, err := locate(todos, req.Parent, "")
+		if err != nil {
+			return nil, err
+		}
+		end := subtreeEnd(todos, i)
+		next := parser.NextLineNumber(todos)
+		out := append([]parser.Todo{}, todos[:end]...)
+		// Each new todo is nested with parser.Nest under the todo it belongs
+		// to, so that indentation and list markers follow the file's.
+		stack := []*parser.Todo{parser.Find(parser.BuildTree(todos), req.Parent)}
+		levels := []int{-1}
+		for j, t := range req.Todos {
+			for len(levels) > 1 && t.IndentLevel <= levels[len(levels)-1] {
+				stack, levels = stack[:len(stack)-1], levels[:len(levels)-1]
+			}
+			p := stack[len(stack)-1]
+			n := &parser.Todo{}
+			*n = t
+			n.Children = nil
+			parser.Nest(p, n)
+			n.Block = todos[i].Block
+			n.LineNumber = next + j
+			p.Children = append(p.Children, n)
+			stack, levels = append(stack, n), append(levels, t.IndentLevel)
+			out = append(out, *n)
+		}
+		return append(out, todos[end:]...), nil
+	case OpSetState:
+		state, err := parser.ParseState(req.State)
+		if err != nil {
+			return nil, err
+		}
+		i, err := locate(todos, req.Line, req.Text)
+		if err != nil {
+			return nil, err
+		}
+		out := append([]parser.Todo{}, todos...)
+		parser.SetState(&out[i], state)
+		return out, nil
+	case OpSetText:
+		if req.Text == "" || !parser.SingleLine(req.Text) {
+			return nil, fmt.Errorf("todo text must be a single non-empty line")
+		}
+		i, err := locate(todos, req.Line, req.Old)
+		if err != nil {
+			return nil, err
+		}
+		out := append([]parser.Todo{}, todos...)
+		out[i].Text = req.Text
+		return out, nil
+	case OpDelete:
+		i, err := locate(todos, req.Line, req.Text)
+		if err != nil {
+			return nil, err
+		}
+		end := subtreeEnd(todos, i)
+		return append(append([]parser.Todo{}, todos[:i]...), todos[end:]...), nil
+	case OpBatch:
+		for _, step := range req.Requests {
+			var err error
+			if todos, err = Apply(todos, step); err != nil {
+				return nil, err
+			}
+		}
+		return todos, nil
+	}
+	return nil, fmt.Errorf("unknown operation %q", req.Op)
+}
+
+// subtreeEnd returns the index just past the last descendant of todos[i].
+func subtreeEnd(todos []parser.Todo, i int) int {
+	end := i + 1
+	for end < len(todos) && todos[end].IndentLevel > todos[i].IndentLevel {
+		end++
+	}
+	return end
+}
+
+// locate finds the todo at line, optionally checking its text to detect that
+// the list has changed since the caller read it.
+func locate(todos []parser.Todo, line int, text string) (int, error) {
+	for i := range todos {
+		if todos[i].LineNumber == line {
+			if text != "" && todos[i].Text != text {
+				break
+			}
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("no todo at line %d (the file may have changed; reload and retry)", line)
+}
+
+// Direct applies req with a locked read-modify-write of req.Path.
+func Direct(req Request) error {
+	return sync.UpdateFile(req.Path, func(todos []parser.Todo) ([]parser.Todo, error) {
+		return Apply(todos, req)
+	})
+}
+
+// Routed sends req to a live session for req.Path if there is one, and
+// otherwise applies it directly.
+func Routed(req Request) error {
+	_, err := Route(req)
+	return err
+}
+
+// Route is Routed, also reporting whether a session handled the request
+// (true) or the file was written by this process (false).
+func Route(req Request) (bool, error) {
+	resp, err := Send(req)
+	if err == nil && resp.Handled {
+		if resp.Error != "" {
+			return true, errors.New(resp.Error)
+		}
+		return true, nil
+	}
+	return false, Direct(req)
+}
+
+// SocketPath returns the control socket for a todo file. Sockets live in
+// $XDG_RUNTIME_DIR/td-file, falling back to a per-user directory under the
+// system temp dir.
+func SocketPath(todoPath string) (string, error) {
+	abs, err := filepath.Abs(todoPath)
+	if err != nil {
+		return "", err
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir != "" {
+		dir = filepath.Join(dir, "td-file")
+	} else {
+		dir = filepath.Join(os.TempDir(), "td-file-"+strconv.Itoa(os.Getuid()))
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".sock"), nil
+}
+
+// Send delivers req to the session editing req.Path. It returns an error if
+// no session is listening. The path is made absolute first, since the session
+// may run in a different working directory.
+func Send(req Request) (Response, error) {
+	abs, err := filepath.Abs(req.Path)
+	if err != nil {
+		return Response{}, err
+	}
+	req.Path = abs
+	sock, err := SocketPath(abs)
+	if err != nil {
+		return Response{}, err
+	}
+	conn, err := net.DialTimeout("unix", sock, timeout)
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return Response{}, err
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return Response{}, err
+	}
+	return resp, nil
+}
+
+// Listener accepts requests for one todo file.
+type Listener struct {
+	ln   net.Listener
+	path string
+}
+
+// Listen starts accepting requests for todoPath, calling handle for each one
+// on its own goroutine. A stale socket left by a crashed session is replaced;
+// if another live session already owns the socket, Listen fails.
+func Listen(todoPath string, handle func(Request) Response) (*Listener, error) {
+	sock, err := SocketPath(todoPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(sock), 0700); err != nil {
+		return nil, err
+	}
+	if conn, err := net.Dial("unix", sock); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another td-file session is already editing %s", todoPath)
+	}
+	os.Remove(sock)
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{ln: ln, path: sock}
+	go l.serve(handle)
+	return l, nil
+}
+
+func (l *Listener) serve(handle func(Request) Response) {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(timeout))
+			var req Request
+			if err := json.NewDecoder(conn).Decode(&req); err != nil {
+				json.NewEncoder(conn).Encode(Response{Handled: true, Error: err.Error()})
+				return
+			}
+			json.NewEncoder(conn).Encode(handle(req))
+		}(conn)
+	}
+}
+
+// Close stops listening and removes the socket.
+func (l *Listener) Close() error {
+	err := l.ln.Close()
+	os.Remove(l.path)
+	return err
+}
@@ -0,0 +1,176 @@
+package control_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"td-file/control"
+	"td-file/parser"
+)
+
+func lines(todos []parser.Todo) []string {
+	var out []string
+	for _, t := range todos {
+		out = append(out, parser.FormatTodo(t))
+	}
+	return out
+}
+
+func TestApply(t *testing.T) {
+	todos := parser.ParseTodos([][]string{{"- [ ] A", "  - [ ] B", "- [ ] C"}, {"- [ ] D"}})
+
+	got, err := control.Apply(todos, control.Request{Op: control.OpAdd, Parent: 1, Todos: []parser.Todo{{Text: "New"}}})
+	if err != nil {
+		t.Fatalf("add child failed: %v", err)
+	}
+	if want := []string{"- [ ] A", "  - [ ] B", "  - [ ] New", "- [ ] C", "- [ ] D"}; !reflect.DeepEqual(lines(got), want) {
+		t.Errorf("add child = %q, want %q", lines(got), want)
+	}
+
+	got, err = control.Apply(todos, control.Request{Op: control.OpAdd, Block: 0, Todos: []parser.Todo{{Text: "Root"}}})
+	if err != nil {
+		t.Fatalf("add root failed: %v", err)
+	}
+	if want := []string{"- [ ] A", "  - [ ] B", "- [ ] C", "- [ ] Root", "- [ ] D"}; !reflect.DeepEqual(lines(got), want) {
+		t.Errorf("add root = %q, want %q", lines(got), want)
+	}
+
+	got, err = control.Apply(todos, control.Request{Op: control.OpSetState, Line: 2, Text: "B", State: "done"})
+	if err != nil {
+		t.Fatalf("set_state failed: %v", err)
+	}
+	if got[1].State != parser.Completed || todos[1].State != parser.Incomplete {
+		t.Errorf("expected a completed copy and an untouched original, got %v and %v", got[1].State, todos[1].State)
+	}
+
+	if _, err := control.Apply(todos, control.Request{Op: control.OpSetState, Line: 2, Text: "Stale", State: "done"}); err == nil {
+		t.Error("expected error when the text at the line has changed")
+	}
+	got, err = control.Apply(todos, control.Request{Op: control.OpSetText, Line: 3, Text: "C2"})
+	if err != nil || got[2].Text != "C2" {
+		t.Errorf("set_text = %v, %v", lines(got), err)
+	}
+	if _, err := control.Apply(todos, control.Request{Op: control.OpSetText, Line: 3, Old: "Stale", Text: "C2"}); err == nil {
+		t.Error("expected set_text to fail when the text at the line has changed")
+	}
+	if _, err := control.Apply(todos, control.Request{Op: control.OpDelete, Line: 1, Text: "Stale"}); err == nil {
+		t.Error("expected delete to fail when the text at the line has changed")
+	}
+	if _, err := control.Apply(todos, control.Request{Op: control.OpSetText, Line: 3, Text: "two\nlines"}); err == nil {
+		t.Error("expected error for multi-line text")
+	}
+	got, err = control.Apply(todos, control.Request{Op: control.OpDelete, Line: 1})
+	if want := []string{"- [ ] C", "- [ ] D"}; err != nil || !reflect.DeepEqual(lines(got), want) {
+		t.Errorf("delete = %q, %v; want %q", lines(got), err, want)
+	}
+
+	// New children follow the parent's indentation and marker.
+	tabbed := parser.ParseTodos([][]string{{"* [ ] A", "\t* [ ] B"}})
+	got, err = control.Apply(tabbed, control.Request{Op: control.OpAdd, Parent: 1, Todos: []parser.Todo{{Text: "New"}, {Text: "Sub", IndentLevel: 2}}})
+	if want := []string{"* [ ] A", "\t* [ ] B", "\t* [ ] New", "\t\t* [ ] Sub"}; err != nil || !reflect.DeepEqual(lines(got), want) {
+		t.Errorf("add to tabbed list = %q, %v; want %q", lines(got), err, want)
+	}
+
+	// Later steps of a batch still address the original todos after an add.
+	got, err = control.Apply(todos, control.Request{Op: control.OpBatch, Requests: []control.Request{
+		{Op: control.OpAdd, Parent: 1, Todos: []parser.Todo{{Text: "New"}}},
+		{Op: control.OpAdd, Block: 1, Todos: []parser.Todo{{Text: "E"}}},
+		{Op: control.OpSetText, Line: 3, Text: "C2"},
+		{Op: control.OpDelete, Line: 4},
+	}})
+	if want := []string{"- [ ] A", "  - [ ] B", "  - [ ] New", "- [ ] C2", "- [ ] E"}; err != nil || !reflect.DeepEqual(lines(got), want) {
+		t.Errorf("batch = %q, %v; want %q", lines(got), err, want)
+	}
+	seen := map[int]bool{}
+	for _, todo := range got {
+		if todo.LineNumber == 0 || seen[todo.LineNumber] {
+			t.Errorf("line number %d of %q is missing or taken", todo.LineNumber, todo.Text)
+		}
+		seen[todo.LineNumber] = true
+	}
+	if _, err := control.Apply(todos, control.Request{Op: "bogus"}); err == nil {
+		t.Error("expected error for unknown operation")
+	}
+}
+
+func TestRouted(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	path := filepath.Join(t.TempDir(), "todos.md")
+	if err := os.WriteFile(path, []byte(":td\n- [ ] A\n:td\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	add := control.Request{Op: control.OpAdd, Path: path, Todos: []parser.Todo{{Text: "B"}}}
+
+	// No session: written directly.
+	if err := control.Routed(add); err != nil {
+		t.Fatalf("direct add failed: %v", err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != ":td\n- [ ] A\n- [ ] B\n:td\n" {
+		t.Fatalf("unexpected file after direct add: %q", content)
+	}
+
+	// A live session receives the request instead.
+	got := make(chan control.Request, 1)
+	ln, err := control.Listen(path, func(req control.Request) control.Response {
+		got <- req
+		return control.Response{Handled: true}
+	})
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	if _, err := control.Listen(path, nil); err == nil {
+		t.Error("expected second Listen on the same file to fail")
+	}
+	if err := control.Routed(add); err != nil {
+		t.Fatalf("routed add failed: %v", err)
+	}
+	if req := <-got; req.Op != control.OpAdd || req.Todos[0].Text != "B" {
+		t.Errorf("session received %+v", req)
+	}
+	if content, _ := os.ReadFile(path); string(content) != ":td\n- [ ] A\n- [ ] B\n:td\n" {
+		t.Errorf("routed request should not touch the file, got %q", content)
+	}
+	ln.Close()
+
+	// A session that is editing another file declines, and the caller falls back.
+	ln, err = control.Listen(path, func(control.Request) control.Response { return control.Response{} })
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	if err := control.Routed(add); err != nil {
+		t.Fatalf("fallback add failed: %v", err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != ":td\n- [ ] A\n- [ ] B\n- [ ] B\n:td\n" {
+		t.Errorf("unexpected file after fallback: %q", content)
+	}
+}
+
+func TestListen_ReplacesStaleSocket(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	path := filepath.Join(t.TempDir(), "todos.md")
+	sock, err := control.SocketPath(path)
+	if err != nil {
+		t.Fatalf("SocketPath failed: %v", err)
+	}
+	os.MkdirAll(filepath.Dir(sock), 0700)
+	// Simulate a crashed session: a socket file with nobody listening.
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("failed to create socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := control.Listen(path, func(control.Request) control.Response { return control.Response{Handled: true} })
+	if err != nil {
+		t.Fatalf("Listen over a stale socket failed: %v", err)
+	}
+	defer ln.Close()
+	if resp, err := control.Send(control.Request{Op: control.OpAdd, Path: path}); err != nil || !resp.Handled {
+		t.Errorf("Send = %+v, %v", resp, err)
+	}
+}
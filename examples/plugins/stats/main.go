@@ -0,0 +1,97 @@
+// Command stats is a sample td-file plugin. Build it into the plugin
+// directory to try it:
+//
+//	go build -o ~/.config/td-file/plugins/stats ./examples/plugins/stats
+//
+// Bound to a key it shows a side panel with counts by state (for the
+// selected subtree, if any); `td-file stats` prints the same for the whole
+// file, and `td-file stats -- tidy` deletes every cancelled todo.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"td-file/output"
+	"td-file/plugins"
+)
+
+func main() {
+	var req plugins.Request
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		fmt.Fprintln(os.Stderr, "stats: bad request:", err)
+		os.Exit(1)
+	}
+	if req.Protocol != plugins.ProtocolVersion {
+		respond(plugins.Response{Error: fmt.Sprintf("unsupported protocol %d", req.Protocol)})
+		return
+	}
+	if len(req.Args) > 0 && req.Args[0] == "tidy" {
+		respond(tidy(req.Todos))
+		return
+	}
+
+	scope, title := req.Todos, "All todos"
+	if n := find(req.Todos, req.Cursor); n != nil {
+		scope, title = []output.Node{*n}, n.Text
+	}
+	counts := map[string]int{}
+	total := 0
+	walk(scope, func(n output.Node) {
+		counts[n.State]++
+		total++
+	})
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", title)
+	for _, state := range []string{"incomplete", "completed", "cancelled", "pushed"} {
+		fmt.Fprintf(&b, "%-11s %3d\n", state, counts[state])
+	}
+	if total > 0 {
+		fmt.Fprintf(&b, "done        %3d%%", 100*counts["completed"]/total)
+	}
+	respond(plugins.Response{Panel: b.String()})
+}
+
+// tidy deletes cancelled todos. Deleting a todo removes its subtree, so
+// cancelled todos below one are not listed again.
+func tidy(todos []output.Node) plugins.Response {
+	var resp plugins.Response
+	var visit func(nodes []output.Node)
+	visit = func(nodes []output.Node) {
+		for _, n := range nodes {
+			if n.State == "cancelled" {
+				resp.Mutations = append(resp.Mutations, plugins.Mutation{Op: "delete", Line: n.Line})
+				continue
+			}
+			visit(n.Children)
+		}
+	}
+	visit(todos)
+	resp.Panel = fmt.Sprintf("Removed %d cancelled todo(s)", len(resp.Mutations))
+	return resp
+}
+
+func walk(nodes []output.Node, fn func(output.Node)) {
+	for _, n := range nodes {
+		fn(n)
+		walk(n.Children, fn)
+	}
+}
+
+func find(nodes []output.Node, line int) *output.Node {
+	for i := range nodes {
+		if line != 0 && nodes[i].Line == line {
+			return &nodes[i]
+		}
+		if n := find(nodes[i].Children, line); n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+func respond(resp plugins.Response) {
+	json.NewEncoder(os.Stdout).Encode(resp)
+}
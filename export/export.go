@@ -0,0 +1,77 @@
+// Package export converts parsed todo trees into formats understood by other
+// tools. Each format is an Exporter registered by name; `td-file export --to
+// NAME` looks formats up in the registry, so adding a format is a matter of
+// implementing Exporter and calling Register from an init function.
+package export
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"td-file/output"
+)
+
+// Exporter writes a document in a particular format.
+type Exporter interface {
+	// Name is the value passed to --to.
+	Name() string
+	Export(w io.Writer, doc output.Document) error
+}
+
+var registry = map[string]Exporter{}
+
+// Register makes an exporter available by name. Registering the same name
+// twice panics.
+func Register(e Exporter) {
+	if _, dup := registry[e.Name()]; dup {
+		panic("export: duplicate exporter " + e.Name())
+	}
+	registry[e.Name()] = e
+}
+
+// Get returns the exporter registered under name.
+func Get(name string) (Exporter, error) {
+	e, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown export format %q (want one of %s)", name, strings.Join(Names(), ", "))
+	}
+	return e, nil
+}
+
+// Names lists the registered formats in alphabetical order.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// walk visits every node in document order along with its parent (nil for
+// roots).
+func walk(nodes []output.Node, parent *output.Node, fn func(n, parent *output.Node) error) error {
+	for i := range nodes {
+		n := &nodes[i]
+		if err := fn(n, parent); err != nil {
+			return err
+		}
+		if err := walk(n.Children, n, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedFields returns the keys of fields in alphabetical order so that output
+// is deterministic.
+func sortedFields(fields map[string]string) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
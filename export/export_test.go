@@ -0,0 +1,110 @@
+package export_test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"td-file/export"
+	"td-file/output"
+	"td-file/parser"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata/")
+
+var goldenExt = map[string]string{
+	"json":               "json",
+	"todotxt":            "txt",
+	"csv":                "csv",
+	"html":               "html",
+	"markdown-checklist": "md",
+}
+
+func sampleDoc(t *testing.T) output.Document {
+	t.Helper()
+	blocks, warnings, err := parser.ExtractTdBlocksWithWarnings("../test-todos.md")
+	if err != nil {
+		t.Fatalf("failed to read test-todos.md: %v", err)
+	}
+	todos, warn2 := parser.ParseTodosWithWarnings(blocks)
+	return output.NewDocument("test-todos.md", todos, append(warnings, warn2...))
+}
+
+func TestGolden(t *testing.T) {
+	doc := sampleDoc(t)
+	for _, name := range export.Names() {
+		t.Run(name, func(t *testing.T) {
+			ext, ok := goldenExt[name]
+			if !ok {
+				t.Fatalf("no golden file extension registered for %s", name)
+			}
+			e, err := export.Get(name)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := e.Export(&buf, doc); err != nil {
+				t.Fatalf("Export failed: %v", err)
+			}
+			golden := filepath.Join("testdata", "test-todos."+ext)
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+					t.Fatalf("failed to update golden file: %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file (run with -update to create): %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("%s output does not match %s:\n--- got ---\n%s\n--- want ---\n%s", name, golden, buf.String(), want)
+			}
+		})
+	}
+}
+
+func TestGet_Unknown(t *testing.T) {
+	if _, err := export.Get("pdf"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestTodoTxt_Metadata(t *testing.T) {
+	todos := parser.ParseTodos([][]string{{"- [ ] Ship #work due:2024-06-07 *", "- [-] Drop it"}})
+	var buf bytes.Buffer
+	e, _ := export.Get("todotxt")
+	if err := e.Export(&buf, output.NewDocument("x.md", todos, nil)); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	want := "(A) Ship +work due:2024-06-07\nDrop it status:cancelled\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestRegistryStates(t *testing.T) {
+	if err := parser.Configure(parser.Settings{States: []parser.StateDef{{Name: "snoozed", Marker: "z", Closed: true}}}); err != nil {
+		t.Fatal(err)
+	}
+	defer parser.Configure(parser.Settings{})
+	doc := output.NewDocument("x.md", parser.ParseTodos([][]string{{"- [/] Doing", "- [z] Nap"}}), nil)
+	for format, want := range map[string]string{
+		"todotxt":            "Doing status:in_progress\nNap status:snoozed\n",
+		"markdown-checklist": "- [ ] Doing _(in progress)_\n- [x] ~~Nap~~\n",
+	} {
+		var buf bytes.Buffer
+		e, _ := export.Get(format)
+		if err := e.Export(&buf, doc); err != nil || buf.String() != want {
+			t.Errorf("%s: got %q, %v; want %q", format, buf.String(), err, want)
+		}
+	}
+	var buf bytes.Buffer
+	e, _ := export.Get("html")
+	e.Export(&buf, doc)
+	if !strings.Contains(buf.String(), `<li class="snoozed closed">`) {
+		t.Errorf("html should mark closed custom states:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,219 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"td-file/output"
+	"td-file/parser"
+)
+
+func init() {
+	Register(jsonExporter{})
+	Register(todoTxtExporter{})
+	Register(csvExporter{})
+	Register(htmlExporter{})
+	Register(checklistExporter{})
+}
+
+// jsonExporter emits the same versioned document as `td-file list --format json`.
+type jsonExporter struct{}
+
+func (jsonExporter) Name() string { return "json" }
+
+func (jsonExporter) Export(w io.Writer, doc output.Document) error {
+	return output.Write(w, "json", doc)
+}
+
+// todoTxtExporter writes one line per todo in the todo.txt format.
+// Completed todos are prefixed with "x", highlighted todos get priority (A),
+// hashtags become +projects, and hierarchy is kept with id:/parent: fields
+// (using the todo's line number). Todos in any other state (cancelled,
+// pushed, in_progress, ...) carry a status: field since todo.txt has no
+// equivalent.
+type todoTxtExporter struct{}
+
+func (todoTxtExporter) Name() string { return "todotxt" }
+
+var hashtagRe = regexp.MustCompile(`(^|\s)#([\p{L}\p{N}_\-/.]+)`)
+
+func (todoTxtExporter) Export(w io.Writer, doc output.Document) error {
+	return walk(doc.Todos, nil, func(n, parent *output.Node) error {
+		var parts []string
+		state := stateOf(n)
+		switch state {
+		case parser.Completed:
+			parts = append(parts, "x")
+		case parser.Incomplete:
+			if n.Highlighted {
+				parts = append(parts, "(A)")
+			}
+		}
+		if text := strings.TrimSpace(hashtagRe.ReplaceAllString(n.Text, "$1+$2")); text != "" {
+			parts = append(parts, text)
+		}
+		if state != parser.Completed && state != parser.Incomplete {
+			parts = append(parts, "status:"+n.State)
+		}
+		if len(n.Children) > 0 {
+			parts = append(parts, "id:"+strconv.Itoa(n.Line))
+		}
+		if parent != nil {
+			parts = append(parts, "parent:"+strconv.Itoa(parent.Line))
+		}
+		_, err := fmt.Fprintln(w, strings.Join(parts, " "))
+		return err
+	})
+}
+
+// csvExporter writes a flat table with one row per todo.
+type csvExporter struct{}
+
+func (csvExporter) Name() string { return "csv" }
+
+func (csvExporter) Export(w io.Writer, doc output.Document) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"line", "parent", "depth", "block", "state", "highlighted", "text", "tags", "fields"}); err != nil {
+		return err
+	}
+	err := walk(doc.Todos, nil, func(n, parent *output.Node) error {
+		parentLine := ""
+		if parent != nil {
+			parentLine = strconv.Itoa(parent.Line)
+		}
+		var fields []string
+		for _, k := range sortedFields(n.Fields) {
+			fields = append(fields, k+"="+n.Fields[k])
+		}
+		return cw.Write([]string{
+			strconv.Itoa(n.Line),
+			parentLine,
+			strconv.Itoa(n.Depth),
+			strconv.Itoa(n.Block),
+			n.State,
+			strconv.FormatBool(n.Highlighted),
+			n.Text,
+			strings.Join(n.Tags, ";"),
+			strings.Join(fields, ";"),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// htmlExporter writes a standalone HTML page with nested lists.
+type htmlExporter struct{}
+
+func (htmlExporter) Name() string { return "html" }
+
+const htmlHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+ul { list-style: none; }
+li.closed > span { color: #888; }
+li.cancelled > span { color: #888; text-decoration: line-through; }
+li.pushed > span { color: #888; font-style: italic; }
+li.highlighted > span { font-weight: bold; color: #1e66f5; }
+</style>
+</head>
+<body>
+`
+
+func (htmlExporter) Export(w io.Writer, doc output.Document) error {
+	if _, err := fmt.Fprintf(w, htmlHeader, html.EscapeString(doc.File)); err != nil {
+		return err
+	}
+	var list func(nodes []output.Node, depth int) error
+	list = func(nodes []output.Node, depth int) error {
+		indent := strings.Repeat("  ", depth)
+		if _, err := fmt.Fprintf(w, "%s<ul>\n", indent); err != nil {
+			return err
+		}
+		for _, n := range nodes {
+			state := stateOf(&n)
+			class := n.State
+			if state.Closed() {
+				class += " closed"
+			}
+			if n.Highlighted {
+				class += " highlighted"
+			}
+			checked := ""
+			if state == parser.Completed {
+				checked = " checked"
+			}
+			if _, err := fmt.Fprintf(w, "%s  <li class=\"%s\"><input type=\"checkbox\" disabled%s> <span>%s</span>", indent, class, checked, html.EscapeString(n.Text)); err != nil {
+				return err
+			}
+			if len(n.Children) > 0 {
+				if _, err := fmt.Fprintln(w); err != nil {
+					return err
+				}
+				if err := list(n.Children, depth+2); err != nil {
+					return err
+				}
+				if _, err := fmt.Fprint(w, indent+"  "); err != nil {
+					return err
+				}
+			}
+			if _, err := fmt.Fprintln(w, "</li>"); err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintf(w, "%s</ul>\n", indent)
+		return err
+	}
+	if err := list(doc.Todos, 0); err != nil {
+		return err
+	}
+	_, err := fmt.Fprint(w, "</body>\n</html>\n")
+	return err
+}
+
+// checklistExporter writes a GitHub-flavoured markdown task list, which only
+// knows checked and unchecked items: todos in other closed states (such as
+// cancelled) are checked and struck through, other open states (such as
+// pushed) are annotated with their name, and highlighted todos are bold.
+type checklistExporter struct{}
+
+func (checklistExporter) Name() string { return "markdown-checklist" }
+
+func (checklistExporter) Export(w io.Writer, doc output.Document) error {
+	return walk(doc.Todos, nil, func(n, _ *output.Node) error {
+		box, text := " ", n.Text
+		switch state := stateOf(n); {
+		case state == parser.Completed:
+			box = "x"
+		case state.Closed():
+			box, text = "x", "~~"+text+"~~"
+		case state != parser.Incomplete:
+			text += " _(" + strings.ReplaceAll(n.State, "_", " ") + ")_"
+		}
+		if n.Highlighted {
+			text = "**" + text + "**"
+		}
+		_, err := fmt.Fprintf(w, "%s- [%s] %s\n", strings.Repeat("  ", n.Depth), box, text)
+		return err
+	})
+}
+
+// stateOf looks up n's state in the registry; unregistered names are
+// parser.Unknown.
+func stateOf(n *output.Node) parser.TodoState {
+	s, err := parser.ParseState(n.State)
+	if err != nil {
+		return parser.Unknown
+	}
+	return s
+}
@@ -0,0 +1,85 @@
+package gitsync
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"td-file/config"
+)
+
+// AutoCommitter commits a repository once writes have stopped for a quiet
+// period, so a burst of edits in the TUI becomes a single commit.
+type AutoCommitter struct {
+	repo   *Repo
+	quiet  time.Duration
+	push   bool
+	report func(error)
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	pending map[string]bool // files written since the last commit
+	running sync.Mutex // serialises commits from the timer and Flush
+}
+
+// NewAutoCommitter returns a committer for repo configured by cfg. Failures,
+// including pull conflicts while pushing, are passed to report.
+func NewAutoCommitter(repo *Repo, cfg *config.GitConfig, report func(error)) *AutoCommitter {
+	quiet := cfg.QuietPeriod
+	if quiet <= 0 {
+		quiet = config.DefaultQuietPeriod
+	}
+	return &AutoCommitter{repo: repo, quiet: quiet, push: cfg.Push, report: report}
+}
+
+// Notify records that the file at path changed and restarts the quiet
+// period. Only notified files are committed.
+func (a *AutoCommitter) Notify(path string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pending == nil {
+		a.pending = make(map[string]bool)
+	}
+	a.pending[path] = true
+	if a.timer == nil {
+		a.timer = time.AfterFunc(a.quiet, a.commit)
+	} else {
+		a.timer.Reset(a.quiet)
+	}
+}
+
+// Flush commits pending changes immediately, e.g. when the TUI exits.
+func (a *AutoCommitter) Flush() {
+	a.mu.Lock()
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	a.mu.Unlock()
+	a.commit()
+}
+
+func (a *AutoCommitter) commit() {
+	a.running.Lock()
+	defer a.running.Unlock()
+	a.mu.Lock()
+	var paths []string
+	for path := range a.pending {
+		paths = append(paths, path)
+	}
+	a.pending = nil
+	a.mu.Unlock()
+	if len(paths) == 0 {
+		return
+	}
+	sort.Strings(paths)
+	committed, err := a.repo.Commit(paths...)
+	if err != nil {
+		a.report(err)
+		return
+	}
+	if committed && a.push {
+		if err := a.repo.Push(); err != nil {
+			a.report(err)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// Package gitsync keeps a todo directory that lives in a git repository
+// committed and in step with its upstream. It drives the git command line,
+// so it works with whatever remotes and credentials the user already has.
+package gitsync
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"td-file/parser"
+)
+
+// Repo runs git commands in a directory inside a working tree.
+type Repo struct {
+	Dir string
+}
+
+// New returns a Repo for dir, which may be a subdirectory of the working
+// tree.
+func New(dir string) *Repo {
+	return &Repo{Dir: dir}
+}
+
+// ConflictError reports a pull that could not be rebased cleanly. The rebase
+// is aborted, so the working tree is left as it was before the pull.
+type ConflictError struct {
+	Files []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("git pull: conflict in %s; kept local version, resolve with git", strings.Join(e.Files, ", "))
+}
+
+func (r *Repo) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.Dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = strings.TrimSpace(stdout.String())
+		}
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return stdout.String(), nil
+}
+
+// Pull rebases local commits onto the upstream branch, stashing any
+// uncommitted changes around it. Conflicts are returned as *ConflictError.
+func (r *Repo) Pull() error {
+	_, err := r.git("pull", "--rebase", "--autostash", "-q")
+	if err == nil {
+		return nil
+	}
+	if files := r.conflicted(); len(files) > 0 {
+		r.git("rebase", "--abort")
+		return &ConflictError{Files: files}
+	}
+	return err
+}
+
+func (r *Repo) conflicted() []string {
+	out, err := r.git("diff", "--name-only", "--diff-filter=U")
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(out)
+}
+
+// Commit stages and commits the changes to the given todo files, leaving
+// anything else in the working tree alone, with a message summarising the
+// todo changes, e.g. "completed 3, added 1". Relative paths are taken from
+// the current directory. It reports whether a commit was made.
+func (r *Repo) Commit(paths ...string) (bool, error) {
+	if len(paths) == 0 {
+		return false, nil
+	}
+	pathspec := []string{"--"}
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return false, err
+		}
+		pathspec = append(pathspec, abs)
+	}
+	if _, err := r.git(append([]string{"add", "-A"}, pathspec...)...); err != nil {
+		return false, err
+	}
+	if _, err := r.git(append([]string{"diff", "--cached", "--quiet"}, pathspec...)...); err == nil {
+		return false, nil
+	}
+	msg, err := r.summary(pathspec)
+	if err != nil {
+		return false, err
+	}
+	if _, err := r.git(append([]string{"commit", "-q", "-m", msg}, pathspec...)...); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Push pushes the current branch. If the remote has moved on, it pulls
+// (rebasing the new commits) and tries once more.
+func (r *Repo) Push() error {
+	if _, err := r.git("push", "-q"); err == nil {
+		return nil
+	}
+	if err := r.Pull(); err != nil {
+		return err
+	}
+	_, err := r.git("push", "-q")
+	return err
+}
+
+// summary builds a commit message from the staged changes to pathspec.
+func (r *Repo) summary(pathspec []string) (string, error) {
+	out, err := r.git(append([]string{"diff", "--cached", "--name-only", "--relative", "-z"}, pathspec...)...)
+	if err != nil {
+		return "", err
+	}
+	var total Changes
+	var files []string
+	for _, name := range strings.Split(strings.TrimRight(out, "\x00"), "\x00") {
+		if name == "" {
+			continue
+		}
+		files = append(files, name)
+		// Missing revisions (new or deleted files) read as empty.
+		before, _ := r.todosAt("HEAD:./" + name)
+		after, _ := r.todosAt(":./" + name)
+		total = total.add(Diff(before, after))
+	}
+	return total.String() + "\n\n" + strings.Join(files, "\n"), nil
+}
+
+func (r *Repo) todosAt(rev string) ([]parser.Todo, error) {
+	content, err := r.git("show", rev)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := parser.ReadTdBlocks(strings.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	return parser.ParseTodos(blocks), nil
+}
+
+// Changes counts what happened to the todos between two versions of a file.
+type Changes struct {
+	Added, Removed int
+	// States counts todos moved into each state. A move back to incomplete
+	// is reported as reopened.
+	States map[parser.TodoState]int
+}
+
+func (c Changes) add(o Changes) Changes {
+	sum := Changes{Added: c.Added + o.Added, Removed: c.Removed + o.Removed}
+	for _, m := range []map[parser.TodoState]int{c.States, o.States} {
+		for s, n := range m {
+			sum.count(s, n)
+		}
+	}
+	return sum
+}
+
+func (c *Changes) count(s parser.TodoState, n int) {
+	if c.States == nil {
+		c.States = map[parser.TodoState]int{}
+	}
+	c.States[s] += n
+}
+
+// String renders the non-zero counts, e.g. "completed 3, added 1", or
+// "update todos" when no todo changed (e.g. only notes were edited).
+// Completions come first, then additions, the other states in registry
+// order, and removals.
+func (c Changes) String() string {
+	type count struct {
+		n    int
+		verb string
+	}
+	counts := []count{{c.States[parser.Completed], "completed"}, {c.Added, "added"}}
+	for i, def := range parser.States() {
+		switch s := parser.TodoState(i); s {
+		case parser.Completed:
+		case parser.Incomplete:
+			counts = append(counts, count{c.States[s], "reopened"})
+		default:
+			counts = append(counts, count{c.States[s], strings.ReplaceAll(def.Name, "_", " ")})
+		}
+	}
+	counts = append(counts, count{c.Removed, "removed"})
+	var parts []string
+	for _, p := range counts {
+		if p.n > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", p.verb, p.n))
+		}
+	}
+	if len(parts) == 0 {
+		return "update todos"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Diff compares two versions of a todo list. Todos are matched by text, so an
+// edited todo counts as one removed and one added.
+func Diff(before, after []parser.Todo) Changes {
+	old := map[string][]parser.TodoState{}
+	for _, t := range before {
+		old[t.Text] = append(old[t.Text], t.State)
+	}
+	var c Changes
+	for _, t := range after {
+		states := old[t.Text]
+		if len(states) == 0 {
+			c.Added++
+			continue
+		}
+		prev := states[0]
+		old[t.Text] = states[1:]
+		if prev == t.State {
+			continue
+		}
+		if t.State != parser.Unknown {
+			c.count(t.State, 1)
+		}
+	}
+	for _, states := range old {
+		c.Removed += len(states)
+	}
+	return c
+}
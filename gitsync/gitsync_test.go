@@ -0,0 +1,165 @@
+package gitsync_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"td-file/config"
+	"td-file/gitsync"
+	"td-file/parser"
+)
+
+func run(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func write(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+// setup creates a bare "remote" and two clones of it, a and b, that share a
+// todos.md. Everything is local; no network is used.
+func setup(t *testing.T) (a, b string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	tmp := t.TempDir()
+	t.Setenv("HOME", tmp)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	for _, k := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(k, "td-file test")
+	}
+	for _, k := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(k, "test@example.com")
+	}
+	remote := filepath.Join(tmp, "remote.git")
+	a, b = filepath.Join(tmp, "a"), filepath.Join(tmp, "b")
+	run(t, tmp, "init", "-q", "--bare", "-b", "main", remote)
+	run(t, tmp, "init", "-q", "-b", "main", a)
+	write(t, filepath.Join(a, "todos.md"), "# Today\n:td\n- [ ] A\n- [ ] B\n:td\n")
+	run(t, a, "add", ".")
+	run(t, a, "commit", "-q", "-m", "initial")
+	run(t, a, "remote", "add", "origin", remote)
+	run(t, a, "push", "-q", "-u", "origin", "main")
+	run(t, tmp, "clone", "-q", remote, b)
+	return a, b
+}
+
+func TestDiff(t *testing.T) {
+	before := parser.ParseTodos([][]string{{"- [ ] A", "- [ ] B", "- [x] C", "- [ ] D", "- [ ] E", "- [ ] Gone"}})
+	after := parser.ParseTodos([][]string{{"- [x] A", "- [x] B", "- [ ] C", "- [-] D", "- [/] E", "- [ ] New"}})
+	got := gitsync.Diff(before, after)
+	want := gitsync.Changes{Added: 1, Removed: 1, States: map[parser.TodoState]int{
+		parser.Completed: 2, parser.Incomplete: 1, parser.Cancelled: 1, parser.InProgress: 1,
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %+v, want %+v", got, want)
+	}
+	if s := got.String(); s != "completed 2, added 1, reopened 1, cancelled 1, in progress 1, removed 1" {
+		t.Errorf("String = %q", s)
+	}
+	if s := (gitsync.Changes{}).String(); s != "update todos" {
+		t.Errorf("empty String = %q", s)
+	}
+}
+
+func TestAutoCommitPushAndPull(t *testing.T) {
+	a, b := setup(t)
+	write(t, filepath.Join(a, "todos.md"), "# Today\n:td\n- [x] A\n- [ ] B\n- [ ] C\n:td\n")
+
+	var reported []error
+	ac := gitsync.NewAutoCommitter(gitsync.New(a), &config.GitConfig{AutoCommit: true, Push: true, QuietPeriod: 20 * time.Millisecond}, func(err error) {
+		reported = append(reported, err)
+	})
+	for i := 0; i < 3; i++ {
+		ac.Notify(filepath.Join(a, "todos.md"))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for run(t, a, "log", "-1", "--format=%s") != "completed 1, added 1" {
+		if time.Now().After(deadline) {
+			t.Fatalf("no auto-commit; log: %s", run(t, a, "log", "--format=%s"))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	ac.Flush()
+	if n := run(t, a, "rev-list", "--count", "HEAD"); n != "2" {
+		t.Errorf("expected one auto-commit for three notifications, got %s commits", n)
+	}
+	if len(reported) > 0 {
+		t.Fatalf("unexpected errors: %v", reported)
+	}
+
+	if err := gitsync.New(b).Pull(); err != nil {
+		t.Fatalf("Pull failed: %v", err)
+	}
+	content, _ := os.ReadFile(filepath.Join(b, "todos.md"))
+	if !strings.Contains(string(content), "- [x] A\n- [ ] B\n- [ ] C") {
+		t.Errorf("pull did not bring the pushed change: %q", content)
+	}
+}
+
+func TestCommit_OnlyGivenFiles(t *testing.T) {
+	a, _ := setup(t)
+	write(t, filepath.Join(a, "todos.md"), "# Today\n:td\n- [x] A\n- [ ] B\n:td\n")
+	write(t, filepath.Join(a, ".todos.md.lock"), "123\n")
+	write(t, filepath.Join(a, "notes.md"), "unrelated\n")
+	run(t, a, "add", "notes.md")
+	if ok, err := gitsync.New(a).Commit(filepath.Join(a, "todos.md")); !ok || err != nil {
+		t.Fatalf("Commit = %v, %v", ok, err)
+	}
+	if files := run(t, a, "show", "--name-only", "--format=", "HEAD"); files != "todos.md" {
+		t.Errorf("committed %q, want only todos.md", files)
+	}
+	if status := run(t, a, "status", "--porcelain"); status != "A  notes.md\n?? .todos.md.lock" {
+		t.Errorf("other files should be left as they were, status %q", status)
+	}
+}
+
+func TestPullConflict(t *testing.T) {
+	a, b := setup(t)
+	write(t, filepath.Join(a, "todos.md"), "# Today\n:td\n- [x] A\n- [ ] B\n:td\n")
+	if _, err := gitsync.New(a).Commit(filepath.Join(a, "todos.md")); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := gitsync.New(a).Push(); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	local := "# Today\n:td\n- [-] A\n- [ ] B\n:td\n"
+	write(t, filepath.Join(b, "todos.md"), local)
+	repo := gitsync.New(b)
+	if ok, err := repo.Commit(filepath.Join(b, "todos.md")); err != nil || !ok {
+		t.Fatalf("Commit = %v, %v", ok, err)
+	}
+	err := repo.Pull()
+	var conflict *gitsync.ConflictError
+	if !errors.As(err, &conflict) || !reflect.DeepEqual(conflict.Files, []string{"todos.md"}) {
+		t.Fatalf("expected conflict in todos.md, got %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(b, "todos.md")); string(content) != local {
+		t.Errorf("expected local version to be kept, got %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(b, ".git", "rebase-merge")); err == nil {
+		t.Error("rebase should have been aborted")
+	}
+	if ok, err := repo.Commit(filepath.Join(b, "todos.md")); err != nil || ok {
+		t.Errorf("expected nothing left to commit, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,172 @@
+// Package hooks runs user-configured shell commands when todos change.
+//
+// A hook is started with `sh -c COMMAND` in the background. It receives a
+// Payload as JSON on stdin and the same information in environment variables:
+//
+//	TD_FILE_EVENT           complete, add, delete, state_change or save
+//	TD_FILE_PATH            the todo file
+//	TD_FILE_TEXT            todo text (not set for save)
+//	TD_FILE_STATE           the state's registered name, e.g. completed or
+//	                        in_progress (see parser.StateDef)
+//	TD_FILE_PREVIOUS_STATE  the state before a state_change or complete
+//	TD_FILE_LINE            the todo's line within the file's :td blocks
+//
+// Hooks that fail or exceed their timeout are passed to the Runner's report
+// function; the TUI shows them as warnings.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"td-file/config"
+	"td-file/output"
+	"td-file/parser"
+)
+
+// Event names; the config key for each is "on_" + name.
+const (
+	Complete    = "complete"
+	Add         = "add"
+	Delete      = "delete"
+	StateChange = "state_change"
+	Save        = "save"
+)
+
+// DefaultTimeout applies when hooks.timeout is unset.
+const DefaultTimeout = 10 * time.Second
+
+// Payload is the JSON document written to a hook's stdin.
+type Payload struct {
+	SchemaVersion int            `json:"schema_version"`
+	Event         string         `json:"event"`
+	File          string         `json:"file"`
+	Todo          *output.Record `json:"todo,omitempty"`
+	PreviousState string         `json:"previous_state,omitempty"`
+}
+
+// Runner starts hooks. A nil *Runner is valid and runs nothing, so callers
+// need not check whether hooks are configured.
+type Runner struct {
+	cfg    config.HooksConfig
+	report func(error)
+	wg     sync.WaitGroup
+}
+
+// New returns a Runner for cfg, or nil if cfg is nil. report receives hook
+// failures and may be called from any goroutine.
+func New(cfg *config.HooksConfig, report func(error)) *Runner {
+	if cfg == nil {
+		return nil
+	}
+	if report == nil {
+		report = func(error) {}
+	}
+	return &Runner{cfg: *cfg, report: report}
+}
+
+func (r *Runner) command(event string) string {
+	switch event {
+	case Complete:
+		return r.cfg.OnComplete
+	case Add:
+		return r.cfg.OnAdd
+	case Delete:
+		return r.cfg.OnDelete
+	case StateChange:
+		return r.cfg.OnStateChange
+	case Save:
+		return r.cfg.OnSave
+	}
+	return ""
+}
+
+// Fire runs the hook for event, if one is configured. todo may be nil (for
+// save).
+func (r *Runner) Fire(event, file string, todo *parser.Todo) {
+	r.fire(Payload{Event: event, File: file}, todo)
+}
+
+// StateChanged fires state_change for a todo that moved from prev to its
+// current state, and also complete if it is now completed.
+func (r *Runner) StateChanged(file string, todo *parser.Todo, prev parser.TodoState) {
+	if r == nil || todo.State == prev {
+		return
+	}
+	r.fire(Payload{Event: StateChange, File: file, PreviousState: prev.String()}, todo)
+	if todo.State == parser.Completed {
+		r.fire(Payload{Event: Complete, File: file, PreviousState: prev.String()}, todo)
+	}
+}
+
+func (r *Runner) fire(p Payload, todo *parser.Todo) {
+	if r == nil {
+		return
+	}
+	cmdline := r.command(p.Event)
+	if cmdline == "" {
+		return
+	}
+	p.SchemaVersion = output.SchemaVersion
+	env := []string{"TD_FILE_EVENT=" + p.Event, "TD_FILE_PATH=" + p.File}
+	if todo != nil {
+		rec := output.NewRecord(p.File, time.Time{}, todo)
+		p.Todo = &rec
+		env = append(env,
+			"TD_FILE_TEXT="+todo.Text,
+			"TD_FILE_STATE="+rec.State,
+			"TD_FILE_LINE="+strconv.Itoa(todo.LineNumber),
+		)
+	}
+	if p.PreviousState != "" {
+		env = append(env, "TD_FILE_PREVIOUS_STATE="+p.PreviousState)
+	}
+	stdin, err := json.Marshal(p)
+	if err != nil {
+		r.report(err)
+		return
+	}
+	timeout := r.cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "sh", "-c", cmdline)
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdin = bytes.NewReader(stdin)
+		// Don't wait forever on background processes the hook left running.
+		cmd.WaitDelay = time.Second
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				err = fmt.Errorf("timed out after %s", timeout)
+			}
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				err = fmt.Errorf("%v: %s", err, msg)
+			}
+			r.report(fmt.Errorf("hook on_%s: %w", p.Event, err))
+		}
+	}()
+}
+
+// Wait blocks until every started hook has finished. One-shot commands call
+// it before exiting so that their hooks are not cut short.
+func (r *Runner) Wait() {
+	if r == nil {
+		return
+	}
+	r.wg.Wait()
+}
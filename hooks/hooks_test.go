@@ -0,0 +1,100 @@
+package hooks_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"td-file/config"
+	"td-file/hooks"
+	"td-file/parser"
+)
+
+// collector gathers reported errors from hook goroutines.
+type collector struct {
+	mu   sync.Mutex
+	errs []string
+}
+
+func (c *collector) report(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errs = append(c.errs, err.Error())
+}
+
+func TestFire_PayloadAndEnv(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.HooksConfig{
+		OnStateChange: `cat > "$OUT/stdin.json"`,
+		OnComplete:    `printf '%s|%s|%s|%s' "$TD_FILE_EVENT" "$TD_FILE_TEXT" "$TD_FILE_STATE" "$TD_FILE_PREVIOUS_STATE" > "$OUT/env"`,
+	}
+	t.Setenv("OUT", dir)
+	var c collector
+	r := hooks.New(cfg, c.report)
+
+	parent := &parser.Todo{Text: "Release", LineNumber: 1}
+	todo := &parser.Todo{Text: "Deploy #ops", State: parser.Completed, LineNumber: 2, Parent: parent}
+	r.StateChanged("/tmp/todos.md", todo, parser.Incomplete)
+	r.Wait()
+
+	if len(c.errs) > 0 {
+		t.Fatalf("unexpected hook errors: %v", c.errs)
+	}
+	env, _ := os.ReadFile(filepath.Join(dir, "env"))
+	if string(env) != "complete|Deploy #ops|completed|incomplete" {
+		t.Errorf("unexpected env: %q", env)
+	}
+	raw, _ := os.ReadFile(filepath.Join(dir, "stdin.json"))
+	var p hooks.Payload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		t.Fatalf("invalid payload %q: %v", raw, err)
+	}
+	if p.Event != hooks.StateChange || p.PreviousState != "incomplete" || p.Todo == nil ||
+		p.Todo.Text != "Deploy #ops" || p.Todo.Parent != 1 || p.Todo.Depth != 1 || p.File != "/tmp/todos.md" {
+		t.Errorf("unexpected payload: %+v", p)
+	}
+}
+
+func TestFire_UnchangedStateAndNilRunner(t *testing.T) {
+	dir := t.TempDir()
+	r := hooks.New(&config.HooksConfig{OnStateChange: "touch " + dir + "/ran"}, nil)
+	r.StateChanged("x.md", &parser.Todo{Text: "A"}, parser.Incomplete)
+	r.Wait()
+	if _, err := os.Stat(filepath.Join(dir, "ran")); err == nil {
+		t.Error("hook should not run when the state did not change")
+	}
+
+	var none *hooks.Runner
+	none.Fire(hooks.Save, "x.md", nil)
+	none.Wait()
+	if hooks.New(nil, nil) != nil {
+		t.Error("expected nil runner without a hooks section")
+	}
+}
+
+func TestFire_FailureAndTimeout(t *testing.T) {
+	var c collector
+	r := hooks.New(&config.HooksConfig{
+		OnAdd:   "echo bridge down >&2; exit 3",
+		OnSave:  "sleep 5",
+		Timeout: 100 * time.Millisecond,
+	}, c.report)
+	start := time.Now()
+	r.Fire(hooks.Add, "x.md", &parser.Todo{Text: "A"})
+	r.Fire(hooks.Save, "x.md", nil)
+	r.Wait()
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("timeout was not enforced")
+	}
+	got := strings.Join(c.errs, "\n")
+	if !strings.Contains(got, "hook on_add: exit status 3: bridge down") {
+		t.Errorf("missing failure report in %q", got)
+	}
+	if !strings.Contains(got, "hook on_save: timed out") {
+		t.Errorf("missing timeout report in %q", got)
+	}
+}
@@ -0,0 +1,372 @@
+// Package ical converts todos to and from iCalendar (RFC 5545) VTODO
+// components, so that dated todos show up in calendar and task apps.
+//
+// Todos are matched across exports and imports by a `uid:` field in the
+// todo text, which AssignUIDs adds on request. Todos without one get a UID
+// derived from their text and position (see UIDs), which stays the same
+// until the todo or one of its ancestors is edited.
+// Fields map as follows:
+//
+//	SUMMARY     the text without its uid, due, done and pri fields
+//	STATUS      NEEDS-ACTION, IN-PROCESS, COMPLETED or CANCELLED; other
+//	            states (pushed, waiting, ...) are NEEDS-ACTION with
+//	            X-TD-FILE-STATE naming the state
+//	DUE         the due: field, as a date
+//	PRIORITY    1 for highlighted todos, otherwise 1, 5 or 9 for pri:A/B/C
+//	RELATED-TO  the parent todo's uid
+package ical
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"td-file/parser"
+)
+
+// UIDField is the todo field holding a todo's iCalendar UID.
+const UIDField = "uid"
+
+// Statuses used in STATUS properties.
+const (
+	StatusNeedsAction = "NEEDS-ACTION"
+	StatusInProcess   = "IN-PROCESS"
+	StatusCompleted   = "COMPLETED"
+	StatusCancelled   = "CANCELLED"
+)
+
+// VTodo is the subset of a VTODO component that td-file understands.
+type VTodo struct {
+	UID       string
+	Summary   string
+	Status    string
+	Due       string // YYYY-MM-DD, or empty
+	Priority  int    // 0 (undefined) or 1 (highest) to 9 (lowest)
+	RelatedTo string // parent UID
+	// State is X-TD-FILE-STATE, which distinguishes states that STATUS
+	// cannot express, such as pushed.
+	State string
+}
+
+// NewUID returns a random UID for a todo.
+func NewUID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// UIDs returns the UID of each todo: its uid: field, or else one derived
+// from its block, its text and its ancestors' text, with a counter telling
+// identical todos apart. Derived UIDs are never written to the file.
+func UIDs(todos []parser.Todo) []string {
+	paths := map[int]string{}
+	var walk func(nodes []*parser.Todo, prefix string)
+	walk = func(nodes []*parser.Todo, prefix string) {
+		for _, t := range nodes {
+			paths[t.LineNumber] = prefix + "\x00" + t.Text
+			walk(t.Children, paths[t.LineNumber])
+		}
+	}
+	walk(parser.BuildTree(todos), "")
+
+	uids := make([]string, len(todos))
+	seen := map[string]int{}
+	for i, t := range todos {
+		if uid := parser.ParseMetadata(t.Text).Fields[UIDField]; uid != "" {
+			uids[i] = uid
+			continue
+		}
+		key := fmt.Sprintf("%d%s", t.Block, paths[t.LineNumber])
+		sum := sha1.Sum([]byte(fmt.Sprintf("%s\x00%d", key, seen[key])))
+		seen[key]++
+		uids[i] = hex.EncodeToString(sum[:8])
+	}
+	return uids
+}
+
+// WithUIDs returns a copy of todos in which every todo has a uid: field,
+// filled in from UIDs where missing.
+func WithUIDs(todos []parser.Todo) []parser.Todo {
+	out := append([]parser.Todo(nil), todos...)
+	for i, uid := range UIDs(todos) {
+		out[i].Text = parser.SetField(out[i].Text, UIDField, uid)
+	}
+	return out
+}
+
+// FromTodos converts a flat todo list to VTODOs in document order. Todos
+// without a uid: field are skipped.
+func FromTodos(todos []parser.Todo) []VTodo {
+	var out []VTodo
+	var walk func(nodes []*parser.Todo, parent string)
+	walk = func(nodes []*parser.Todo, parent string) {
+		for _, t := range nodes {
+			v := FromTodo(t)
+			if v.UID != "" {
+				v.RelatedTo = parent
+				out = append(out, v)
+			}
+			walk(t.Children, v.UID)
+		}
+	}
+	walk(parser.BuildTree(todos), "")
+	return out
+}
+
+// FromTodo converts one todo, leaving RelatedTo empty.
+func FromTodo(t *parser.Todo) VTodo {
+	md := parser.ParseMetadata(t.Text)
+	v := VTodo{
+		UID:     md.Fields[UIDField],
+		Summary: parser.StripFields(t.Text, UIDField, "due", "done", "pri"),
+		Status:  status(t.State),
+	}
+	if due, ok := md.Due(); ok {
+		v.Due = due.Format(parser.DateLayout)
+	}
+	if v.Status == StatusNeedsAction && t.State != parser.Incomplete && t.State != parser.Unknown {
+		v.State = t.State.String()
+	}
+	v.Priority = priority(md.Fields["pri"])
+	if t.Highlighted {
+		v.Priority = 1
+	}
+	return v
+}
+
+func status(s parser.TodoState) string {
+	switch s {
+	case parser.Completed:
+		return StatusCompleted
+	case parser.Cancelled:
+		return StatusCancelled
+	case parser.InProgress:
+		return StatusInProcess
+	}
+	return StatusNeedsAction
+}
+
+// TodoState maps the VTODO's status back to a todo state.
+func (v VTodo) TodoState() parser.TodoState {
+	if s, err := parser.ParseState(v.State); err == nil && status(s) == strings.ToUpper(v.Status) {
+		return s
+	}
+	switch strings.ToUpper(v.Status) {
+	case StatusCompleted:
+		return parser.Completed
+	case StatusCancelled:
+		return parser.Cancelled
+	case StatusInProcess:
+		return parser.InProgress
+	}
+	return parser.Incomplete
+}
+
+func priority(pri string) int {
+	switch strings.ToUpper(pri) {
+	case "A":
+		return 1
+	case "B":
+		return 5
+	case "C":
+		return 9
+	}
+	return 0
+}
+
+// priField is the pri: value for an iCalendar priority, following RFC 5545's
+// high (1-4), medium (5) and low (6-9) bands.
+func priField(p int) string {
+	switch {
+	case p >= 1 && p <= 4:
+		return "A"
+	case p == 5:
+		return "B"
+	case p >= 6 && p <= 9:
+		return "C"
+	}
+	return ""
+}
+
+// Encode writes items as a VCALENDAR. stamp is used for every DTSTAMP.
+func Encode(w io.Writer, items []VTodo, stamp time.Time) error {
+	bw := bufio.NewWriter(w)
+	write := func(name, value string) {
+		bw.WriteString(fold(name + ":" + value))
+	}
+	write("BEGIN", "VCALENDAR")
+	write("VERSION", "2.0")
+	write("PRODID", "-//td-file//td-file//EN")
+	for _, v := range items {
+		write("BEGIN", "VTODO")
+		write("UID", escape(v.UID))
+		write("DTSTAMP", stamp.UTC().Format("20060102T150405Z"))
+		write("SUMMARY", escape(v.Summary))
+		write("STATUS", v.Status)
+		if v.Due != "" {
+			if d, err := time.Parse(parser.DateLayout, v.Due); err == nil {
+				write("DUE;VALUE=DATE", d.Format("20060102"))
+			}
+		}
+		if v.Priority > 0 {
+			write("PRIORITY", strconv.Itoa(v.Priority))
+		}
+		if v.RelatedTo != "" {
+			write("RELATED-TO;RELTYPE=PARENT", escape(v.RelatedTo))
+		}
+		if v.State != "" {
+			write("X-TD-FILE-STATE", v.State)
+		}
+		write("END", "VTODO")
+	}
+	write("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+// fold terminates a content line with CRLF, folding it so that no physical
+// line exceeds 75 octets without splitting a UTF-8 sequence.
+func fold(line string) string {
+	var b strings.Builder
+	width := 0
+	for _, r := range line {
+		n := len(string(r))
+		if width+n > 75 {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += n
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+var (
+	escaper   = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+	unescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+)
+
+func escape(s string) string   { return escaper.Replace(s) }
+func unescape(s string) string { return unescaper.Replace(s) }
+
+// Decode reads the VTODO components of an iCalendar stream. Other
+// components and unknown properties are ignored.
+func Decode(r io.Reader) ([]VTodo, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	var out []VTodo
+	var cur *VTodo
+	depth := 0 // nesting below the current VTODO, e.g. VALARM
+	for n, line := range lines {
+		name, params, value, ok := splitLine(line)
+		if !ok {
+			return nil, fmt.Errorf("ical: line %d: malformed content line %q", n+1, line)
+		}
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VTODO") && cur == nil:
+			cur = &VTodo{}
+		case cur == nil:
+		case name == "BEGIN":
+			depth++
+		case name == "END" && depth > 0:
+			depth--
+		case depth > 0:
+		case name == "END":
+			if cur.UID == "" {
+				return nil, fmt.Errorf("ical: line %d: VTODO without UID", n+1)
+			}
+			out = append(out, *cur)
+			cur = nil
+		case name == "UID":
+			cur.UID = unescape(value)
+		case name == "SUMMARY":
+			cur.Summary = strings.Join(strings.Fields(unescape(value)), " ")
+		case name == "STATUS":
+			cur.Status = strings.ToUpper(value)
+		case name == "DUE":
+			cur.Due = date(value)
+		case name == "PRIORITY":
+			cur.Priority, _ = strconv.Atoi(value)
+		case name == "RELATED-TO":
+			if rel := params["RELTYPE"]; rel == "" || strings.EqualFold(rel, "PARENT") {
+				cur.RelatedTo = unescape(value)
+			}
+		case name == "X-TD-FILE-STATE":
+			cur.State = strings.ToLower(value)
+		}
+	}
+	if cur != nil {
+		return nil, fmt.Errorf("ical: unterminated VTODO")
+	}
+	return out, nil
+}
+
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// splitLine parses NAME;PARAM=VALUE;...:VALUE. Parameter values may be
+// quoted, and quoted values may contain ':' and ';'.
+func splitLine(line string) (name string, params map[string]string, value string, ok bool) {
+	params = map[string]string{}
+	inQuote := false
+	start := 0
+	var parts []string
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == ';':
+			parts = append(parts, line[start:i])
+			start = i + 1
+		case c == ':':
+			parts = append(parts, line[start:i])
+			value = line[i+1:]
+			name = strings.ToUpper(parts[0])
+			for _, p := range parts[1:] {
+				k, v, _ := strings.Cut(p, "=")
+				params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+			}
+			return name, params, value, name != ""
+		}
+	}
+	return "", nil, "", false
+}
+
+// date reads a DATE or DATE-TIME value as YYYY-MM-DD. UTC times are converted
+// to the local date.
+func date(value string) string {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t.Local().Format(parser.DateLayout)
+	}
+	if len(value) < 8 {
+		return ""
+	}
+	t, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		return ""
+	}
+	return t.Format(parser.DateLayout)
+}
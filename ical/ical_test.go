@@ -0,0 +1,198 @@
+package ical_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"td-file/control"
+	"td-file/ical"
+	"td-file/parser"
+)
+
+var stamp = time.Date(2024, 6, 1, 9, 30, 0, 0, time.UTC)
+
+func TestEncodeDecode(t *testing.T) {
+	todos := parser.ParseTodos([][]string{{
+		"- [ ] Launch, then celebrate; really due:2024-06-07 uid:a1 *",
+		"  - [x] Write notes pri:B uid:b2",
+		"  - [>] Book venue uid:c3",
+		"  - [/] Draft invite uid:d4",
+		"  - [w] Hear back uid:e5",
+		"- [ ] No uid yet",
+	}})
+	items := ical.FromTodos(todos)
+	want := []ical.VTodo{
+		{UID: "a1", Summary: "Launch, then celebrate; really", Status: "NEEDS-ACTION", Due: "2024-06-07", Priority: 1},
+		{UID: "b2", Summary: "Write notes", Status: "COMPLETED", Priority: 5, RelatedTo: "a1"},
+		{UID: "c3", Summary: "Book venue", Status: "NEEDS-ACTION", RelatedTo: "a1", State: "pushed"},
+		{UID: "d4", Summary: "Draft invite", Status: "IN-PROCESS", RelatedTo: "a1"},
+		{UID: "e5", Summary: "Hear back", Status: "NEEDS-ACTION", RelatedTo: "a1", State: "waiting"},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Fatalf("FromTodos:\n got %+v\nwant %+v", items, want)
+	}
+
+	var buf bytes.Buffer
+	if err := ical.Encode(&buf, items, stamp); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"SUMMARY:Launch\\, then celebrate\\; really\r\n",
+		"DUE;VALUE=DATE:20240607\r\n",
+		"DTSTAMP:20240601T093000Z\r\n",
+		"RELATED-TO;RELTYPE=PARENT:a1\r\n",
+		"X-TD-FILE-STATE:pushed\r\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+
+	got, err := ical.Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip:\n got %+v\nwant %+v", got, want)
+	}
+	for i, v := range got {
+		if v.TodoState() != todos[i].State {
+			t.Errorf("%s: state %s, want %s", v.UID, v.TodoState(), todos[i].State)
+		}
+	}
+}
+
+func TestEncode_Folds(t *testing.T) {
+	summary := strings.Repeat("ünïcödé ", 20)
+	var buf bytes.Buffer
+	ical.Encode(&buf, []ical.VTodo{{UID: "x", Summary: strings.TrimSpace(summary), Status: "NEEDS-ACTION"}}, stamp)
+	for _, line := range strings.Split(buf.String(), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line longer than 75 octets: %q", line)
+		}
+	}
+	got, err := ical.Decode(&buf)
+	if err != nil || len(got) != 1 || got[0].Summary != strings.TrimSpace(summary) {
+		t.Errorf("folded summary did not round trip: %+v, %v", got, err)
+	}
+}
+
+func TestDecode_ForeignCalendar(t *testing.T) {
+	src := "BEGIN:VCALENDAR\n" +
+		"BEGIN:VEVENT\nUID:ev\nSUMMARY:Meeting\nEND:VEVENT\n" +
+		"BEGIN:VTODO\nUID:t1\nSUMMARY:Call \n back\nDUE:20240610T230000Z\nPRIORITY:7\n" +
+		"BEGIN:VALARM\nACTION:DISPLAY\nEND:VALARM\n" +
+		"RELATED-TO;RELTYPE=SIBLING:ev\nSTATUS:in-process\nEND:VTODO\n" +
+		"END:VCALENDAR\n"
+	got, err := ical.Decode(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected only the VTODO, got %+v", got)
+	}
+	v := got[0]
+	if v.Summary != "Call back" || v.Priority != 7 || v.RelatedTo != "" || v.TodoState() != parser.InProgress {
+		t.Errorf("unexpected %+v", v)
+	}
+	if want := time.Date(2024, 6, 10, 23, 0, 0, 0, time.UTC).Local().Format(parser.DateLayout); v.Due != want {
+		t.Errorf("due = %q, want %q", v.Due, want)
+	}
+
+	if _, err := ical.Decode(strings.NewReader("BEGIN:VTODO\nSUMMARY:x\nEND:VTODO\n")); err == nil {
+		t.Error("expected an error for a VTODO without UID")
+	}
+}
+
+func TestAssignUIDs(t *testing.T) {
+	todos := parser.ParseTodos([][]string{{"- [ ] A uid:keep", "- [ ] B"}})
+	req, ok := ical.AssignUIDs("todos.md", todos)
+	if !ok || len(req.Requests) != 1 || req.Requests[0].Line != 2 {
+		t.Fatalf("unexpected request %+v", req)
+	}
+	got, err := control.Apply(todos, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := parser.ParseMetadata(got[1].Text).Fields["uid"]
+	if len(uid) != 16 || got[0].Text != "A uid:keep" {
+		t.Errorf("unexpected todos %+v", got)
+	}
+	if _, ok := ical.AssignUIDs("todos.md", got); ok {
+		t.Error("all todos have UIDs; nothing should be assigned")
+	}
+}
+
+func TestUIDs(t *testing.T) {
+	todos := parser.ParseTodos([][]string{{"- [ ] A uid:keep", "- [ ] B", "  - [ ] C", "- [ ] B", "  - [ ] C"}})
+	uids := ical.UIDs(todos)
+	if uids[0] != "keep" || len(uids[1]) != 16 {
+		t.Fatalf("unexpected UIDs %v", uids)
+	}
+	seen := map[string]bool{}
+	for _, uid := range uids {
+		if seen[uid] {
+			t.Errorf("duplicate UID %q in %v", uid, uids)
+		}
+		seen[uid] = true
+	}
+	if again := ical.UIDs(parser.ParseTodos([][]string{{"- [ ] A uid:keep", "- [ ] B", "  - [ ] C", "- [ ] B", "  - [ ] C"}})); !reflect.DeepEqual(again, uids) {
+		t.Errorf("UIDs should be stable, got %v then %v", uids, again)
+	}
+	if got := ical.WithUIDs(todos); got[1].Text != "B uid:"+uids[1] || todos[1].Text != "B" {
+		t.Errorf("unexpected WithUIDs %+v", got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	todos := parser.ParseTodos([][]string{
+		{"- [ ] Launch due:2024-06-07 uid:a1", "  - [>] Book venue uid:c3", "- [ ] Untouched uid:d4"},
+		{},
+	})
+	items := []ical.VTodo{
+		{UID: "a1", Summary: "Launch v2", Status: "COMPLETED", Due: "2024-06-08"},
+		// A calendar app that drops X- properties must not un-push the todo.
+		{UID: "c3", Summary: "Book venue", Status: "NEEDS-ACTION", RelatedTo: "a1"},
+		{UID: "d4", Summary: "Untouched", Status: "NEEDS-ACTION"},
+		{UID: "n1", Summary: "Send invites", Status: "NEEDS-ACTION", RelatedTo: "a1", Priority: 1},
+		{UID: "n2", Summary: "Print", Status: "NEEDS-ACTION", RelatedTo: "n1", Priority: 9},
+		{UID: "n3", Summary: "New root", Status: "CANCELLED"},
+	}
+	req, res := ical.Merge("todos.md", todos, items, 1)
+	if res.Updated != 1 || len(res.Added) != 3 || len(res.States) != 1 || res.States[0].Previous != parser.Incomplete {
+		t.Errorf("unexpected result %+v", res)
+	}
+	got, err := control.Apply(todos, req)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	var lines []string
+	for _, td := range got {
+		lines = append(lines, parser.FormatTodo(td))
+	}
+	want := []string{
+		"- [x] Launch v2 due:2024-06-08 uid:a1",
+		"  - [>] Book venue uid:c3",
+		"  - [ ] Send invites uid:n1 *",
+		"    - [ ] Print pri:C uid:n2",
+		"- [ ] Untouched uid:d4",
+		"- [-] New root uid:n3",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("merged:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+	if got[5].Block != 1 {
+		t.Errorf("new root should go to block 1, got %d", got[5].Block)
+	}
+
+	// Importing the export of the merged file changes nothing.
+	again, res := ical.Merge("todos.md", got, ical.FromTodos(got), 0)
+	if len(again.Requests) != 0 || res.Updated != 0 {
+		t.Errorf("re-import should be a no-op, got %+v", again.Requests)
+	}
+}
@@ -0,0 +1,176 @@
+package ical
+
+import (
+	"strings"
+
+	"td-file/control"
+	"td-file/parser"
+)
+
+// AssignUIDs returns a request that adds a uid: field to every todo in path
+// that lacks one. ok is false if every todo already has a UID.
+func AssignUIDs(path string, todos []parser.Todo) (req control.Request, ok bool) {
+	req = control.Request{Op: control.OpBatch, Path: path}
+	for _, t := range todos {
+		if parser.ParseMetadata(t.Text).Fields[UIDField] != "" {
+			continue
+		}
+		req.Requests = append(req.Requests, control.Request{
+			Op:   control.OpSetText,
+			Line: t.LineNumber,
+			Old:  t.Text,
+			Text: parser.SetField(t.Text, UIDField, NewUID()),
+		})
+	}
+	return req, len(req.Requests) > 0
+}
+
+// StateChange records a todo whose state an import changed.
+type StateChange struct {
+	Todo     parser.Todo // with the new state and text
+	Previous parser.TodoState
+}
+
+// Result summarises what Merge would change.
+type Result struct {
+	Added   []parser.Todo
+	Updated int
+	States  []StateChange
+}
+
+// Merge compares imported VTODOs with the todos of path and returns a batch
+// request that brings the file in line with them. Todos are matched by UID
+// (see UIDs): a matched todo gets the VTODO's summary, status, due date and
+// priority, but only where they differ from what exporting it would produce,
+// so that information VTODOs can't carry (such as pushed vs. incomplete)
+// survives a round trip. Unmatched VTODOs are added, under their RELATED-TO parent if
+// that is in the file or among the new items, otherwise at the end of block.
+// Todos missing from items are left alone.
+func Merge(path string, todos []parser.Todo, items []VTodo, block int) (control.Request, Result) {
+	req := control.Request{Op: control.OpBatch, Path: path}
+	var res Result
+
+	lines := map[string]int{}
+	for i, uid := range UIDs(todos) {
+		lines[uid] = i
+	}
+
+	var added []VTodo
+	for _, v := range items {
+		i, ok := lines[v.UID]
+		if !ok {
+			added = append(added, v)
+			continue
+		}
+		t := todos[i]
+		cur := FromTodo(&t)
+		text := t.Text
+		if v.Summary != "" && v.Summary != cur.Summary {
+			text = withFields(v.Summary, t.Text)
+		}
+		if v.Due != cur.Due {
+			text = parser.SetField(text, "due", v.Due)
+		}
+		if v.Priority != cur.Priority {
+			text = parser.SetField(text, "pri", priField(v.Priority))
+		}
+		changed := false
+		if text != t.Text {
+			req.Requests = append(req.Requests, control.Request{Op: control.OpSetText, Line: t.LineNumber, Old: t.Text, Text: text})
+			changed = true
+		}
+		// The state goes last so that a completion date stamped by
+		// parser.SetState is not overwritten by the new text.
+		if state := v.TodoState(); status(state) != cur.Status || (v.State != "" && v.State != cur.State) {
+			req.Requests = append(req.Requests, control.Request{
+				Op: control.OpSetState, Line: t.LineNumber, Text: text, State: state.String(),
+			})
+			after := t
+			after.Text, after.State = text, state
+			res.States = append(res.States, StateChange{Todo: after, Previous: t.State})
+			changed = true
+		}
+		if changed {
+			res.Updated++
+		}
+	}
+
+	// New items are grouped into subtrees and inserted in one step each: under
+	// an existing parent, or as a new root.
+	children := map[string][]VTodo{}
+	isNew := map[string]bool{}
+	for _, v := range added {
+		isNew[v.UID] = true
+	}
+	var roots []VTodo
+	for _, v := range added {
+		if isNew[v.RelatedTo] && v.RelatedTo != v.UID {
+			children[v.RelatedTo] = append(children[v.RelatedTo], v)
+		} else {
+			roots = append(roots, v)
+		}
+	}
+	seen := map[string]bool{}
+	var subtree func(v VTodo, indent int) []parser.Todo
+	subtree = func(v VTodo, indent int) []parser.Todo {
+		if seen[v.UID] {
+			return nil
+		}
+		seen[v.UID] = true
+		out := []parser.Todo{newTodo(v, indent)}
+		for _, c := range children[v.UID] {
+			out = append(out, subtree(c, indent+2)...)
+		}
+		return out
+	}
+	add := func(v VTodo) {
+		step := control.Request{Op: control.OpAdd, Block: block, Todos: subtree(v, 0)}
+		if i, ok := lines[v.RelatedTo]; ok {
+			step.Parent = todos[i].LineNumber
+		}
+		req.Requests = append(req.Requests, step)
+		res.Added = append(res.Added, step.Todos...)
+	}
+	for _, v := range roots {
+		add(v)
+	}
+	// Items whose parents form a cycle are not reached from any root.
+	for _, v := range added {
+		if !seen[v.UID] {
+			add(v)
+		}
+	}
+	return req, res
+}
+
+// withFields builds todo text from an imported summary, keeping the managed
+// fields of the existing text.
+func withFields(summary, old string) string {
+	text := summary
+	md := parser.ParseMetadata(old)
+	for _, key := range []string{"due", "done", "pri", UIDField} {
+		if v := md.Fields[key]; v != "" {
+			text = parser.SetField(text, key, v)
+		}
+	}
+	return text
+}
+
+func newTodo(v VTodo, indent int) parser.Todo {
+	t := parser.Todo{
+		Text:        strings.TrimSpace(v.Summary),
+		State:       v.TodoState(),
+		IndentLevel: indent,
+	}
+	if t.Text == "" {
+		t.Text = "(untitled)"
+	}
+	t.Text = parser.SetField(t.Text, "due", v.Due)
+	if v.Priority >= 1 && v.Priority <= 4 {
+		t.Highlighted = true
+	} else {
+		t.Text = parser.SetField(t.Text, "pri", priField(v.Priority))
+	}
+	t.Text = parser.SetField(t.Text, UIDField, v.UID)
+	return t
+}
@@ -0,0 +1,276 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"td-file/parser"
+)
+
+func init() {
+	Register(todoTxtImporter{})
+	Register(taskwarriorImporter{})
+	Register(markdownImporter{})
+}
+
+// todoTxtImporter reads the todo.txt format. "x" marks completion, priority
+// (A) becomes a highlight and other priorities a pri: field, +projects and
+// @contexts become hashtags, and the id:/parent: and status: fields written by
+// the todotxt exporter are turned back into nesting and state.
+type todoTxtImporter struct{}
+
+func (todoTxtImporter) Name() string { return "todotxt" }
+
+var (
+	todoTxtPriorityRe = regexp.MustCompile(`^\(([A-Z])\)\s+`)
+	todoTxtDateRe     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}\s+`)
+	todoTxtTagRe      = regexp.MustCompile(`(^|\s)[+@]([^\s]+)`)
+)
+
+func (todoTxtImporter) Import(r io.Reader) ([]parser.Todo, error) {
+	var todos []parser.Todo
+	var ids, parents []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		t := parser.Todo{State: parser.Incomplete}
+		if strings.HasPrefix(line, "x ") {
+			t.State = parser.Completed
+			line = strings.TrimSpace(line[2:])
+			// Completion date, then optional creation date.
+			if d := todoTxtDateRe.FindString(line); d != "" {
+				line = line[len(d):]
+				line = addField(line, "done", strings.TrimSpace(d))
+			}
+		}
+		if m := todoTxtPriorityRe.FindStringSubmatch(line); m != nil {
+			line = line[len(m[0]):]
+			if m[1] == "A" && t.State == parser.Incomplete {
+				t.Highlighted = true
+			} else {
+				line = addField(line, "pri", m[1])
+			}
+		}
+		if d := todoTxtDateRe.FindString(line); d != "" {
+			line = addField(line[len(d):], "created", strings.TrimSpace(d))
+		}
+		var id, parent string
+		var words []string
+		for _, w := range strings.Fields(line) {
+			switch {
+			case strings.HasPrefix(w, "id:"):
+				id = w[3:]
+			case strings.HasPrefix(w, "parent:"):
+				parent = w[7:]
+			case w == "status:cancelled":
+				t.State = parser.Cancelled
+			case w == "status:pushed":
+				t.State = parser.Pushed
+			default:
+				words = append(words, w)
+			}
+		}
+		t.Text = oneLine(todoTxtTagRe.ReplaceAllString(strings.Join(words, " "), "$1#$2"))
+		todos = append(todos, t)
+		ids = append(ids, id)
+		parents = append(parents, parent)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return orderByParent(todos, ids, parents), nil
+}
+
+// orderByParent arranges todos so that each appears directly under the todo
+// whose id matches its parent reference. Todos with unknown parents become
+// roots; relative order is otherwise preserved.
+func orderByParent(todos []parser.Todo, ids, parents []string) []parser.Todo {
+	index := map[string]int{}
+	for i, id := range ids {
+		if id != "" {
+			index[id] = i
+		}
+	}
+	children := make(map[int][]int)
+	var roots []int
+	for i, p := range parents {
+		if pi, ok := index[p]; ok && p != "" && pi != i {
+			children[pi] = append(children[pi], i)
+		} else {
+			roots = append(roots, i)
+		}
+	}
+	var out []parser.Todo
+	var depths []int
+	seen := make(map[int]bool)
+	var visit func(i, depth int)
+	visit = func(i, depth int) {
+		if seen[i] {
+			return
+		}
+		seen[i] = true
+		out = append(out, todos[i])
+		depths = append(depths, depth)
+		for _, c := range children[i] {
+			visit(c, depth+1)
+		}
+	}
+	for _, i := range roots {
+		visit(i, 0)
+	}
+	// Anything left is part of a parent cycle; keep it rather than drop it.
+	for i := range todos {
+		visit(i, 0)
+	}
+	return nest(depths, out)
+}
+
+// taskwarriorImporter reads the JSON array produced by `task export`.
+type taskwarriorImporter struct{}
+
+func (taskwarriorImporter) Name() string { return "taskwarrior" }
+
+type taskwarriorTask struct {
+	Description string   `json:"description"`
+	Status      string   `json:"status"`
+	Priority    string   `json:"priority"`
+	Project     string   `json:"project"`
+	Tags        []string `json:"tags"`
+	Due         string   `json:"due"`
+	Scheduled   string   `json:"scheduled"`
+	End         string   `json:"end"`
+	UUID        string   `json:"uuid"`
+}
+
+func (taskwarriorImporter) Import(r io.Reader) ([]parser.Todo, error) {
+	var tasks []taskwarriorTask
+	if err := json.NewDecoder(r).Decode(&tasks); err != nil {
+		return nil, fmt.Errorf("invalid taskwarrior export: %w", err)
+	}
+	var todos []parser.Todo
+	for _, task := range tasks {
+		t := parser.Todo{Text: task.Description}
+		switch task.Status {
+		case "completed":
+			t.State = parser.Completed
+		case "deleted":
+			t.State = parser.Cancelled
+		case "waiting":
+			t.State = parser.Pushed
+		default:
+			t.State = parser.Incomplete
+		}
+		switch task.Priority {
+		case "H":
+			if t.State == parser.Incomplete {
+				t.Highlighted = true
+			} else {
+				t.Text = addField(t.Text, "pri", "H")
+			}
+		case "M", "L":
+			t.Text = addField(t.Text, "pri", task.Priority)
+		}
+		if task.Project != "" {
+			t.Text += " #" + strings.ReplaceAll(task.Project, ".", "/")
+		}
+		for _, tag := range task.Tags {
+			t.Text += " #" + tag
+		}
+		for _, f := range []struct{ key, value string }{{"due", task.Due}, {"scheduled", task.Scheduled}, {"done", task.End}} {
+			if d, ok := taskwarriorDate(f.value); ok {
+				t.Text = addField(t.Text, f.key, d)
+			}
+		}
+		t.Text = oneLine(t.Text)
+		todos = append(todos, t)
+	}
+	return todos, nil
+}
+
+func taskwarriorDate(v string) (string, bool) {
+	if v == "" {
+		return "", false
+	}
+	d, err := time.Parse("20060102T150405Z", v)
+	if err != nil {
+		return "", false
+	}
+	return d.Local().Format(parser.DateLayout), true
+}
+
+// markdownImporter reads checklist items from any markdown document,
+// accepting -, * and + bullets as well as numbered lists, with nesting taken
+// from indentation (a tab counts as four spaces). Lines that are not
+// checklist items are skipped, as are items whose box holds a marker that
+// is not a registered state.
+type markdownImporter struct{}
+
+func (markdownImporter) Name() string { return "markdown" }
+
+// markdownItemRe matches checklist items whose box holds a registered
+// marker, or X for completed.
+func markdownItemRe() *regexp.Regexp {
+	class := "X"
+	for _, def := range parser.States() {
+		for _, r := range def.Marker {
+			// ASCII punctuation is escaped so that -, ] and ^ stay literal.
+			if r < utf8.RuneSelf && !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' {
+				class += `\`
+			}
+			class += string(r)
+		}
+	}
+	return regexp.MustCompile(`^([ \t]*)(?:[-*+]|\d+[.)])\s+\[([` + class + `])\]\s+(.*)$`)
+}
+
+func (markdownImporter) Import(r io.Reader) ([]parser.Todo, error) {
+	itemRe := markdownItemRe()
+	var todos []parser.Todo
+	var depths []int
+	var stack []int // indent widths of open ancestors
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		m := itemRe.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		width := len(strings.ReplaceAll(m[1], "\t", "    "))
+		for len(stack) > 0 && width <= stack[len(stack)-1] {
+			stack = stack[:len(stack)-1]
+		}
+		depths = append(depths, len(stack))
+		stack = append(stack, width)
+
+		t := parser.Todo{Text: oneLine(strings.TrimSpace(m[3])), State: parser.Completed}
+		if m[2] != "X" {
+			t.State, _ = parser.StateForMarker(m[2])
+		}
+		if strings.HasSuffix(t.Text, " *") && t.State == parser.Incomplete {
+			t.Highlighted = true
+			t.Text = strings.TrimSpace(strings.TrimSuffix(t.Text, "*"))
+		}
+		todos = append(todos, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nest(depths, todos), nil
+}
+
+func addField(text, key, value string) string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return key + ":" + value
+	}
+	return text + " " + key + ":" + value
+}
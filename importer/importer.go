@@ -0,0 +1,80 @@
+// Package importer converts task lists from other tools into parser.Todo
+// entries. Like the export package, formats are registered by name so that
+// `td-file import --from NAME` can look them up.
+package importer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"td-file/parser"
+)
+
+// Importer reads foreign task records.
+type Importer interface {
+	// Name is the value passed to --from.
+	Name() string
+	// Import returns the tasks in document order. Nesting is expressed with
+	// IndentLevel (two spaces per level, root todos at 0).
+	Import(r io.Reader) ([]parser.Todo, error)
+}
+
+var registry = map[string]Importer{}
+
+// Register makes an importer available by name. Registering the same name
+// twice panics.
+func Register(i Importer) {
+	if _, dup := registry[i.Name()]; dup {
+		panic("importer: duplicate importer " + i.Name())
+	}
+	registry[i.Name()] = i
+}
+
+// Get returns the importer registered under name.
+func Get(name string) (Importer, error) {
+	i, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown import format %q (want one of %s)", name, strings.Join(Names(), ", "))
+	}
+	return i, nil
+}
+
+// Names lists the registered formats in alphabetical order.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// oneLine joins text spanning several lines with spaces, since a todo must
+// fit on one line.
+func oneLine(text string) string {
+	if parser.SingleLine(text) {
+		return text
+	}
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// nest converts a list of (depth, todo) pairs into todos with IndentLevel set,
+// clamping depths so that no todo is more than one level deeper than the
+// previous one.
+func nest(depths []int, todos []parser.Todo) []parser.Todo {
+	prev := -1
+	for i := range todos {
+		d := depths[i]
+		if d > prev+1 {
+			d = prev + 1
+		}
+		if d < 0 {
+			d = 0
+		}
+		todos[i].IndentLevel = 2 * d
+		prev = d
+	}
+	return todos
+}
@@ -0,0 +1,131 @@
+package importer_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"td-file/export"
+	"td-file/importer"
+	"td-file/output"
+	"td-file/parser"
+)
+
+func lines(todos []parser.Todo) []string {
+	var out []string
+	for _, t := range todos {
+		out = append(out, parser.FormatTodo(t))
+	}
+	return out
+}
+
+func run(t *testing.T, format, input string) []string {
+	t.Helper()
+	imp, err := importer.Get(format)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	todos, err := imp.Import(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	return lines(todos)
+}
+
+func TestTodoTxt(t *testing.T) {
+	input := strings.Join([]string{
+		"(A) Call mom +family @phone due:2024-06-07",
+		"x 2024-06-02 2024-06-01 File taxes +admin",
+		"(B) 2024-05-30 Plan trip",
+		"Cancelled thing status:cancelled",
+	}, "\n")
+	got := run(t, "todotxt", input)
+	want := []string{
+		"- [ ] Call mom #family #phone due:2024-06-07 *",
+		"- [x] File taxes #admin done:2024-06-02 created:2024-06-01",
+		"- [ ] Plan trip pri:B created:2024-05-30",
+		"- [-] Cancelled thing",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+}
+
+func TestTodoTxt_RoundTripsNesting(t *testing.T) {
+	original := parser.ParseTodos([][]string{{
+		"- [ ] Parent",
+		"  - [x] Child",
+		"    - [>] Grandchild",
+		"- [-] Sibling",
+	}})
+	var buf bytes.Buffer
+	e, _ := export.Get("todotxt")
+	if err := e.Export(&buf, output.NewDocument("x.md", original, nil)); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	got := run(t, "todotxt", buf.String())
+	if !reflect.DeepEqual(got, lines(original)) {
+		t.Errorf("round trip = %q, want %q", got, lines(original))
+	}
+}
+
+func TestTaskwarrior(t *testing.T) {
+	input := `[
+		{"uuid":"1","description":"Fix bug","status":"pending","priority":"H","project":"work.backend","tags":["urgent"],"due":"20240607T120000Z"},
+		{"uuid":"2","description":"Old idea","status":"deleted"},
+		{"uuid":"3","description":"Later","status":"waiting","priority":"L"},
+		{"uuid":"4","description":"Shipped","status":"completed","priority":"H"}
+	]`
+	got := run(t, "taskwarrior", input)
+	want := []string{
+		"- [ ] Fix bug #work/backend #urgent due:2024-06-07 *",
+		"- [-] Old idea",
+		"- [>] Later pri:L",
+		"- [x] Shipped pri:H",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+}
+
+func TestMultiLineTextIsFlattened(t *testing.T) {
+	for _, c := range []struct{ format, input, want string }{
+		{"todotxt", "Call\rmom +family", "- [ ] Call mom #family"},
+		{"taskwarrior", `[{"description":"Fix\r\nthe\nbug","status":"pending"}]`, "- [ ] Fix the bug"},
+		{"markdown", "- [ ] Two\rparts", "- [ ] Two parts"},
+	} {
+		got := run(t, c.format, c.input)
+		if len(got) != 1 || got[0] != c.want {
+			t.Errorf("%s: got %q, want %q", c.format, got, c.want)
+		}
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	input := strings.Join([]string{
+		"# Groceries",
+		"* [ ] Fruit",
+		"\t+ [X] Apples",
+		"\t+ [ ] Pears *",
+		"not a task",
+		"1. [-] Dropped",
+		"        - [ ] Over-indented",
+		"- [/] Cooking",
+		"- [?] Maybe",
+		"- [%] Not a registered marker",
+	}, "\n")
+	got := run(t, "markdown", input)
+	want := []string{
+		"- [ ] Fruit",
+		"  - [x] Apples",
+		"  - [ ] Pears *",
+		"- [-] Dropped",
+		"  - [ ] Over-indented",
+		"- [/] Cooking",
+		"- [?] Maybe",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+}
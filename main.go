@@ -7,22 +7,41 @@ import (
 	"os"
 	"path/filepath"
 
+	"td-file/cli"
 	"td-file/config"
+	"td-file/gitsync"
 	"td-file/parser"
 	"td-file/sync"
 	"td-file/tui"
 )
 
 func main() {
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		if err := cli.Run(os.Args[1], os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var todoFileFlag string
 	flag.StringVar(&todoFileFlag, "todo-file", "", "Path to todo file (overrides config)")
 	flag.StringVar(&todoFileFlag, "f", "", "Path to todo file (shorthand, overrides config)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: td-file [flags] [command]\n\nFlags:\n")
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output())
+		cli.Usage(flag.CommandLine.Output())
+	}
 	flag.Parse()
 
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	if err := parser.Configure(cfg.ParserSettings()); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
 
 	var todoPath string
 	if todoFileFlag != "" {
@@ -40,6 +59,21 @@ func main() {
 		log.Fatalf("Failed to create todo directory: %v", err)
 	}
 
+	// With a git section, base_directory is a repository: bring it up to
+	// date before reading, and commit edits once they settle.
+	var repo *gitsync.Repo
+	var pullErr error
+	if cfg.Git != nil {
+		dir := cfg.BaseDir
+		if dir == "" {
+			dir = filepath.Dir(todoPath)
+		}
+		repo = gitsync.New(dir)
+		if cfg.Git.PullOnStart {
+			pullErr = repo.Pull()
+		}
+	}
+
 	if _, err := os.Stat(todoPath); os.IsNotExist(err) {
 		fmt.Printf("Todo file '%s' does not exist. Please create it and restart the app.\n", todoPath)
 		os.Exit(1)
@@ -58,11 +92,19 @@ func main() {
 	todos := parser.ParseTodos(blocks)
 
 	syncer := sync.NewFileSynchronizer(todoPath)
+	if repo != nil && cfg.Git.AutoCommit {
+		committer := gitsync.NewAutoCommitter(repo, cfg.Git, syncer.Report)
+		syncer.OnChange = committer.Notify
+		defer committer.Flush()
+	}
 	if err := syncer.Start(); err != nil {
 		fmt.Println("Error starting file synchronizer:", err)
 		os.Exit(1)
 	}
 	defer syncer.Stop()
+	if pullErr != nil {
+		syncer.Report(pullErr)
+	}
 
 	maxID := 0
 	for i := range todos {
@@ -70,7 +112,7 @@ func main() {
 			maxID = todos[i].ID
 		}
 	}
-	if err := tui.StartTUI(todos, syncer, maxID); err != nil {
+	if err := tui.StartTUI(todos, syncer, maxID, cfg); err != nil {
 		fmt.Println("Error running TUI:", err)
 		os.Exit(1)
 	}
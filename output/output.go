@@ -0,0 +1,255 @@
+// Package output serialises parsed todo trees into machine-readable formats.
+//
+// The JSON shape is versioned by SchemaVersion. Fields may be added without
+// bumping the version; renaming or removing a field, or changing its type,
+// requires a bump so that consumers such as jq scripts and status bars can
+// detect the change.
+//
+// Schema (version 1):
+//
+//	{
+//	  "schema_version": 1,
+//	  "file": "/path/to/todos.md",
+//	  "todos": [Node, ...],
+//	  "warnings": ["...", ...]
+//	}
+//
+// where each Node is
+//
+//	{
+//	  "text": "Buy milk",
+//	  "state": "incomplete",
+//	  "highlighted": false,
+//	  "depth": 0,
+//	  "block": 0,
+//	  "line": 1,
+//	  "tags": ["work"],
+//	  "fields": {"due": "2024-06-07"},
+//	  "children": [Node, ...]
+//	}
+//
+// "state" is the name of a registered state (see parser.StateDef): one of
+// incomplete, completed, cancelled, pushed, in_progress, question,
+// important and waiting, a state added in the config, or "unknown" for a
+// marker that is not registered.
+//
+// "line" is not a line of the file: it counts the todo lines of all :td
+// blocks from 1, skipping the :td markers and everything outside the
+// blocks. It is the number that --line and --parent take, and it stays the
+// same while the text around the blocks changes.
+//
+// NDJSON output emits one Record per line instead: every todo is flattened in
+// document order with its parent's line number, followed by one record per
+// warning.
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"td-file/parser"
+)
+
+// SchemaVersion identifies the layout of Document, Node and Record.
+const SchemaVersion = 1
+
+// Document is the top-level JSON object emitted by `td-file list --format json`.
+type Document struct {
+	SchemaVersion int      `json:"schema_version"`
+	File          string   `json:"file"`
+	Todos         []Node   `json:"todos"`
+	Warnings      []string `json:"warnings"`
+}
+
+// Node is a single todo and its subtree.
+type Node struct {
+	Text        string            `json:"text"`
+	State       string            `json:"state"`
+	Highlighted bool              `json:"highlighted"`
+	Depth       int               `json:"depth"`
+	Block       int               `json:"block"`
+	Line        int               `json:"line"` // todo counter, not file line; see the package doc
+	Tags        []string          `json:"tags"`
+	Fields      map[string]string `json:"fields"`
+	Children    []Node            `json:"children"`
+}
+
+// Record is one line of NDJSON output. Type is either "todo" or "warning".
+type Record struct {
+	SchemaVersion int    `json:"schema_version"`
+	Type          string `json:"type"`
+	File          string `json:"file"`
+	Date          string `json:"date,omitempty"`
+	Text          string `json:"text,omitempty"`
+	State         string `json:"state,omitempty"`
+	Highlighted   bool   `json:"highlighted,omitempty"`
+	Depth         int    `json:"depth"`
+	Block         int    `json:"block"`
+	Line          int    `json:"line,omitempty"`   // as Node.Line
+	Parent        int    `json:"parent,omitempty"` // the parent's Line
+	Message       string `json:"message,omitempty"`
+}
+
+// Formats lists the values accepted by Write.
+var Formats = []string{"text", "json", "ndjson"}
+
+// NewDocument builds a Document from a flat todo list, as returned by
+// parser.ParseTodosWithWarnings.
+func NewDocument(file string, todos []parser.Todo, warnings []string) Document {
+	doc := Document{
+		SchemaVersion: SchemaVersion,
+		File:          file,
+		Todos:         nodes(parser.BuildTree(todos), 0),
+		Warnings:      warnings,
+	}
+	if doc.Todos == nil {
+		doc.Todos = []Node{}
+	}
+	if doc.Warnings == nil {
+		doc.Warnings = []string{}
+	}
+	return doc
+}
+
+func nodes(todos []*parser.Todo, depth int) []Node {
+	var out []Node
+	for _, t := range todos {
+		n := Node{
+			Text:        t.Text,
+			State:       t.State.String(),
+			Highlighted: t.Highlighted,
+			Depth:       depth,
+			Block:       t.Block,
+			Line:        t.LineNumber,
+			Children:    nodes(t.Children, depth+1),
+		}
+		md := parser.ParseMetadata(t.Text)
+		n.Tags, n.Fields = md.Tags, md.Fields
+		if n.Tags == nil {
+			n.Tags = []string{}
+		}
+		if n.Children == nil {
+			n.Children = []Node{}
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// Records flattens a Document into NDJSON records in document order.
+func Records(doc Document) []Record {
+	var out []Record
+	var walk func(nodes []Node, parent int)
+	walk = func(nodes []Node, parent int) {
+		for _, n := range nodes {
+			out = append(out, Record{
+				SchemaVersion: SchemaVersion,
+				Type:          "todo",
+				File:          doc.File,
+				Text:          n.Text,
+				State:         n.State,
+				Highlighted:   n.Highlighted,
+				Depth:         n.Depth,
+				Block:         n.Block,
+				Line:          n.Line,
+				Parent:        parent,
+			})
+			walk(n.Children, n.Line)
+		}
+	}
+	walk(doc.Todos, 0)
+	for _, w := range doc.Warnings {
+		out = append(out, Record{
+			SchemaVersion: SchemaVersion,
+			Type:          "warning",
+			File:          doc.File,
+			Message:       w,
+		})
+	}
+	return out
+}
+
+// NewRecord describes a single tree node, e.g. a query match. date is the
+// date of the daily file the todo came from and may be zero.
+func NewRecord(file string, date time.Time, t *parser.Todo) Record {
+	r := Record{
+		SchemaVersion: SchemaVersion,
+		Type:          "todo",
+		File:          file,
+		Text:          t.Text,
+		State:         t.State.String(),
+		Highlighted:   t.Highlighted,
+		Block:         t.Block,
+		Line:          t.LineNumber,
+	}
+	if !date.IsZero() {
+		r.Date = date.Format(parser.DateLayout)
+	}
+	if t.Parent != nil {
+		r.Parent = t.Parent.LineNumber
+	}
+	for p := t.Parent; p != nil; p = p.Parent {
+		r.Depth++
+	}
+	return r
+}
+
+// Write renders doc to w in the named format.
+func Write(w io.Writer, format string, doc Document) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(doc)
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		for _, r := range Records(doc) {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "text", "":
+		return writeText(w, doc)
+	}
+	return fmt.Errorf("unknown format %q (want one of %s)", format, strings.Join(Formats, ", "))
+}
+
+func writeText(w io.Writer, doc Document) error {
+	var walk func(nodes []Node) error
+	walk = func(nodes []Node) error {
+		for _, n := range nodes {
+			text := n.Text
+			if n.Highlighted {
+				text += " *"
+			}
+			if _, err := fmt.Fprintf(w, "%s- [%s] %s\n", strings.Repeat("  ", n.Depth), Marker(n.State), text); err != nil {
+				return err
+			}
+			if err := walk(n.Children); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(doc.Todos); err != nil {
+		return err
+	}
+	for _, warn := range doc.Warnings {
+		if _, err := fmt.Fprintf(w, "Warning: %s\n", warn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Marker returns the checkbox character for a state name.
+func Marker(state string) string {
+	if s, err := parser.ParseState(state); err == nil {
+		return s.Def().Marker
+	}
+	return " "
+}
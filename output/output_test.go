@@ -0,0 +1,97 @@
+package output_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"td-file/output"
+	"td-file/parser"
+)
+
+func sampleDoc() output.Document {
+	blocks := [][]string{{
+		"- [ ] Parent *",
+		"  - [x] Child",
+		"not a todo",
+		"- [>] Pushed",
+	}}
+	todos, warnings := parser.ParseTodosWithWarnings(blocks)
+	return output.NewDocument("todos.md", todos, warnings)
+}
+
+func TestNewDocument(t *testing.T) {
+	doc := sampleDoc()
+	if doc.SchemaVersion != output.SchemaVersion {
+		t.Errorf("schema version = %d, want %d", doc.SchemaVersion, output.SchemaVersion)
+	}
+	if len(doc.Todos) != 2 {
+		t.Fatalf("expected 2 roots, got %d", len(doc.Todos))
+	}
+	parent := doc.Todos[0]
+	if parent.Text != "Parent" || !parent.Highlighted || parent.State != "incomplete" {
+		t.Errorf("unexpected parent node: %+v", parent)
+	}
+	if len(parent.Children) != 1 || parent.Children[0].State != "completed" || parent.Children[0].Depth != 1 {
+		t.Errorf("unexpected children: %+v", parent.Children)
+	}
+	if len(doc.Warnings) != 1 {
+		t.Errorf("expected 1 warning, got %v", doc.Warnings)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := output.Write(&buf, "json", sampleDoc()); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	var got output.Document
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if got.Todos[1].Text != "Pushed" || got.Todos[1].State != "pushed" {
+		t.Errorf("unexpected second root: %+v", got.Todos[1])
+	}
+}
+
+func TestWriteJSON_EmptyIsArrays(t *testing.T) {
+	var buf bytes.Buffer
+	if err := output.Write(&buf, "json", output.NewDocument("x.md", nil, nil)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"todos": []`) || !strings.Contains(buf.String(), `"warnings": []`) {
+		t.Errorf("expected empty arrays rather than null, got %s", buf.String())
+	}
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := output.Write(&buf, "ndjson", sampleDoc()); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 records, got %d:\n%s", len(lines), buf.String())
+	}
+	var child output.Record
+	if err := json.Unmarshal([]byte(lines[1]), &child); err != nil {
+		t.Fatalf("invalid record: %v", err)
+	}
+	if child.Type != "todo" || child.Text != "Child" || child.Parent != 1 {
+		t.Errorf("unexpected child record: %+v", child)
+	}
+	var warn output.Record
+	if err := json.Unmarshal([]byte(lines[3]), &warn); err != nil {
+		t.Fatalf("invalid record: %v", err)
+	}
+	if warn.Type != "warning" || warn.Message == "" {
+		t.Errorf("unexpected warning record: %+v", warn)
+	}
+}
+
+func TestWrite_UnknownFormat(t *testing.T) {
+	if err := output.Write(&bytes.Buffer{}, "yaml", sampleDoc()); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
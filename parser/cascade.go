@@ -0,0 +1,57 @@
+package parser
+
+// Cascade selects which state changes spread through the tree. The zero
+// value changes only the todo itself.
+type Cascade struct {
+	// Down applies completing or cancelling a todo to its open
+	// descendants.
+	Down bool `yaml:"down"`
+	// Push applies pushing a todo to its open descendants.
+	Push bool `yaml:"push"`
+	// Reopen reopens the completed ancestors of a todo that is reopened.
+	Reopen bool `yaml:"reopen"`
+}
+
+// Change records a todo changed by SetStateCascade and its previous state.
+type Change struct {
+	Todo *Todo
+	Prev TodoState
+}
+
+// open reports whether a todo is still to be done here: not closed and not
+// pushed to another day.
+func open(t *Todo) bool {
+	return !t.State.Closed() && t.State != Pushed
+}
+
+// SetStateCascade puts t into state, then applies rules to the rest of its
+// tree. It returns every todo whose state changed, t first.
+func SetStateCascade(t *Todo, state TodoState, rules Cascade) []Change {
+	var changes []Change
+	set := func(n *Todo, s TodoState) {
+		if n.State != s {
+			changes = append(changes, Change{Todo: n, Prev: n.State})
+			SetState(n, s)
+		}
+	}
+	set(t, state)
+	switch {
+	case rules.Down && (state == Completed || state == Cancelled),
+		rules.Push && state == Pushed:
+		var walk func(nodes []*Todo)
+		walk = func(nodes []*Todo) {
+			for _, c := range nodes {
+				if open(c) {
+					set(c, state)
+				}
+				walk(c.Children)
+			}
+		}
+		walk(t.Children)
+	case rules.Reopen && open(t):
+		for p := t.Parent; p != nil && p.State == Completed; p = p.Parent {
+			set(p, Incomplete)
+		}
+	}
+	return changes
+}
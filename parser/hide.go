@@ -0,0 +1,50 @@
+package parser
+
+// DefaultHidden are the states hidden when hiding done todos, unless
+// configured otherwise.
+var DefaultHidden = []TodoState{Completed, Cancelled, Pushed}
+
+// Hidden returns the todos under roots that a view hiding states leaves
+// out. A todo in one of the states is hidden only if all of its descendants
+// are too, so open work is never hidden beneath a finished parent.
+func Hidden(roots []*Todo, states map[TodoState]bool) map[*Todo]bool {
+	hidden := make(map[*Todo]bool)
+	var walk func(n *Todo) bool
+	walk = func(n *Todo) bool {
+		all := true
+		for _, c := range n.Children {
+			if !walk(c) {
+				all = false
+			}
+		}
+		if all && states[n.State] {
+			hidden[n] = true
+		}
+		return hidden[n]
+	}
+	for _, r := range roots {
+		walk(r)
+	}
+	return hidden
+}
+
+// Visible returns the todos in flat that a view hiding states shows, in
+// order.
+func Visible(flat []Todo, states map[TodoState]bool) []Todo {
+	roots := BuildTree(flat)
+	hidden := Hidden(roots, states)
+	var out []Todo
+	i := 0
+	var walk func(nodes []*Todo)
+	walk = func(nodes []*Todo) {
+		for _, n := range nodes {
+			if !hidden[n] {
+				out = append(out, flat[i])
+			}
+			i++
+			walk(n.Children)
+		}
+	}
+	walk(roots)
+	return out
+}
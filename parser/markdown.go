@@ -0,0 +1,182 @@
+package parser
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Block is a :td block in a markdown document.
+type Block struct {
+	// Name is given on the opening line, as in ":td Work".
+	Name string
+	// Title is the nearest heading above the block, if any.
+	Title string
+	// Start and End are the 0-based line numbers of the opening and closing
+	// :td lines.
+	Start, End int
+	Lines      []string
+}
+
+var (
+	atxHeadingRe    = regexp.MustCompile(`^ {0,3}#{1,6}(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	setextUnderline = regexp.MustCompile(`^ {0,3}(?:=+|-+)[ \t]*$`)
+	fenceRe         = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})(.*)$")
+)
+
+// marker reports whether line is a :td marker, rather than an indented code
+// block that happens to contain one, and returns the block name after it.
+func marker(line string) (name string, ok bool) {
+	trimmed := strings.TrimLeft(line, " \t")
+	if IndentWidth(line[:len(line)-len(trimmed)]) >= 4 {
+		return "", false
+	}
+	trimmed = strings.TrimSpace(trimmed)
+	if trimmed == ":td" {
+		return "", true
+	}
+	if rest, found := strings.CutPrefix(trimmed, ":td"); found && (rest[0] == ' ' || rest[0] == '\t') {
+		return strings.TrimSpace(rest), true
+	}
+	return "", false
+}
+
+// Label returns the name of the block, or its title if it has no name.
+func (b Block) Label() string {
+	if b.Name != "" {
+		return b.Name
+	}
+	return b.Title
+}
+
+// frontMatterEnd returns the line number of the line closing the YAML front
+// matter at the start of lines, or -1 if there is none.
+func frontMatterEnd(lines []string) int {
+	if len(lines) == 0 || strings.TrimRight(lines[0], " \t\r") != "---" {
+		return -1
+	}
+	for i := 1; i < len(lines); i++ {
+		if l := strings.TrimRight(lines[i], " \t\r"); l == "---" || l == "..." {
+			return i
+		}
+	}
+	return -1
+}
+
+// FindBlocks returns the complete :td blocks in a markdown document, and
+// whether an opening :td was left unclosed at the end. A block opens with
+// ":td" or ":td Name" and closes at the next marker. Markers inside front
+// matter, fenced or indented code blocks and HTML comments are not blocks,
+// so documentation showing td-file's own syntax is left alone.
+func FindBlocks(lines []string) (blocks []Block, unmatched bool) {
+	var (
+		fence     string // opening fence of the current code block
+		inComment bool
+		title     string
+		name      string
+		open      = -1
+	)
+	i := frontMatterEnd(lines) + 1
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if open >= 0 {
+			if _, ok := marker(line); ok {
+				blocks = append(blocks, Block{Name: name, Title: title, Start: open, End: i, Lines: lines[open+1 : i]})
+				open = -1
+			}
+			continue
+		}
+		n, isMarker := marker(line)
+		switch {
+		case fence != "":
+			if m := fenceRe.FindStringSubmatch(line); m != nil && m[1][0] == fence[0] && len(m[1]) >= len(fence) && strings.TrimSpace(m[2]) == "" {
+				fence = ""
+			}
+		case inComment:
+			inComment = !strings.Contains(line, "-->")
+		case isMarker:
+			open, name = i, n
+		default:
+			if m := fenceRe.FindStringSubmatch(line); m != nil && !(m[1][0] == '`' && strings.Contains(m[2], "`")) {
+				fence = m[1]
+			} else if at := strings.Index(line, "<!--"); at >= 0 && !strings.Contains(line[at+4:], "-->") {
+				inComment = true
+			} else if m := atxHeadingRe.FindStringSubmatch(line); m != nil {
+				title = strings.TrimSpace(m[1])
+			} else if i > 0 && setextUnderline.MatchString(line) && isParagraph(lines[i-1]) {
+				title = strings.TrimSpace(lines[i-1])
+			}
+		}
+	}
+	return blocks, open >= 0
+}
+
+// isParagraph reports whether line can be the text of a setext heading.
+func isParagraph(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	_, isMarker := marker(line)
+	return trimmed != "" && !isMarker && !todoRe.MatchString(line) &&
+		!atxHeadingRe.MatchString(line) && !setextUnderline.MatchString(line) &&
+		IndentWidth(line[:len(line)-len(strings.TrimLeft(line, " \t"))]) < 4
+}
+
+// ExtractBlocks returns the :td blocks in the file at path along with their
+// titles and positions, and warnings about unclosed blocks.
+func ExtractBlocks(path string) ([]Block, []string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	return ReadBlocks(f)
+}
+
+// ReadBlocks is ExtractBlocks for content that is not in a file.
+func ReadBlocks(r io.Reader) ([]Block, []string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	blocks, unmatched := FindBlocks(lines)
+	var warnings []string
+	if unmatched {
+		warnings = append(warnings, "Unmatched :td block at end of file ignored")
+	}
+	return blocks, warnings, nil
+}
+
+// AppendBlock adds an empty block called name (which may be empty) at the
+// end of the file at path.
+func AppendBlock(path, name string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	content := string(data)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	if content != "" && !strings.HasSuffix(content, "\n\n") {
+		content += "\n"
+	}
+	open := ":td"
+	if name = strings.TrimSpace(name); name != "" {
+		open += " " + name
+	}
+	return os.WriteFile(path, []byte(content+open+"\n:td\n"), 0644)
+}
+
+// BlockLines returns the lines of each block.
+func BlockLines(blocks []Block) [][]string {
+	out := make([][]string, len(blocks))
+	for i, b := range blocks {
+		out[i] = b.Lines
+	}
+	return out
+}
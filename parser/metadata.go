@@ -0,0 +1,233 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Metadata is the structured information embedded in a todo's text:
+// hashtags (`#work`), `key:value` fields (`due:2024-06-07`) and Obsidian
+// Tasks emoji fields (`📅 2024-06-07`), which are reported under the same keys
+// as their key:value equivalents (see obsidianKeys).
+// It is derived from Text on demand and never stored separately, so the
+// markdown remains the single source of truth.
+type Metadata struct {
+	Tags   []string
+	Fields map[string]string
+}
+
+var (
+	tagRe   = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_\-/.]+)`)
+	fieldRe = regexp.MustCompile(`(?:^|\s)([A-Za-z][A-Za-z0-9_\-]*):([^\s/][^\s]*)`)
+)
+
+// DateLayout is the format used for all dates stored in todo text.
+const DateLayout = "2006-01-02"
+
+// Syntax selects how new fields are written into todo text.
+type Syntax string
+
+const (
+	// SyntaxPlain writes `key:value`.
+	SyntaxPlain Syntax = "plain"
+	// SyntaxObsidian writes Obsidian Tasks emoji fields (`✅ 2024-06-07`)
+	// for the keys that have one, and `key:value` for the rest.
+	SyntaxObsidian Syntax = "obsidian"
+)
+
+// ParseSyntax validates a metadata_syntax setting; empty means plain.
+func ParseSyntax(name string) (Syntax, error) {
+	switch Syntax(name) {
+	case "", SyntaxPlain:
+		return SyntaxPlain, nil
+	case SyntaxObsidian:
+		return SyntaxObsidian, nil
+	}
+	return "", fmt.Errorf("unknown metadata syntax %q (want plain or obsidian)", name)
+}
+
+// FieldSyntax and StampDone are set by Configure.
+var (
+	// FieldSyntax is the syntax SetField uses for fields the text does not
+	// have yet. Existing fields are always updated in their own syntax.
+	FieldSyntax = SyntaxPlain
+	// StampDone makes SetState record the completion date in a done field,
+	// and remove it when a todo is reopened, as Obsidian Tasks does.
+	StampDone = false
+	// Now is the clock used for completion dates.
+	Now = time.Now
+)
+
+// Obsidian Tasks emoji and the field keys they map to. Dates follow the
+// emoji; 🔁 is followed by a recurrence rule; priorities are the emoji alone.
+var (
+	obsidianKeys = map[string]string{
+		"📅": "due", "📆": "due", "🗓": "due",
+		"⏳": "scheduled",
+		"🛫": "start",
+		"✅": "done",
+		"➕": "created",
+		"❌": "cancelled",
+		"🔁": "recurrence",
+	}
+	obsidianEmoji = map[string]string{
+		"due": "📅", "scheduled": "⏳", "start": "🛫", "done": "✅",
+		"created": "➕", "cancelled": "❌", "recurrence": "🔁",
+	}
+	obsidianPriorities = map[string]string{
+		"🔺": "highest", "⏫": "high", "🔼": "medium", "🔽": "low", "⏬": "lowest",
+	}
+	obsidianDateRe     = regexp.MustCompile(`(?:^|\s)(📅|📆|🗓|⏳|🛫|✅|➕|❌)\x{FE0F}?[ \t]*(\d{4}-\d{2}-\d{2})`)
+	obsidianRecurRe    = regexp.MustCompile(`(?:^|\s)(🔁)\x{FE0F}?[ \t]*((?:[^\s:#📅📆🗓⏳🛫✅➕❌🔁🔺⏫🔼🔽⏬]+)(?:[ \t]+[^\s:#📅📆🗓⏳🛫✅➕❌🔁🔺⏫🔼🔽⏬]+)*)`)
+	obsidianPriorityRe = regexp.MustCompile(`(?:^|\s)(🔺|⏫|🔼|🔽|⏬)\x{FE0F}?`)
+)
+
+// field is one field occurrence in todo text. [start, end) covers the whole
+// field including its leading space; [valStart, valEnd) covers the value,
+// which for a priority emoji is the emoji itself.
+type field struct {
+	key, value       string
+	start, end       int
+	valStart, valEnd int
+	emoji            bool
+}
+
+// fields finds every field in text, emoji fields first.
+func fields(text string) []field {
+	var out []field
+	for _, loc := range obsidianDateRe.FindAllStringSubmatchIndex(text, -1) {
+		out = append(out, field{obsidianKeys[text[loc[2]:loc[3]]], text[loc[4]:loc[5]], loc[0], loc[1], loc[4], loc[5], true})
+	}
+	for _, loc := range obsidianRecurRe.FindAllStringSubmatchIndex(text, -1) {
+		out = append(out, field{"recurrence", text[loc[4]:loc[5]], loc[0], loc[1], loc[4], loc[5], true})
+	}
+	for _, loc := range obsidianPriorityRe.FindAllStringSubmatchIndex(text, -1) {
+		out = append(out, field{"priority", obsidianPriorities[text[loc[2]:loc[3]]], loc[0], loc[1], loc[2], loc[1], true})
+	}
+	for _, loc := range fieldRe.FindAllStringSubmatchIndex(text, -1) {
+		out = append(out, field{strings.ToLower(text[loc[2]:loc[3]]), text[loc[4]:loc[5]], loc[0], loc[1], loc[4], loc[5], false})
+	}
+	return out
+}
+
+// ParseMetadata extracts tags and fields from todo text.
+// Tags are lowercased; field keys are lowercased, values are kept verbatim.
+// A key:value field wins over an emoji field for the same key.
+func ParseMetadata(text string) Metadata {
+	md := Metadata{Fields: map[string]string{}}
+	for _, m := range tagRe.FindAllStringSubmatch(text, -1) {
+		md.Tags = append(md.Tags, strings.ToLower(strings.TrimRight(m[1], ".")))
+	}
+	for _, f := range fields(text) {
+		md.Fields[f.key] = f.value
+	}
+	return md
+}
+
+// HasTag reports whether the metadata contains tag (case-insensitive, with or
+// without a leading '#').
+func (md Metadata) HasTag(tag string) bool {
+	tag = strings.ToLower(strings.TrimPrefix(tag, "#"))
+	for _, t := range md.Tags {
+		if t == tag || strings.HasPrefix(t, tag+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Date parses the named field as a YYYY-MM-DD date.
+func (md Metadata) Date(key string) (time.Time, bool) {
+	v, ok := md.Fields[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	d, err := time.ParseInLocation(DateLayout, v, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return d, true
+}
+
+// Due returns the todo's due date, if it has a valid `due:` field.
+func (md Metadata) Due() (time.Time, bool) {
+	return md.Date("due")
+}
+
+// SetField returns text with the field key set to value, replacing an
+// existing value in place (in whichever syntax it is written) or appending
+// the field in FieldSyntax. An empty value removes the field.
+func SetField(text, key, value string) string {
+	key = strings.ToLower(key)
+	for _, f := range fields(text) {
+		if f.key != key {
+			continue
+		}
+		if value == "" {
+			return strings.TrimSpace(text[:f.start] + text[f.end:])
+		}
+		if f.key == "priority" && f.emoji {
+			emoji := priorityEmoji(value)
+			if emoji == "" {
+				return SetField(strings.TrimSpace(text[:f.start]+text[f.end:]), key, value)
+			}
+			return text[:f.valStart] + emoji + text[f.valEnd:]
+		}
+		return text[:f.valStart] + value + text[f.valEnd:]
+	}
+	if value == "" {
+		return text
+	}
+	add := key + ":" + value
+	if FieldSyntax == SyntaxObsidian {
+		if emoji, ok := obsidianEmoji[key]; ok {
+			add = emoji + " " + value
+		} else if emoji := priorityEmoji(value); key == "priority" && emoji != "" {
+			add = emoji
+		}
+	}
+	if text = strings.TrimSpace(text); text == "" {
+		return add
+	}
+	return text + " " + add
+}
+
+func priorityEmoji(level string) string {
+	for emoji, l := range obsidianPriorities {
+		if l == strings.ToLower(level) {
+			return emoji
+		}
+	}
+	return ""
+}
+
+// stampDone adds or removes the completion date when a todo enters or
+// leaves the completed state.
+func stampDone(t *Todo, prev TodoState) {
+	if !StampDone || prev == t.State {
+		return
+	}
+	switch {
+	case t.State == Completed:
+		t.Text = SetField(t.Text, "done", Now().Format(DateLayout))
+	case prev == Completed:
+		t.Text = SetField(t.Text, "done", "")
+	}
+}
+
+// StripFields returns text without the named key:value fields, for display
+// in places where those fields are shown separately.
+func StripFields(text string, keys ...string) string {
+	for _, key := range keys {
+		for {
+			stripped := SetField(text, key, "")
+			if stripped == text {
+				break
+			}
+			text = stripped
+		}
+	}
+	return strings.Join(strings.Fields(text), " ")
+}
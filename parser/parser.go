@@ -1,8 +1,8 @@
 package parser
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
 	"strings"
@@ -17,19 +17,107 @@ const (
 	Pushed
 )
 
+// String returns the name of the state as used in CLI output.
+func (s TodoState) String() string {
+	return s.Def().Name
+}
+
+// ParseState is the inverse of TodoState.String. It also accepts a few
+// aliases, such as "open" and "done".
+func ParseState(name string) (TodoState, error) {
+	return lookupState(states, name)
+}
+
 type Todo struct {
 	ID          int
 	Text        string
 	State       TodoState
 	IndentLevel int
 	LineNumber  int
+	Block       int
 	Children    []*Todo
 	Parent      *Todo
 	Collapsed   bool
 	Highlighted bool
+	// Marker is the list marker as written ("-", "*", "+", "1." or "1)");
+	// empty means "-".
+	Marker string
+	// Indent is the leading whitespace as written. IndentLevel is its width
+	// in columns, with tabs expanded to TabWidth.
+	Indent string
+	// Box is the checkbox character of a todo in the Unknown state.
+	Box string
 }
 
-var todoRe = regexp.MustCompile(`^(\s*)- \[( |x|\-|>)\] (.*)$`)
+// TabWidth is the number of columns a tab in indentation advances to, as in
+// CommonMark. It is set by Configure.
+var TabWidth = defaultTabWidth
+
+const defaultTabWidth = 4
+
+var todoRe = regexp.MustCompile(`^([ \t]*)([-*+]|\d{1,9}[.)]) \[([^\]])\] (.*)$`)
+
+// IndentWidth returns the width in columns of leading whitespace, expanding
+// tabs to the next multiple of TabWidth.
+func IndentWidth(indent string) int {
+	width := 0
+	for _, r := range indent {
+		if r == '\t' {
+			width += TabWidth - width%TabWidth
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+// indentFor returns t.Indent if it still has t.IndentLevel columns, and
+// otherwise whitespace of that width in the same style: tabs if t.Indent
+// used them, spaces if not.
+func indentFor(t Todo) string {
+	if IndentWidth(t.Indent) == t.IndentLevel {
+		return t.Indent
+	}
+	if strings.Contains(t.Indent, "\t") {
+		return strings.Repeat("\t", t.IndentLevel/TabWidth) + strings.Repeat(" ", t.IndentLevel%TabWidth)
+	}
+	return strings.Repeat(" ", t.IndentLevel)
+}
+
+// parseLine parses one line of a :td block, reporting false for lines that
+// are not todos.
+func parseLine(line string) (Todo, bool) {
+	m := todoRe.FindStringSubmatch(line)
+	if m == nil {
+		return Todo{}, false
+	}
+	state, _ := StateForMarker(m[3])
+	box := ""
+	if state == Unknown {
+		box = m[3]
+	}
+	text := m[4]
+	highlighted := false
+	if strings.HasSuffix(strings.TrimSpace(text), "*") {
+		highlighted = true
+		text = strings.TrimSpace(text)
+		text = strings.TrimSuffix(text, "*")
+		text = strings.TrimSpace(text)
+	}
+	marker := m[2]
+	if marker == "-" {
+		marker = ""
+	}
+	return Todo{
+		Text:        text,
+		State:       state,
+		IndentLevel: IndentWidth(m[1]),
+		Highlighted: highlighted,
+		Marker:      marker,
+		Indent:      m[1],
+		Box:         box,
+	}, true
+}
 
 // Extracts all complete :td blocks, tolerating odd numbers (ignores unmatched)
 func ExtractTdBlocks(path string) ([][]string, error) {
@@ -38,109 +126,30 @@ func ExtractTdBlocks(path string) ([][]string, error) {
 		return nil, err
 	}
 	defer f.Close()
-	var blocks [][]string
-	scanner := bufio.NewScanner(f)
-	var inBlock bool
-	var block []string
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == ":td" {
-			if inBlock {
-				blocks = append(blocks, block)
-				block = nil
-				inBlock = false
-			} else {
-				inBlock = true
-				block = nil
-			}
-		} else if inBlock {
-			block = append(block, line)
-		}
-	}
-	if err := scanner.Err(); err != nil {
+	return ReadTdBlocks(f)
+}
+
+// ReadTdBlocks is ExtractTdBlocks for content that is not in a file, such as
+// an older revision read from git.
+func ReadTdBlocks(r io.Reader) ([][]string, error) {
+	blocks, _, err := ReadBlocks(r)
+	if err != nil {
 		return nil, err
 	}
-	return blocks, nil
+	return BlockLines(blocks), nil
 }
 
 // Defensive extractTdBlocks returns blocks and warnings
 func ExtractTdBlocksWithWarnings(path string) ([][]string, []string, error) {
-	f, err := os.Open(path)
+	blocks, warnings, err := ExtractBlocks(path)
 	if err != nil {
 		return nil, nil, err
 	}
-	defer f.Close()
-	var blocks [][]string
-	scanner := bufio.NewScanner(f)
-	var inBlock bool
-	var block []string
-	var warnings []string
-	blockCount := 0
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == ":td" {
-			if inBlock {
-				blocks = append(blocks, block)
-				block = nil
-				inBlock = false
-				blockCount++
-			} else {
-				inBlock = true
-				block = nil
-			}
-		} else if inBlock {
-			block = append(block, line)
-		}
-	}
-	if inBlock {
-		warnings = append(warnings, "Unmatched :td block at end of file ignored")
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, nil, err
-	}
-	return blocks, warnings, nil
+	return BlockLines(blocks), warnings, nil
 }
 
 func ParseTodos(blocks [][]string) []Todo {
-	var todos []Todo
-	lineNum := 0
-	for _, block := range blocks {
-		for _, line := range block {
-			lineNum++
-			m := todoRe.FindStringSubmatch(line)
-			if m == nil {
-				continue
-			}
-			indent := len(m[1])
-			var state TodoState
-			switch m[2] {
-			case " ":
-				state = Incomplete
-			case "x":
-				state = Completed
-			case "-":
-				state = Cancelled
-			case ">":
-				state = Pushed
-			}
-			text := m[3]
-			highlighted := false
-			if strings.HasSuffix(strings.TrimSpace(text), "*") {
-				highlighted = true
-				text = strings.TrimSpace(text)
-				text = strings.TrimSuffix(text, "*")
-				text = strings.TrimSpace(text)
-			}
-			todos = append(todos, Todo{
-				ID:          lineNum,
-				Text:        text,
-				State:       state,
-				IndentLevel: indent,
-				LineNumber:  lineNum,
-				Highlighted: highlighted,
-			})
-		}
-	}
+	todos, _ := ParseTodosWithWarnings(blocks)
 	return todos
 }
 
@@ -152,92 +161,70 @@ func ParseTodosWithWarnings(blocks [][]string) ([]Todo, []string) {
 	for blockIdx, block := range blocks {
 		for _, line := range block {
 			lineNum++
-			m := todoRe.FindStringSubmatch(line)
-			if m == nil {
+			t, ok := parseLine(line)
+			if !ok {
 				if strings.TrimSpace(line) != "" {
 					warnings = append(warnings, fmt.Sprintf("Malformed todo in block %d, line %d: '%s'", blockIdx+1, lineNum, line))
 				}
 				continue
 			}
-			indent := len(m[1])
-			var state TodoState
-			switch m[2] {
-			case " ":
-				state = Incomplete
-			case "x":
-				state = Completed
-			case "-":
-				state = Cancelled
-			case ">":
-				state = Pushed
-			}
-			text := m[3]
-			highlighted := false
-			if strings.HasSuffix(strings.TrimSpace(text), "*") {
-				highlighted = true
-				text = strings.TrimSpace(text)
-				text = strings.TrimSuffix(text, "*")
-				text = strings.TrimSpace(text)
-			}
-			todos = append(todos, Todo{
-				ID:          lineNum,
-				Text:        text,
-				State:       state,
-				IndentLevel: indent,
-				LineNumber:  lineNum,
-				Highlighted: highlighted,
-			})
+			t.ID = lineNum
+			t.LineNumber = lineNum
+			t.Block = blockIdx
+			todos = append(todos, t)
 		}
 	}
 	return todos, warnings
 }
 
+// FormatTodo renders a todo as the markdown line stored in the file, with
+// its original list marker and indentation.
+func FormatTodo(t Todo) string {
+	marker := t.Marker
+	if marker == "" {
+		marker = "-"
+	}
+	state := t.State.Def().Marker
+	if t.State == Unknown && t.Box != "" {
+		state = t.Box
+	} else if state == "" {
+		state = " "
+	}
+	text := t.Text
+	if t.Highlighted {
+		text = strings.TrimSpace(text) + " *"
+	}
+	return fmt.Sprintf("%s%s [%s] %s", indentFor(t), marker, state, text)
+}
+
+// WriteTodosToFile replaces the contents of every :td block in path with
+// todos, keeping each todo in the block given by its Block field. Todos whose
+// block no longer exists are written to the last block.
 func WriteTodosToFile(path string, todos []Todo) {
 	input, err := os.ReadFile(path)
 	if err != nil {
 		return
 	}
 	lines := strings.Split(string(input), "\n")
+	blocks, _ := FindBlocks(lines)
+	if len(blocks) == 0 {
+		return
+	}
+	byBlock := make([][]Todo, len(blocks))
+	for _, t := range todos {
+		b := min(max(t.Block, 0), len(blocks)-1)
+		byBlock[b] = append(byBlock[b], t)
+	}
 	var out []string
-	inBlock := false
-	blockIdx := 0
-	curTodo := 0
-	for i := 0; i < len(lines); i++ {
-		line := lines[i]
-		if strings.TrimSpace(line) == ":td" {
-			if !inBlock {
-				inBlock = true
-				out = append(out, line)
-				for curTodo < len(todos) && (blockIdx == 0 || todos[curTodo].LineNumber > 0) {
-					indent := strings.Repeat(" ", todos[curTodo].IndentLevel)
-					state := " "
-					switch todos[curTodo].State {
-					case Completed:
-						state = "x"
-					case Cancelled:
-						state = "-"
-					case Pushed:
-						state = ">"
-					}
-					text := todos[curTodo].Text
-					if todos[curTodo].Highlighted {
-						text = strings.TrimSpace(text) + " *"
-					}
-					out = append(out, fmt.Sprintf("%s- [%s] %s", indent, state, text))
-					curTodo++
-				}
-				blockIdx++
-				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != ":td" {
-					i++
-				}
-			} else {
-				inBlock = false
-				out = append(out, line)
-			}
-		} else if !inBlock {
-			out = append(out, line)
+	next := 0
+	for i, b := range blocks {
+		out = append(out, lines[next:b.Start+1]...)
+		for _, t := range byBlock[i] {
+			out = append(out, FormatTodo(t))
 		}
+		next = b.End
 	}
+	out = append(out, lines[next:]...)
 	os.WriteFile(path, []byte(strings.Join(out, "\n")), 0644)
 }
 
@@ -246,7 +233,9 @@ func WriteTodosToFile(path string, todos []Todo) {
 
 // Add mutation helpers for todos
 func SetState(todo *Todo, state TodoState) {
+	prev := todo.State
 	todo.State = state
+	stampDone(todo, prev)
 	if state != Incomplete {
 		todo.Highlighted = false
 	}
@@ -289,12 +278,19 @@ func BuildTree(flat []Todo) []*Todo {
 			State:       flat[i].State,
 			IndentLevel: flat[i].IndentLevel,
 			LineNumber:  flat[i].LineNumber,
+			Block:       flat[i].Block,
 			Highlighted: flat[i].Highlighted,
+			Marker:      flat[i].Marker,
+			Indent:      flat[i].Indent,
+			Box:         flat[i].Box,
 		}
 	}
 	var roots []*Todo
 	var stack []*Todo
 	for _, t := range treeNodes {
+		if len(stack) > 0 && stack[0].Block != t.Block {
+			stack = nil // todos never nest across blocks
+		}
 		for len(stack) > 0 && t.IndentLevel <= stack[len(stack)-1].IndentLevel {
 			stack = stack[:len(stack)-1]
 		}
@@ -321,3 +317,116 @@ func SetHighlight(todo *Todo, highlight bool) {
 		todo.Highlighted = highlight
 	}
 }
+
+// SingleLine reports whether text fits on one todo line, i.e. holds no line
+// breaks.
+func SingleLine(text string) bool {
+	return !strings.ContainsAny(text, "\r\n")
+}
+
+// NextLineNumber returns a LineNumber above every one in todos, for todos
+// added to the list before it is written and parsed again.
+func NextLineNumber(todos []Todo) int {
+	next := 1
+	for _, t := range todos {
+		if t.LineNumber >= next {
+			next = t.LineNumber + 1
+		}
+	}
+	return next
+}
+
+// InsertIntoBlock places todos at the end of the given :td block (0-based)
+// of an existing flat todo list. They are numbered from NextLineNumber, so
+// the existing todos keep unique line numbers.
+func InsertIntoBlock(existing, todos []Todo, block int) []Todo {
+	at := len(existing)
+	for i, t := range existing {
+		if t.Block > block {
+			at = i
+			break
+		}
+	}
+	next := NextLineNumber(existing)
+	out := append([]Todo{}, existing[:at]...)
+	for i, t := range todos {
+		t.Block = block
+		t.LineNumber = next + i
+		out = append(out, t)
+	}
+	return append(out, existing[at:]...)
+}
+
+// Flatten converts a tree back into the flat, document-ordered list used by
+// WriteTodosToFile, recomputing IndentLevel from each node's depth (see
+// ChildIndent).
+func Flatten(roots []*Todo) []Todo {
+	var out []Todo
+	var walk func(nodes []*Todo, parent *Todo, indent int)
+	walk = func(nodes []*Todo, parent *Todo, indent int) {
+		for _, n := range nodes {
+			t := *n
+			t.IndentLevel = ChildIndent(parent, n, indent)
+			t.Children = nil
+			t.Parent = nil
+			out = append(out, t)
+			walk(n.Children, n, t.IndentLevel)
+		}
+	}
+	walk(roots, nil, 0)
+	return out
+}
+
+// ChildIndent returns the IndentLevel for n under parent once parent has
+// been placed at parentIndent. Roots go to 0. A child still under its
+// original parent keeps its offset from it, so an unchanged tree keeps its
+// indentation; any other child is indented one step further than parent: a
+// tab if n was tab-indented, two spaces otherwise.
+func ChildIndent(parent, n *Todo, parentIndent int) int {
+	if parent == nil {
+		return 0
+	}
+	if n.Parent == parent && n.IndentLevel > parent.IndentLevel {
+		return parentIndent + n.IndentLevel - parent.IndentLevel
+	}
+	if strings.Contains(n.Indent, "\t") {
+		return parentIndent + TabWidth
+	}
+	return parentIndent + 2
+}
+
+// Find returns the node in the tree with the given LineNumber, or nil.
+func Find(roots []*Todo, line int) *Todo {
+	for _, n := range roots {
+		if n.LineNumber == line {
+			return n
+		}
+		if found := Find(n.Children, line); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Nest sets the indentation and list marker of t for adding it as the last
+// child of parent, following parent's existing children if it has any.
+func Nest(parent, t *Todo) {
+	if n := len(parent.Children); n > 0 {
+		last := parent.Children[n-1]
+		t.IndentLevel, t.Indent, t.Marker = last.IndentLevel, last.Indent, last.Marker
+		return
+	}
+	t.Indent = parent.Indent + "  "
+	if strings.Contains(parent.Indent, "\t") {
+		t.Indent = parent.Indent + "\t"
+	}
+	t.IndentLevel = IndentWidth(t.Indent)
+	if parent.IndentLevel != IndentWidth(parent.Indent) {
+		t.Indent, t.IndentLevel = "", parent.IndentLevel+2
+	}
+	if strings.ContainsAny(parent.Marker, ".)") {
+		t.Marker = "1" + parent.Marker[len(parent.Marker)-1:]
+	} else {
+		t.Marker = parent.Marker
+	}
+}
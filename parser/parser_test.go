@@ -3,9 +3,11 @@ package parser_test
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"td-file/parser"
 )
@@ -193,7 +195,7 @@ func TestEdgeCases(t *testing.T) {
 			"- [ ] Good todo",
 			"- [x] Also good",
 			"not a todo line",
-			"- [z] Invalid state",
+			"- [] Empty box",
 			"   - [ ] Nested good",
 		}}
 		todos := parser.ParseTodos(blocks)
@@ -246,6 +248,10 @@ func TestTodoRegex(t *testing.T) {
 		"- [x] Test 2",
 		"- [-] Test 3",
 		"- [>] Test 4",
+		"* [ ] Test 5",
+		"+ [x] Test 6",
+		"1. [ ] Test 7",
+		"\t12) [-] Test 8",
 	}
 	for _, tc := range testCases {
 		if !parser.TodoRe().MatchString(tc) {
@@ -417,3 +423,525 @@ func TestCompleteParentAndPreserveChildren(t *testing.T) {
 		t.Errorf("grandchild text after complete = %q, want 'Grandchild 1.1'", parent2.Children[0].Children[0].Text)
 	}
 }
+
+func TestParseMetadata(t *testing.T) {
+	md := parser.ParseMetadata("Ship release #Work #team/backend due:2024-06-07 owner:sam see http://example.com")
+	if !reflect.DeepEqual(md.Tags, []string{"work", "team/backend"}) {
+		t.Errorf("tags = %v", md.Tags)
+	}
+	if md.Fields["owner"] != "sam" || md.Fields["due"] != "2024-06-07" {
+		t.Errorf("fields = %v", md.Fields)
+	}
+	if _, ok := md.Fields["http"]; ok {
+		t.Errorf("URL scheme should not be parsed as a field: %v", md.Fields)
+	}
+	if !md.HasTag("#work") || !md.HasTag("team") || md.HasTag("home") {
+		t.Errorf("HasTag mismatch for %v", md.Tags)
+	}
+	due, ok := md.Due()
+	if !ok || due.Format(parser.DateLayout) != "2024-06-07" {
+		t.Errorf("due = %v, %v", due, ok)
+	}
+	if _, ok := parser.ParseMetadata("due:soon").Due(); ok {
+		t.Error("invalid due date should not parse")
+	}
+}
+
+func TestSetField(t *testing.T) {
+	cases := []struct{ text, key, value, want string }{
+		{"Pay rent due:2024-06-01 #home", "due", "2024-07-01", "Pay rent due:2024-07-01 #home"},
+		{"Pay rent", "due", "2024-07-01", "Pay rent due:2024-07-01"},
+		{"Pay rent DUE:2024-06-01 #home", "due", "", "Pay rent #home"},
+		{"Pay rent", "uid", "", "Pay rent"},
+	}
+	for _, c := range cases {
+		if got := parser.SetField(c.text, c.key, c.value); got != c.want {
+			t.Errorf("SetField(%q, %q, %q) = %q, want %q", c.text, c.key, c.value, got, c.want)
+		}
+	}
+	if got := parser.StripFields("uid:1 Pay due:x  rent uid:2", "uid", "due"); got != "Pay rent" {
+		t.Errorf("StripFields = %q", got)
+	}
+}
+
+func TestParseMetadata_Obsidian(t *testing.T) {
+	text := "Water plants 🔁 every week on Monday ⏫ 🛫 2024-06-01 ⏳ 2024-06-02 📅 2024-06-03 ✅ 2024-06-04 #home"
+	md := parser.ParseMetadata(text)
+	want := map[string]string{
+		"recurrence": "every week on Monday",
+		"priority":   "high",
+		"start":      "2024-06-01",
+		"scheduled":  "2024-06-02",
+		"due":        "2024-06-03",
+		"done":       "2024-06-04",
+	}
+	if !reflect.DeepEqual(md.Fields, want) {
+		t.Errorf("fields = %v, want %v", md.Fields, want)
+	}
+	if !md.HasTag("home") {
+		t.Errorf("tags = %v", md.Tags)
+	}
+	if due, ok := md.Due(); !ok || due.Format(parser.DateLayout) != "2024-06-03" {
+		t.Errorf("emoji due date not used: %v, %v", due, ok)
+	}
+
+	// Emoji fields are edited in place and stripped like key:value ones.
+	if got := parser.SetField(text, "due", "2024-07-01"); got != strings.Replace(text, "📅 2024-06-03", "📅 2024-07-01", 1) {
+		t.Errorf("SetField(due) = %q", got)
+	}
+	if got := parser.SetField("Pay ⏫ rent", "priority", "low"); got != "Pay 🔽 rent" {
+		t.Errorf("SetField(priority) = %q", got)
+	}
+	if got := parser.StripFields(text, "recurrence", "priority", "start", "scheduled", "due", "done"); got != "Water plants #home" {
+		t.Errorf("StripFields = %q", got)
+	}
+}
+
+func TestWriteTodosToFile_ObsidianRoundTrip(t *testing.T) {
+	content := "# Notes\n:td\n- [x] Water plants 🔁 every week ⏫ 📅 2024-06-03 ✅ 2024-06-04\n  - [ ] Buy soil 🗓️ 2024-06-05 ⏳2024-06-01\n:td\n"
+	path := filepath.Join(t.TempDir(), "todos.md")
+	os.WriteFile(path, []byte(content), 0644)
+	blocks, err := parser.ExtractTdBlocks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	todos := parser.ParseTodos(blocks)
+	if got := parser.ParseMetadata(todos[1].Text).Fields; got["due"] != "2024-06-05" || got["scheduled"] != "2024-06-01" {
+		t.Errorf("fields = %v", got)
+	}
+	parser.WriteTodosToFile(path, todos)
+	if got, _ := os.ReadFile(path); string(got) != content {
+		t.Errorf("round trip changed the file:\n%s", got)
+	}
+}
+
+func TestWriteTodosToFile_ListMarkersAndTabs(t *testing.T) {
+	content := ":td\n* [ ] Trip *\n\t+ [x] Book flights\n\t\t1. [ ] Pack\n\t\t2) [>] Passport\n  - [ ] Spaces\n3. [-] Last\n:td\n"
+	path := filepath.Join(t.TempDir(), "todos.md")
+	os.WriteFile(path, []byte(content), 0644)
+	blocks, _ := parser.ExtractTdBlocks(path)
+	todos, warnings := parser.ParseTodosWithWarnings(blocks)
+	if len(warnings) != 0 || len(todos) != 6 {
+		t.Fatalf("got %d todos, warnings %v", len(todos), warnings)
+	}
+	if todos[2].IndentLevel != 8 || todos[2].Marker != "1." || todos[2].Indent != "\t\t" {
+		t.Errorf("unexpected %+v", todos[2])
+	}
+	roots := parser.BuildTree(todos)
+	if len(roots) != 2 || len(roots[0].Children) != 2 || len(roots[0].Children[0].Children) != 2 {
+		t.Fatalf("wrong nesting")
+	}
+	parser.WriteTodosToFile(path, parser.Flatten(roots))
+	if got, _ := os.ReadFile(path); string(got) != content {
+		t.Errorf("round trip changed the file:\n%s", got)
+	}
+
+	// A reparented todo keeps its marker and indents with tabs.
+	child := roots[0].Children[0].Children[1]
+	parser.DeleteNode(roots[0].Children[0], 1)
+	parser.AddChild(roots[0], child)
+	parser.WriteTodosToFile(path, parser.Flatten(roots))
+	want := ":td\n* [ ] Trip *\n\t+ [x] Book flights\n\t\t1. [ ] Pack\n  - [ ] Spaces\n\t2) [>] Passport\n3. [-] Last\n:td\n"
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Errorf("got:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestStates_ExtendedAndUnknownMarkers(t *testing.T) {
+	content := ":td\n- [/] Draft\n- [?] Ask\n- [!] Urgent\n- [w] Reply\n- [z] Custom\n  - [~] Later\n:td\n"
+	path := filepath.Join(t.TempDir(), "todos.md")
+	os.WriteFile(path, []byte(content), 0644)
+	blocks, _ := parser.ExtractTdBlocks(path)
+	todos, warnings := parser.ParseTodosWithWarnings(blocks)
+	if len(warnings) != 0 || len(todos) != 6 {
+		t.Fatalf("got %d todos, warnings %v", len(todos), warnings)
+	}
+	want := []parser.TodoState{parser.InProgress, parser.Question, parser.Important, parser.Waiting, parser.Unknown, parser.Unknown}
+	for i, s := range want {
+		if todos[i].State != s {
+			t.Errorf("%q: state %s, want %s", todos[i].Text, todos[i].State, s)
+		}
+	}
+	parser.WriteTodosToFile(path, parser.Flatten(parser.BuildTree(todos)))
+	if got, _ := os.ReadFile(path); string(got) != content {
+		t.Errorf("unknown markers not preserved:\n%s", got)
+	}
+	parser.SetState(&todos[4], parser.Completed)
+	if got := parser.FormatTodo(todos[4]); got != "- [x] Custom" {
+		t.Errorf("got %q", got)
+	}
+	if s, err := parser.ParseState("in-progress"); err != nil || s != parser.InProgress || s.String() != "in_progress" {
+		t.Errorf("ParseState(in-progress) = %v, %v", s, err)
+	}
+}
+
+func TestConfigure_States(t *testing.T) {
+	defer parser.Configure(parser.Settings{})
+	err := parser.Configure(parser.Settings{States: []parser.StateDef{
+		{Marker: "z", Name: "Snoozed", Icon: "z", Key: "x", Closed: true},
+		{Marker: "x", Icon: "☑"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := parser.StateForMarker("z")
+	if !ok || s.String() != "snoozed" || !s.Closed() {
+		t.Fatalf("custom state not registered: %v %+v", ok, s.Def())
+	}
+	if k, _ := parser.StateForKey("x"); k != s {
+		t.Errorf("key x should move to the custom state, got %s", k)
+	}
+	if parser.Completed.Def().Icon != "☑" || parser.Completed.Def().Key != "" {
+		t.Errorf("override not applied: %+v", parser.Completed.Def())
+	}
+	if got := parser.ParseTodos([][]string{{"- [z] Nap"}}); got[0].State != s {
+		t.Errorf("parsed state %s", got[0].State)
+	}
+
+	for _, bad := range [][]parser.StateDef{
+		{{Marker: "zz", Name: "two"}},
+		{{Marker: "q"}},
+		{{Marker: "x", Name: "done-ish"}},
+		{{Marker: "q", Name: "pushed"}},
+	} {
+		if err := parser.Configure(parser.Settings{Syntax: "obsidian", States: bad}); err == nil {
+			t.Errorf("expected an error for %+v", bad)
+		}
+	}
+	if parser.Completed.Def().Icon != "☑" || parser.FieldSyntax != parser.SyntaxPlain {
+		t.Error("a failed Configure should change nothing")
+	}
+}
+
+func TestNest(t *testing.T) {
+	parent := &parser.Todo{Marker: "2.", Indent: "\t", IndentLevel: 4}
+	child := &parser.Todo{}
+	parser.Nest(parent, child)
+	if child.Indent != "\t\t" || child.IndentLevel != 8 || child.Marker != "1." {
+		t.Errorf("first child: %+v", child)
+	}
+	parser.AddChild(parent, child)
+	next := &parser.Todo{}
+	parser.Nest(parent, next)
+	if next.Indent != child.Indent || next.Marker != child.Marker {
+		t.Errorf("second child should follow the first: %+v", next)
+	}
+
+	defer parser.Configure(parser.Settings{})
+	parser.Configure(parser.Settings{TabWidth: 2})
+	if got := parser.IndentWidth(" \t\t"); got != 4 {
+		t.Errorf("IndentWidth with tab width 2 = %d", got)
+	}
+}
+
+func TestSetState_StampsDoneDate(t *testing.T) {
+	defer parser.Configure(parser.Settings{})
+	defer func(now func() time.Time) { parser.Now = now }(parser.Now)
+	parser.Now = func() time.Time { return time.Date(2024, 6, 7, 12, 0, 0, 0, time.Local) }
+
+	for _, tc := range []struct{ syntax, text, done string }{
+		{"obsidian", "Ship it 📅 2024-06-07", "Ship it 📅 2024-06-07 ✅ 2024-06-07"},
+		{"plain", "Ship it due:2024-06-07", "Ship it due:2024-06-07 done:2024-06-07"},
+	} {
+		if err := parser.Configure(parser.Settings{Syntax: tc.syntax, StampDone: true}); err != nil {
+			t.Fatal(err)
+		}
+		todo := parser.Todo{Text: tc.text}
+		parser.SetState(&todo, parser.Completed)
+		if todo.Text != tc.done {
+			t.Errorf("%s: completed text = %q, want %q", tc.syntax, todo.Text, tc.done)
+		}
+		parser.SetState(&todo, parser.Completed)
+		if todo.Text != tc.done {
+			t.Errorf("%s: completing twice should not stamp twice: %q", tc.syntax, todo.Text)
+		}
+		parser.SetState(&todo, parser.Incomplete)
+		if todo.Text != tc.text {
+			t.Errorf("%s: reopened text = %q, want %q", tc.syntax, todo.Text, tc.text)
+		}
+	}
+
+	parser.Configure(parser.Settings{Syntax: "obsidian"})
+	todo := parser.Todo{Text: "Ship it"}
+	parser.SetState(&todo, parser.Completed)
+	if todo.Text != "Ship it" {
+		t.Errorf("done dates are off by default, got %q", todo.Text)
+	}
+	if err := parser.Configure(parser.Settings{Syntax: "emoji", StampDone: true}); err == nil {
+		t.Error("expected an error for an unknown syntax")
+	}
+}
+
+func TestWriteTodosToFile_KeepsBlocks(t *testing.T) {
+	tmpfile := t.TempDir() + "/todos.md"
+	initial := ":td\n- [ ] A\n:td\n\n## Later\n:td\n- [ ] B\n  - [ ] C\n:td\n"
+	if err := os.WriteFile(tmpfile, []byte(initial), 0644); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+	blocks, err := parser.ExtractTdBlocks(tmpfile)
+	if err != nil {
+		t.Fatalf("ExtractTdBlocks failed: %v", err)
+	}
+	todos := parser.ParseTodos(blocks)
+	if todos[0].Block != 0 || todos[1].Block != 1 || todos[2].Block != 1 {
+		t.Fatalf("unexpected blocks: %+v", todos)
+	}
+	todos[2].State = parser.Completed
+	todos = append(todos, parser.Todo{Text: "D", Block: 7})
+	parser.WriteTodosToFile(tmpfile, todos)
+	content, _ := os.ReadFile(tmpfile)
+	want := ":td\n- [ ] A\n:td\n\n## Later\n:td\n- [ ] B\n  - [x] C\n- [ ] D\n:td\n"
+	if string(content) != want {
+		t.Errorf("got %q, want %q", content, want)
+	}
+}
+
+func TestInsertIntoBlock(t *testing.T) {
+	existing := []parser.Todo{
+		{Text: "A", Block: 0, LineNumber: 1},
+		{Text: "B", Block: 1, LineNumber: 4},
+		{Text: "C", Block: 2, LineNumber: 5},
+	}
+	imported := []parser.Todo{{Text: "New"}, {Text: "Child", IndentLevel: 2}}
+	got := parser.InsertIntoBlock(existing, imported, 1)
+	var texts []string
+	for _, t := range got {
+		texts = append(texts, t.Text)
+	}
+	if !reflect.DeepEqual(texts, []string{"A", "B", "New", "Child", "C"}) {
+		t.Fatalf("unexpected order: %v", texts)
+	}
+	if got[2].Block != 1 || got[3].Block != 1 || got[3].IndentLevel != 2 {
+		t.Errorf("unexpected inserted todos: %+v", got[2:4])
+	}
+	// New todos are numbered above the existing ones, even when the
+	// existing numbers have gaps.
+	if got[2].LineNumber != 6 || got[3].LineNumber != 7 {
+		t.Errorf("inserted todos numbered %d, %d; want 6, 7", got[2].LineNumber, got[3].LineNumber)
+	}
+}
+
+func TestFindBlocks_MarkdownAware(t *testing.T) {
+	content := strings.Join([]string{
+		"---",
+		"title: notes",
+		":td",
+		"---",
+		"# Docs",
+		"```markdown",
+		":td",
+		"- [ ] Example only",
+		":td",
+		"```",
+		"~~~~",
+		":td",
+		"~~~",
+		"~~~~",
+		"    :td",
+		"<!-- hidden",
+		":td",
+		"-->",
+		"## Work ##",
+		":td",
+		"- [ ] Real todo",
+		":td",
+		"Home",
+		"====",
+		":td",
+		"- [x] Other",
+		":td",
+		"",
+	}, "\n")
+	path := filepath.Join(t.TempDir(), "todos.md")
+	os.WriteFile(path, []byte(content), 0644)
+
+	blocks, warnings, err := parser.ExtractBlocks(path)
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("err %v, warnings %v", err, warnings)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("expected 2 blocks, got %+v", blocks)
+	}
+	if b := blocks[0]; b.Title != "Work" || b.Start != 19 || b.End != 21 || len(b.Lines) != 1 {
+		t.Errorf("first block %+v", b)
+	}
+	if blocks[1].Title != "Home" {
+		t.Errorf("setext heading not used as title: %q", blocks[1].Title)
+	}
+
+	todos := parser.ParseTodos([][]string{blocks[0].Lines, blocks[1].Lines})
+	todos[0].Text = "Real todo, edited"
+	parser.WriteTodosToFile(path, todos)
+	want := strings.Replace(content, "- [ ] Real todo\n", "- [ ] Real todo, edited\n", 1)
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Errorf("write touched code or comments:\n%s", got)
+	}
+}
+
+func TestWriteTodosToFile_UnclosedBlockKept(t *testing.T) {
+	content := ":td\n- [ ] A\n:td\nNotes\n:td\n- [ ] Draft\nmore notes\n"
+	path := filepath.Join(t.TempDir(), "todos.md")
+	os.WriteFile(path, []byte(content), 0644)
+	blocks, warnings, _ := parser.ExtractTdBlocksWithWarnings(path)
+	if len(blocks) != 1 || len(warnings) != 1 {
+		t.Fatalf("blocks %v, warnings %v", blocks, warnings)
+	}
+	parser.WriteTodosToFile(path, parser.ParseTodos(blocks))
+	if got, _ := os.ReadFile(path); string(got) != content {
+		t.Errorf("text after an unclosed :td was lost:\n%s", got)
+	}
+}
+
+func TestNamedBlocks(t *testing.T) {
+	content := "# Today\n:td Work\n- [ ] Report\n:td\n:td\n  - [ ] Not a child of Report\n:td\n"
+	path := filepath.Join(t.TempDir(), "todos.md")
+	os.WriteFile(path, []byte(content), 0644)
+	blocks, _, err := parser.ExtractBlocks(path)
+	if err != nil || len(blocks) != 2 {
+		t.Fatalf("blocks %+v, err %v", blocks, err)
+	}
+	if b := blocks[0]; b.Name != "Work" || b.Label() != "Work" || b.Start != 1 || b.End != 3 {
+		t.Errorf("first block %+v", b)
+	}
+	if b := blocks[1]; b.Name != "" || b.Label() != "Today" {
+		t.Errorf("second block should fall back to the heading: %+v", b)
+	}
+	todos := parser.ParseTodos(parser.BlockLines(blocks))
+	if roots := parser.BuildTree(todos); len(roots) != 2 {
+		t.Errorf("todos must not nest across blocks, got %d roots", len(roots))
+	}
+	parser.WriteTodosToFile(path, todos)
+	if got, _ := os.ReadFile(path); string(got) != content {
+		t.Errorf("named block not kept:\n%s", got)
+	}
+
+	if err := parser.AppendBlock(path, "Home"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != content+"\n:td Home\n:td\n" {
+		t.Errorf("AppendBlock wrote:\n%s", got)
+	}
+}
+
+func TestVisible(t *testing.T) {
+	todos := parser.ParseTodos([][]string{{
+		"- [x] Done",
+		"  - [x] Done child",
+		"- [x] Done parent",
+		"  - [ ] Open child",
+		"  - [-] Cancelled child",
+		"- [>] Pushed",
+	}})
+	var got []string
+	for _, td := range parser.Visible(todos, map[parser.TodoState]bool{parser.Completed: true, parser.Cancelled: true}) {
+		got = append(got, td.Text)
+	}
+	if s := strings.Join(got, ","); s != "Done parent,Open child,Pushed" {
+		t.Errorf("visible todos: %s", s)
+	}
+	if n := len(parser.Visible(todos, nil)); n != len(todos) {
+		t.Errorf("hiding no states should show all %d todos, got %d", len(todos), n)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	roots := parser.BuildTree(parser.ParseTodos([][]string{{
+		"- [ ] Release",
+		"  - [x] Build",
+		"    - [x] Compile",
+		"  - [-] Announce",
+		"  - [ ] Deploy",
+		"    - [ ] Tag",
+	}}))
+	cases := []struct {
+		mode parser.CancelledMode
+		want parser.Progress
+	}{
+		{parser.CancelledExclude, parser.Progress{Done: 2, Total: 4}},
+		{parser.CancelledDone, parser.Progress{Done: 3, Total: 5}},
+		{parser.CancelledOpen, parser.Progress{Done: 2, Total: 5}},
+	}
+	for _, c := range cases {
+		if got := parser.CountProgress(roots[0].Children, c.mode); got != c.want {
+			t.Errorf("%s: got %+v, want %+v", c.mode, got, c.want)
+		}
+	}
+	if p := (parser.Progress{Done: 2, Total: 4}); p.Percent() != 50 {
+		t.Errorf("Percent = %d", p.Percent())
+	}
+	if _, err := parser.ParseCancelledMode("maybe"); err == nil {
+		t.Error("an unknown mode should be an error")
+	}
+
+	deploy := roots[0].Children[2]
+	tag := deploy.Children[0]
+	parser.SetState(tag, parser.Completed)
+	changes := parser.CompleteAncestors(tag, parser.CancelledExclude)
+	if len(changes) != 2 || changes[0].Todo != deploy || changes[1].Todo != roots[0] || changes[1].Prev != parser.Incomplete {
+		t.Fatalf("CompleteAncestors changed %d todos", len(changes))
+	}
+	if roots[0].State != parser.Completed || deploy.State != parser.Completed {
+		t.Error("ancestors should be completed once all their children are done")
+	}
+}
+
+func TestSetStateCascade(t *testing.T) {
+	lines := []string{
+		"- [ ] Release",
+		"  - [ ] Build",
+		"    - [/] Compile",
+		"  - [x] Test",
+		"  - [-] Announce",
+		"  - [>] Blog post",
+		"  - [ ] Deploy",
+		"    - [ ] Tag",
+	}
+	all := parser.Cascade{Down: true, Push: true, Reopen: true}
+	states := func(roots []*parser.Todo) string {
+		var out []string
+		for _, td := range parser.Flatten(roots) {
+			out = append(out, td.State.Def().Marker)
+		}
+		return strings.Join(out, "")
+	}
+	cases := []struct {
+		name  string
+		line  int
+		state parser.TodoState
+		rules parser.Cascade
+		setup func(roots []*parser.Todo)
+		want  string
+		n     int // todos changed
+	}{
+		{"no rules", 1, parser.Completed, parser.Cascade{}, nil, "x /x->  ", 1},
+		{"complete down", 1, parser.Completed, all, nil, "xxxx->xx", 5},
+		{"cancel down", 1, parser.Cancelled, all, nil, "---x->--", 5},
+		{"complete subtree only", 7, parser.Completed, all, nil, "  /x->xx", 2},
+		{"push down", 1, parser.Pushed, all, nil, ">>>x->>>", 5},
+		{"push without the rule", 1, parser.Pushed, parser.Cascade{Down: true, Reopen: true}, nil, "> /x->  ", 1},
+		{"down without the rule", 1, parser.Completed, parser.Cascade{Push: true}, nil, "x /x->  ", 1},
+		{"reopen ancestors", 8, parser.Incomplete, all, func(roots []*parser.Todo) {
+			parser.SetStateCascade(roots[0], parser.Completed, all)
+		}, " xxx->  ", 3},
+		{"reopen stops at an open ancestor", 3, parser.Incomplete, all, nil, "   x->  ", 1},
+		{"reopen without the rule", 8, parser.Incomplete, parser.Cascade{Down: true}, func(roots []*parser.Todo) {
+			parser.SetStateCascade(roots[0], parser.Completed, all)
+		}, "xxxx->x ", 1},
+	}
+	for _, c := range cases {
+		roots := parser.BuildTree(parser.ParseTodos([][]string{lines}))
+		if c.setup != nil {
+			c.setup(roots)
+		}
+		changes := parser.SetStateCascade(parser.Find(roots, c.line), c.state, c.rules)
+		if got := states(roots); got != c.want {
+			t.Errorf("%s: states %q, want %q", c.name, got, c.want)
+		}
+		if len(changes) != c.n {
+			t.Errorf("%s: %d changes, want %d", c.name, len(changes), c.n)
+		}
+		if len(changes) > 0 && changes[0].Todo != parser.Find(roots, c.line) {
+			t.Errorf("%s: the todo itself should be the first change", c.name)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package parser
+
+import "fmt"
+
+// CancelledMode says how cancelled todos count towards progress.
+type CancelledMode string
+
+const (
+	// CancelledExclude leaves cancelled todos out of progress entirely.
+	CancelledExclude CancelledMode = "exclude"
+	// CancelledDone counts cancelled todos as done.
+	CancelledDone CancelledMode = "done"
+	// CancelledOpen counts cancelled todos as still to do.
+	CancelledOpen CancelledMode = "open"
+)
+
+// ParseCancelledMode validates a progress.cancelled setting; empty means
+// exclude.
+func ParseCancelledMode(name string) (CancelledMode, error) {
+	switch CancelledMode(name) {
+	case "", CancelledExclude:
+		return CancelledExclude, nil
+	case CancelledDone, CancelledOpen:
+		return CancelledMode(name), nil
+	}
+	return "", fmt.Errorf("unknown progress.cancelled %q (want exclude, done or open)", name)
+}
+
+// Progress counts finished todos.
+type Progress struct {
+	Done, Total int
+}
+
+// Percent returns Done as a whole percentage of Total, or 0 if there is
+// nothing to count.
+func (p Progress) Percent() int {
+	if p.Total == 0 {
+		return 0
+	}
+	return p.Done * 100 / p.Total
+}
+
+// CountProgress counts nodes and all their descendants. Completed todos and
+// todos in other closed states are done; cancelled ones are counted as mode
+// says.
+func CountProgress(nodes []*Todo, mode CancelledMode) Progress {
+	var p Progress
+	for _, n := range nodes {
+		switch {
+		case n.State == Cancelled && mode == CancelledDone:
+			p.Done++
+			p.Total++
+		case n.State == Cancelled && mode == CancelledOpen:
+			p.Total++
+		case n.State == Cancelled:
+		case n.State.Closed():
+			p.Done++
+			p.Total++
+		default:
+			p.Total++
+		}
+		c := CountProgress(n.Children, mode)
+		p.Done += c.Done
+		p.Total += c.Total
+	}
+	return p
+}
+
+// CompleteAncestors completes each ancestor of n, innermost first, whose
+// descendants are now all done, stopping at the first one that is not. It
+// returns the todos it completed.
+func CompleteAncestors(n *Todo, mode CancelledMode) []Change {
+	var changes []Change
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.State.Closed() {
+			continue
+		}
+		if c := CountProgress(p.Children, mode); c.Total == 0 || c.Done < c.Total {
+			break
+		}
+		changes = append(changes, Change{Todo: p, Prev: p.State})
+		SetState(p, Completed)
+	}
+	return changes
+}
@@ -0,0 +1,36 @@
+package parser
+
+import "fmt"
+
+// Settings are the config options that change how todos are parsed and
+// written.
+type Settings struct {
+	Syntax    string     // metadata_syntax; empty is plain
+	StampDone bool       // done_dates
+	TabWidth  int        // tab_width; zero is the default of 4
+	States    []StateDef // states
+}
+
+// Configure validates s and then replaces FieldSyntax, StampDone, TabWidth
+// and the state registry; on error nothing changes. It is the one place
+// those globals are set. It is not safe for concurrent use: call it once at
+// startup (or from tests), before any todos are parsed.
+func Configure(s Settings) error {
+	syntax, err := ParseSyntax(s.Syntax)
+	if err != nil {
+		return err
+	}
+	if s.TabWidth < 0 {
+		return fmt.Errorf("invalid tab_width %d", s.TabWidth)
+	}
+	reg, err := registry(s.States)
+	if err != nil {
+		return err
+	}
+	FieldSyntax, StampDone, states = syntax, s.StampDone, reg
+	TabWidth = defaultTabWidth
+	if s.TabWidth > 0 {
+		TabWidth = s.TabWidth
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// StateDef describes one checkbox state: the character written between the
+// brackets and how the TUI shows and sets it.
+type StateDef struct {
+	// Name is used in CLI output, queries, hooks and JSON.
+	Name string `yaml:"name"`
+	// Marker is the single character between the brackets.
+	Marker string `yaml:"marker"`
+	Icon   string `yaml:"icon"`
+	// Key is the TUI key that toggles a todo into and out of the state.
+	Key   string `yaml:"key"`
+	Style Style  `yaml:"style"`
+	// Closed states are finished with: completed and cancelled todos.
+	Closed bool `yaml:"closed"`
+}
+
+// Style is how the TUI renders todos in a state. Color is a terminal color
+// number or hex code.
+type Style struct {
+	Color         string `yaml:"color"`
+	Bold          bool   `yaml:"bold"`
+	Faint         bool   `yaml:"faint"`
+	Strikethrough bool   `yaml:"strikethrough"`
+}
+
+func (s Style) isZero() bool { return s == Style{} }
+
+// The built-in extra states, after the four original ones.
+const (
+	InProgress TodoState = iota + Pushed + 1
+	Question
+	Important
+	Waiting
+)
+
+// Unknown is the state of a todo whose marker is not registered. Its marker
+// is kept in Todo.Box so it is written back unchanged.
+const Unknown TodoState = -1
+
+func defaultStates() []StateDef {
+	return []StateDef{
+		Incomplete: {Name: "incomplete", Marker: " ", Icon: "○", Key: " ", Style: Style{Color: "7"}},
+		Completed:  {Name: "completed", Marker: "x", Icon: "✔", Key: "x", Style: Style{Color: "3", Faint: true}, Closed: true},
+		Cancelled:  {Name: "cancelled", Marker: "-", Icon: "✗", Key: "-", Style: Style{Color: "8", Strikethrough: true}, Closed: true},
+		Pushed:     {Name: "pushed", Marker: ">", Icon: "➤", Key: ">", Style: Style{Color: "8", Faint: true}},
+		InProgress: {Name: "in_progress", Marker: "/", Icon: "◐", Key: "i", Style: Style{Color: "6"}},
+		Question:   {Name: "question", Marker: "?", Icon: "?", Key: "Q", Style: Style{Color: "5"}},
+		Important:  {Name: "important", Marker: "!", Icon: "!", Key: "!", Style: Style{Color: "1", Bold: true}},
+		Waiting:    {Name: "waiting", Marker: "w", Icon: "⧗", Key: "w", Style: Style{Color: "4", Faint: true}},
+	}
+}
+
+// states is the registry, indexed by TodoState.
+var states = defaultStates()
+
+var stateAliases = map[string]TodoState{
+	"open":        Incomplete,
+	"done":        Completed,
+	"canceled":    Cancelled,
+	"in-progress": InProgress,
+	"doing":       InProgress,
+}
+
+// registry returns the built-in states with defs from the config applied.
+// A def whose marker is already registered changes that state's icon, key
+// and style; any other def adds a state, and needs a name. A key bound
+// twice goes to the later state.
+func registry(defs []StateDef) ([]StateDef, error) {
+	reg := defaultStates()
+	for _, d := range defs {
+		if utf8.RuneCountInString(d.Marker) != 1 || d.Marker == "]" {
+			return nil, fmt.Errorf("state %q: marker must be a single character other than ']'", d.Name)
+		}
+		d.Name = strings.ToLower(d.Name)
+		i := -1
+		for j, s := range reg {
+			if s.Marker == d.Marker {
+				i = j
+			}
+		}
+		if i < 0 {
+			if d.Name == "" {
+				return nil, fmt.Errorf("state with marker %q needs a name", d.Marker)
+			}
+			if _, err := lookupState(reg, d.Name); err == nil {
+				return nil, fmt.Errorf("state %q is already defined", d.Name)
+			}
+			if d.Icon == "" {
+				d.Icon = d.Marker
+			}
+			i = len(reg)
+			reg = append(reg, d)
+		} else {
+			s := &reg[i]
+			if d.Name != "" && d.Name != s.Name {
+				return nil, fmt.Errorf("marker %q is already state %q", d.Marker, s.Name)
+			}
+			if d.Icon != "" {
+				s.Icon = d.Icon
+			}
+			if d.Key != "" {
+				s.Key = d.Key
+			}
+			if !d.Style.isZero() {
+				s.Style = d.Style
+			}
+		}
+		if key := reg[i].Key; key != "" {
+			for j := range reg {
+				if j != i && reg[j].Key == key {
+					reg[j].Key = ""
+				}
+			}
+		}
+	}
+	return reg, nil
+}
+
+// States returns the registered states in order.
+func States() []StateDef {
+	return append([]StateDef(nil), states...)
+}
+
+// Def returns the definition of s. Unknown and unregistered states get a
+// placeholder named "unknown".
+func (s TodoState) Def() StateDef {
+	if s < 0 || int(s) >= len(states) {
+		return StateDef{Name: "unknown", Icon: "·"}
+	}
+	return states[s]
+}
+
+// Closed reports whether todos in state s are finished with.
+func (s TodoState) Closed() bool {
+	return s.Def().Closed
+}
+
+// StateForMarker returns the state written as marker.
+func StateForMarker(marker string) (TodoState, bool) {
+	for i, s := range states {
+		if s.Marker == marker {
+			return TodoState(i), true
+		}
+	}
+	return Unknown, false
+}
+
+// StateForKey returns the state bound to a TUI key.
+func StateForKey(key string) (TodoState, bool) {
+	if key == "" {
+		return Unknown, false
+	}
+	for i, s := range states {
+		if s.Key == key {
+			return TodoState(i), true
+		}
+	}
+	return Unknown, false
+}
+
+func lookupState(reg []StateDef, name string) (TodoState, error) {
+	name = strings.ToLower(name)
+	for i, s := range reg {
+		if s.Name == name {
+			return TodoState(i), nil
+		}
+	}
+	if s, ok := stateAliases[name]; ok {
+		return s, nil
+	}
+	return Unknown, fmt.Errorf("unknown todo state %q", name)
+}
@@ -0,0 +1,233 @@
+// Package plugins runs external executables that extend td-file.
+//
+// Every executable file in the plugin directory is a plugin named after the
+// file (without extension). A plugin is started once per invocation, reads a
+// single Request as JSON on stdin, and writes a single Response as JSON on
+// stdout; anything it prints to stderr is shown if it exits non-zero.
+//
+// Request (protocol 1):
+//
+//	{
+//	  "protocol": 1,
+//	  "invocation": "key" | "command",
+//	  "args": ["..."],
+//	  "file": "/path/to/todos.md",
+//	  "todos": [Node, ...],   // the tree, as in `td-file list --format json`
+//	  "cursor": 3             // line of the selected todo; 0 for commands
+//	}
+//
+// Response:
+//
+//	{
+//	  "mutations": [Mutation, ...],  // applied in order
+//	  "panel": "text",               // shown beside the list (TUI) or printed (CLI)
+//	  "error": "message"             // reported instead of applying anything
+//	}
+//
+// Mutation ops are "add" (Text, State and Highlighted as a child of Line, or at
+// the end of Block when Line is 0), "set_state", "set_text" and "delete",
+// each targeting the todo at Line.
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"td-file/control"
+	"td-file/output"
+	"td-file/parser"
+)
+
+// ProtocolVersion is sent in every Request. It is bumped when a field is
+// renamed or removed.
+const ProtocolVersion = 1
+
+// Invocation kinds.
+const (
+	InvokedByKey     = "key"
+	InvokedByCommand = "command"
+)
+
+// Timeout bounds how long a plugin may run.
+var Timeout = 10 * time.Second
+
+// Request is sent to the plugin on stdin.
+type Request struct {
+	Protocol   int           `json:"protocol"`
+	Invocation string        `json:"invocation"`
+	Args       []string      `json:"args"`
+	File       string        `json:"file"`
+	Todos      []output.Node `json:"todos"`
+	Cursor     int           `json:"cursor,omitempty"`
+}
+
+// NewRequest builds a request for the todos of file. cursor is the line of
+// the selected todo, or 0.
+func NewRequest(invocation string, args []string, file string, todos []parser.Todo, cursor int) Request {
+	if args == nil {
+		args = []string{}
+	}
+	return Request{
+		Protocol:   ProtocolVersion,
+		Invocation: invocation,
+		Args:       args,
+		File:       file,
+		Todos:      output.NewDocument(file, todos, nil).Todos,
+		Cursor:     cursor,
+	}
+}
+
+// Mutation is one change requested by a plugin.
+type Mutation struct {
+	Op          string `json:"op"`
+	Line        int    `json:"line,omitempty"`
+	Block       int    `json:"block,omitempty"`
+	Text        string `json:"text,omitempty"`
+	State       string `json:"state,omitempty"`
+	Highlighted bool   `json:"highlighted,omitempty"`
+}
+
+// Response is read from the plugin's stdout.
+type Response struct {
+	Mutations []Mutation `json:"mutations,omitempty"`
+	Panel     string     `json:"panel,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// Request converts the response's mutations into a single batch request for
+// path, so they can be applied to a model or routed to a running session.
+// todos is the list the plugin was given: each step expects its todo to
+// still have the text the plugin saw, so a file that changed while the
+// plugin ran is not edited in the wrong place.
+func (r Response) Request(path string, todos []parser.Todo) (control.Request, error) {
+	steps, err := r.steps(todos)
+	if err != nil {
+		return control.Request{}, err
+	}
+	return control.Request{Op: control.OpBatch, Path: path, Requests: steps}, nil
+}
+
+func (r Response) steps(todos []parser.Todo) ([]control.Request, error) {
+	text := map[int]string{}
+	for _, t := range todos {
+		text[t.LineNumber] = t.Text
+	}
+	var out []control.Request
+	for _, m := range r.Mutations {
+		req := control.Request{Op: m.Op, Line: m.Line}
+		switch m.Op {
+		case control.OpAdd:
+			state := parser.Incomplete
+			if m.State != "" {
+				s, err := parser.ParseState(m.State)
+				if err != nil {
+					return nil, err
+				}
+				state = s
+			}
+			if strings.TrimSpace(m.Text) == "" {
+				return nil, fmt.Errorf("add: text is required")
+			}
+			req.Line = 0
+			req.Parent = m.Line
+			req.Block = m.Block
+			req.Todos = []parser.Todo{{Text: m.Text, State: state, Highlighted: m.Highlighted}}
+		case control.OpSetState:
+			req.State, req.Text = m.State, text[m.Line]
+		case control.OpSetText:
+			req.Old, req.Text = text[m.Line], m.Text
+			text[m.Line] = m.Text
+		case control.OpDelete:
+			req.Text = text[m.Line]
+		default:
+			return nil, fmt.Errorf("unknown mutation %q", m.Op)
+		}
+		out = append(out, req)
+	}
+	return out, nil
+}
+
+// Plugin is an executable found in the plugin directory.
+type Plugin struct {
+	Name string
+	Path string
+}
+
+// Discover lists the plugins in dir, sorted by name. A missing directory
+// means no plugins.
+func Discover(dir string) ([]Plugin, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Plugin
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		out = append(out, Plugin{Name: name, Path: filepath.Join(dir, e.Name())})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// Find returns the plugin called name in dir.
+func Find(dir, name string) (Plugin, bool) {
+	found, _ := Discover(dir)
+	for _, p := range found {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Plugin{}, false
+}
+
+// Run invokes the plugin with req. A non-zero exit, a timeout, malformed
+// output or a response carrying an error are all returned as errors.
+func (p Plugin) Run(req Request) (Response, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return Response{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.Path, req.Args...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.WaitDelay = time.Second
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", Timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%v: %s", err, msg)
+		}
+		return Response{}, fmt.Errorf("plugin %s: %w", p.Name, err)
+	}
+	var resp Response
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return resp, nil
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return Response{}, fmt.Errorf("plugin %s: invalid response: %w", p.Name, err)
+	}
+	if resp.Error != "" {
+		return resp, fmt.Errorf("plugin %s: %s", p.Name, resp.Error)
+	}
+	return resp, nil
+}
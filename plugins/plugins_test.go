@@ -0,0 +1,178 @@
+package plugins_test
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"td-file/control"
+	"td-file/parser"
+	"td-file/plugins"
+)
+
+// stub writes an executable shell script into dir and returns it as a plugin.
+func stub(t *testing.T, dir, name, script string) plugins.Plugin {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatalf("write stub: %v", err)
+	}
+	return plugins.Plugin{Name: strings.TrimSuffix(name, filepath.Ext(name)), Path: path}
+}
+
+func TestDiscover(t *testing.T) {
+	dir := t.TempDir()
+	stub(t, dir, "zeta", "")
+	stub(t, dir, "alpha.sh", "")
+	os.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0644)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+
+	found, err := plugins.Discover(dir)
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	var names []string
+	for _, p := range found {
+		names = append(names, p.Name)
+	}
+	if strings.Join(names, ",") != "alpha,zeta" {
+		t.Errorf("got plugins %v", names)
+	}
+	if p, ok := plugins.Find(dir, "alpha"); !ok || filepath.Base(p.Path) != "alpha.sh" {
+		t.Errorf("Find(alpha) = %+v, %v", p, ok)
+	}
+	if found, err := plugins.Discover(filepath.Join(dir, "missing")); err != nil || found != nil {
+		t.Errorf("missing dir: got %v, %v", found, err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "stdin.json")
+	p := stub(t, dir, "echo", `cat > `+in+`
+echo "args: $*" > `+dir+`/argv
+echo '{"mutations":[{"op":"set_state","line":1,"state":"completed"}],"panel":"done"}'
+`)
+	todos := parser.ParseTodos([][]string{{"- [ ] A", "  - [ ] B"}})
+	resp, err := p.Run(plugins.NewRequest(plugins.InvokedByKey, []string{"one"}, "todos.md", todos, 2))
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if resp.Panel != "done" || len(resp.Mutations) != 1 || resp.Mutations[0].State != "completed" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	var req plugins.Request
+	data, _ := os.ReadFile(in)
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatalf("stdin is not a request: %v\n%s", err, data)
+	}
+	if req.Protocol != plugins.ProtocolVersion || req.Invocation != "key" || req.Cursor != 2 || req.File != "todos.md" {
+		t.Errorf("unexpected request %+v", req)
+	}
+	if len(req.Todos) != 1 || len(req.Todos[0].Children) != 1 || req.Todos[0].Children[0].Text != "B" {
+		t.Errorf("request should carry the tree, got %+v", req.Todos)
+	}
+	if argv, _ := os.ReadFile(filepath.Join(dir, "argv")); string(argv) != "args: one\n" {
+		t.Errorf("args not passed on the command line: %q", argv)
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	dir := t.TempDir()
+	defer func(d time.Duration) { plugins.Timeout = d }(plugins.Timeout)
+	plugins.Timeout = 200 * time.Millisecond
+
+	for _, tc := range []struct {
+		name, script, want string
+	}{
+		{"fails", "echo broken >&2; exit 3", "broken"},
+		{"garbage", "echo not json", "invalid response"},
+		{"refuses", `echo '{"error":"nothing selected"}'`, "nothing selected"},
+		{"slow", "sleep 5", "timed out"},
+	} {
+		p := stub(t, dir, tc.name, tc.script)
+		_, err := p.Run(plugins.NewRequest(plugins.InvokedByCommand, nil, "todos.md", nil, 0))
+		if err == nil || !strings.Contains(err.Error(), tc.want) || !strings.Contains(err.Error(), "plugin "+tc.name) {
+			t.Errorf("%s: got error %v, want %q", tc.name, err, tc.want)
+		}
+	}
+
+	p := stub(t, dir, "silent", "cat >/dev/null")
+	if resp, err := p.Run(plugins.NewRequest(plugins.InvokedByCommand, nil, "todos.md", nil, 0)); err != nil || resp.Panel != "" {
+		t.Errorf("empty output should be an empty response, got %+v, %v", resp, err)
+	}
+}
+
+func TestResponse_Request(t *testing.T) {
+	resp := plugins.Response{Mutations: []plugins.Mutation{
+		{Op: "add", Line: 1, Text: "Child", State: "cancelled"},
+		{Op: "set_text", Line: 1, Text: "Renamed"},
+		{Op: "delete", Line: 2},
+	}}
+	todos := parser.ParseTodos([][]string{{"- [ ] A", "  - [ ] B"}})
+	req, err := resp.Request("todos.md", todos)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if req.Op != control.OpBatch || len(req.Requests) != 3 {
+		t.Fatalf("expected a batch of 3, got %+v", req)
+	}
+	add := req.Requests[0]
+	if add.Parent != 1 || add.Line != 0 || add.Todos[0].State != parser.Cancelled {
+		t.Errorf("add should target the parent line, got %+v", add)
+	}
+
+	got, err := control.Apply(todos, req)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if len(got) != 2 || got[0].Text != "Renamed" || got[1].Text != "Child" {
+		t.Errorf("unexpected result %+v", got)
+	}
+	// The plugin saw A at line 1; if the file changed since, nothing applies.
+	changed := parser.ParseTodos([][]string{{"- [ ] Z", "  - [ ] B"}})
+	if _, err := control.Apply(changed, req); err == nil {
+		t.Error("expected the batch to fail once line 1 holds another todo")
+	}
+
+	for _, bad := range []plugins.Mutation{{Op: "explode"}, {Op: "add"}, {Op: "add", Text: "x", State: "maybe"}} {
+		if _, err := (plugins.Response{Mutations: []plugins.Mutation{bad}}).Request("todos.md", nil); err == nil {
+			t.Errorf("expected %+v to be rejected", bad)
+		}
+	}
+}
+
+// TestSamplePlugin builds examples/plugins/stats and runs it end to end.
+func TestSamplePlugin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a binary")
+	}
+	bin := filepath.Join(t.TempDir(), "stats")
+	build := exec.Command("go", "build", "-o", bin, "../examples/plugins/stats")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("build sample plugin: %v\n%s", err, out)
+	}
+	p := plugins.Plugin{Name: "stats", Path: bin}
+	todos := parser.ParseTodos([][]string{{"- [x] A", "- [-] B", "- [ ] C"}})
+
+	resp, err := p.Run(plugins.NewRequest(plugins.InvokedByCommand, nil, "todos.md", todos, 0))
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(resp.Panel, "completed     1") || !strings.Contains(resp.Panel, "33%") {
+		t.Errorf("unexpected panel:\n%s", resp.Panel)
+	}
+
+	resp, err = p.Run(plugins.NewRequest(plugins.InvokedByCommand, []string{"tidy"}, "todos.md", todos, 0))
+	if err != nil {
+		t.Fatalf("Run tidy failed: %v", err)
+	}
+	if len(resp.Mutations) != 1 || resp.Mutations[0].Op != "delete" || resp.Mutations[0].Line != 2 {
+		t.Errorf("tidy should delete B, got %+v", resp.Mutations)
+	}
+}
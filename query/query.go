@@ -0,0 +1,556 @@
+// Package query implements the filter language shared by `td-file query`
+// and the TUI filter prompt.
+//
+// A query is a sequence of terms combined with `and` (implicit between
+// adjacent terms), `or` and `not` (also spelled `!` or a leading `-`), with
+// parentheses for grouping:
+//
+//	state:incomplete tag:work due<+3d text~"deploy"
+//	(tag:home or tag:errand) not state:cancelled
+//	under:"Release" -state:completed
+//
+// Supported keys:
+//
+//	state:NAME        incomplete, completed, cancelled, pushed, in_progress, ...
+//	tag:NAME          hashtag in the text (#NAME)
+//	text~STR          case-insensitive substring (text:STR is equivalent)
+//	text=STR          exact text match
+//	is:highlighted    highlighted todos (also is:parent, is:leaf)
+//	due OP DATE       due date from a `due:YYYY-MM-DD` field; due:none/due:any
+//	date OP DATE      date of the daily file the todo lives in
+//	under:X           some ancestor matches X
+//	has:X             some descendant matches X
+//	KEY:VALUE         any other `key:value` metadata field
+//
+// OP is one of `:` `=` `!=` `<` `<=` `>` `>=`. DATE is YYYY-MM-DD, today,
+// tomorrow, yesterday, or an offset such as +3d, -2w or +1m relative to today.
+// X is either a string (matched against the text) or a parenthesised query.
+// A bare word or quoted string is shorthand for text~.
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"td-file/parser"
+)
+
+// Env carries the context a todo is evaluated in.
+type Env struct {
+	// Now anchors relative dates such as +3d. The zero value means time.Now().
+	Now time.Time
+	// FileDate is the date of the daily file containing the todo, if any.
+	FileDate time.Time
+}
+
+// Query is a compiled filter expression.
+type Query struct {
+	src  string
+	expr expr
+}
+
+// String returns the source text the query was parsed from.
+func (q *Query) String() string {
+	return q.src
+}
+
+// Match reports whether todo satisfies the query. todo must be a node of a
+// tree built by parser.BuildTree so that ancestor and descendant predicates
+// can follow Parent and Children.
+func (q *Query) Match(todo *parser.Todo, env Env) bool {
+	if q == nil || q.expr == nil {
+		return true
+	}
+	if env.Now.IsZero() {
+		env.Now = time.Now()
+	}
+	return q.expr.match(todo, &env)
+}
+
+// Parse compiles src into a Query. An empty or all-whitespace query matches
+// everything.
+func Parse(src string) (*Query, error) {
+	p := &queryParser{src: src}
+	p.skipSpace()
+	if p.eof() {
+		return &Query{src: src}, nil
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q", string(p.peek()))
+	}
+	return &Query{src: src, expr: e}, nil
+}
+
+// --- expression tree ---
+
+type expr interface {
+	match(t *parser.Todo, env *Env) bool
+}
+
+type andExpr struct{ left, right expr }
+type orExpr struct{ left, right expr }
+type notExpr struct{ inner expr }
+type predExpr func(t *parser.Todo, env *Env) bool
+
+func (e andExpr) match(t *parser.Todo, env *Env) bool {
+	return e.left.match(t, env) && e.right.match(t, env)
+}
+
+func (e orExpr) match(t *parser.Todo, env *Env) bool {
+	return e.left.match(t, env) || e.right.match(t, env)
+}
+
+func (e notExpr) match(t *parser.Todo, env *Env) bool {
+	return !e.inner.match(t, env)
+}
+
+func (e predExpr) match(t *parser.Todo, env *Env) bool {
+	return e(t, env)
+}
+
+// --- parser ---
+
+type queryParser struct {
+	src string
+	pos int
+}
+
+func (p *queryParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("query: at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *queryParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *queryParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *queryParser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// keyword consumes word if it appears next as a standalone token.
+func (p *queryParser) keyword(word string) bool {
+	p.skipSpace()
+	end := p.pos + len(word)
+	if end > len(p.src) || !strings.EqualFold(p.src[p.pos:end], word) {
+		return false
+	}
+	if end < len(p.src) && !unicode.IsSpace(rune(p.src[end])) && p.src[end] != '(' {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *queryParser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		if p.eof() || p.peek() == ')' {
+			return left, nil
+		}
+		start := p.pos
+		if p.keyword("or") {
+			p.pos = start
+			return left, nil
+		}
+		p.keyword("and")
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+}
+
+func (p *queryParser) parseUnary() (expr, error) {
+	p.skipSpace()
+	if p.keyword("not") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{inner}, nil
+	}
+	if c := p.peek(); (c == '!' || c == '-') && p.pos+1 < len(p.src) && !unicode.IsSpace(rune(p.src[p.pos+1])) {
+		p.pos++
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{inner}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (expr, error) {
+	p.skipSpace()
+	if p.eof() {
+		return nil, p.errorf("unexpected end of query")
+	}
+	switch p.peek() {
+	case '(':
+		return p.parseGroup()
+	case ')':
+		return nil, p.errorf("unexpected ')'")
+	case '"':
+		s, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		return textContains(s), nil
+	}
+	start := p.pos
+	for !p.eof() && (isIdent(p.peek())) {
+		p.pos++
+	}
+	key := strings.ToLower(p.src[start:p.pos])
+	if op := p.parseOp(); key != "" && op != "" {
+		return p.parsePredicate(key, op)
+	}
+	p.pos = start
+	return textContains(p.parseWord()), nil
+}
+
+func (p *queryParser) parseGroup() (expr, error) {
+	p.pos++ // (
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.peek() != ')' {
+		return nil, p.errorf("expected ')'")
+	}
+	p.pos++
+	return e, nil
+}
+
+func (p *queryParser) parseOp() string {
+	for _, op := range []string{"<=", ">=", "!=", "<", ">", "=", ":", "~"} {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			p.pos += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *queryParser) parseQuoted() (string, error) {
+	p.pos++ // opening quote
+	var b strings.Builder
+	for !p.eof() {
+		c := p.src[p.pos]
+		p.pos++
+		switch c {
+		case '\\':
+			if !p.eof() {
+				b.WriteByte(p.src[p.pos])
+				p.pos++
+			}
+		case '"':
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *queryParser) parseWord() string {
+	start := p.pos
+	for !p.eof() && !unicode.IsSpace(rune(p.peek())) && p.peek() != '(' && p.peek() != ')' {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *queryParser) parseValue() (string, error) {
+	if p.peek() == '"' {
+		return p.parseQuoted()
+	}
+	v := p.parseWord()
+	if v == "" {
+		return "", p.errorf("missing value")
+	}
+	return v, nil
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// --- predicates ---
+
+func (p *queryParser) parsePredicate(key, op string) (expr, error) {
+	if key == "under" || key == "has" {
+		if op != ":" && op != "=" {
+			return nil, p.errorf("%s only supports ':'", key)
+		}
+		var inner expr
+		if p.peek() == '(' {
+			e, err := p.parseGroup()
+			if err != nil {
+				return nil, err
+			}
+			inner = e
+		} else {
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			inner = textContains(v)
+		}
+		if key == "under" {
+			return underExpr(inner), nil
+		}
+		return hasExpr(inner), nil
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	negate := op == "!="
+	if negate {
+		op = "="
+	}
+	var e expr
+	switch key {
+	case "state":
+		if op != ":" && op != "=" {
+			return nil, p.errorf("state only supports ':', '=' and '!='")
+		}
+		state, err := parser.ParseState(value)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		e = predExpr(func(t *parser.Todo, _ *Env) bool { return t.State == state })
+	case "tag":
+		e = predExpr(func(t *parser.Todo, _ *Env) bool { return parser.ParseMetadata(t.Text).HasTag(value) })
+	case "text":
+		switch op {
+		case ":", "~":
+			e = textContains(value)
+		case "=":
+			e = predExpr(func(t *parser.Todo, _ *Env) bool { return strings.EqualFold(t.Text, value) })
+		default:
+			return nil, p.errorf("text does not support %q", op)
+		}
+	case "is":
+		e, err = isExpr(value)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+	case "due":
+		e, err = dateExpr(op, value, func(t *parser.Todo, _ *Env) (time.Time, bool) {
+			return parser.ParseMetadata(t.Text).Due()
+		})
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+	case "date":
+		e, err = dateExpr(op, value, func(_ *parser.Todo, env *Env) (time.Time, bool) {
+			return env.FileDate, !env.FileDate.IsZero()
+		})
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+	default:
+		if op != ":" && op != "=" && op != "~" {
+			return nil, p.errorf("field %s only supports ':', '=', '!=' and '~'", key)
+		}
+		fuzzy := op == "~"
+		e = predExpr(func(t *parser.Todo, _ *Env) bool {
+			v, ok := parser.ParseMetadata(t.Text).Fields[key]
+			if fuzzy {
+				return ok && strings.Contains(strings.ToLower(v), strings.ToLower(value))
+			}
+			return ok && strings.EqualFold(v, value)
+		})
+	}
+	if negate {
+		return notExpr{e}, nil
+	}
+	return e, nil
+}
+
+func textContains(s string) expr {
+	needle := strings.ToLower(s)
+	return predExpr(func(t *parser.Todo, _ *Env) bool {
+		return strings.Contains(strings.ToLower(t.Text), needle)
+	})
+}
+
+func underExpr(inner expr) expr {
+	return predExpr(func(t *parser.Todo, env *Env) bool {
+		for a := t.Parent; a != nil; a = a.Parent {
+			if inner.match(a, env) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func hasExpr(inner expr) expr {
+	var walk func(nodes []*parser.Todo, env *Env) bool
+	walk = func(nodes []*parser.Todo, env *Env) bool {
+		for _, c := range nodes {
+			if inner.match(c, env) || walk(c.Children, env) {
+				return true
+			}
+		}
+		return false
+	}
+	return predExpr(func(t *parser.Todo, env *Env) bool {
+		return walk(t.Children, env)
+	})
+}
+
+func isExpr(value string) (expr, error) {
+	switch strings.ToLower(value) {
+	case "highlighted", "highlight", "starred":
+		return predExpr(func(t *parser.Todo, _ *Env) bool { return t.Highlighted }), nil
+	case "parent":
+		return predExpr(func(t *parser.Todo, _ *Env) bool { return len(t.Children) > 0 }), nil
+	case "leaf":
+		return predExpr(func(t *parser.Todo, _ *Env) bool { return len(t.Children) == 0 }), nil
+	case "root":
+		return predExpr(func(t *parser.Todo, _ *Env) bool { return t.Parent == nil }), nil
+	}
+	if state, err := parser.ParseState(value); err == nil {
+		return predExpr(func(t *parser.Todo, _ *Env) bool { return t.State == state }), nil
+	}
+	return nil, fmt.Errorf("unknown is:%s", value)
+}
+
+func dateExpr(op, value string, get func(*parser.Todo, *Env) (time.Time, bool)) (expr, error) {
+	switch strings.ToLower(value) {
+	case "none":
+		if op != ":" && op != "=" {
+			return nil, fmt.Errorf("%s is only valid with ':'", value)
+		}
+		return predExpr(func(t *parser.Todo, env *Env) bool { _, ok := get(t, env); return !ok }), nil
+	case "any":
+		if op != ":" && op != "=" {
+			return nil, fmt.Errorf("%s is only valid with ':'", value)
+		}
+		return predExpr(func(t *parser.Todo, env *Env) bool { _, ok := get(t, env); return ok }), nil
+	}
+	if _, err := ResolveDate(value, time.Now()); err != nil {
+		return nil, err
+	}
+	return predExpr(func(t *parser.Todo, env *Env) bool {
+		d, ok := get(t, env)
+		if !ok {
+			return false
+		}
+		want, _ := ResolveDate(value, env.Now)
+		d = truncateDay(d)
+		switch op {
+		case "<":
+			return d.Before(want)
+		case "<=":
+			return !d.After(want)
+		case ">":
+			return d.After(want)
+		case ">=":
+			return !d.Before(want)
+		default:
+			return d.Equal(want)
+		}
+	}), nil
+}
+
+// ResolveDate turns an absolute (YYYY-MM-DD) or relative (today, +3d, -2w,
+// +1m) date into midnight local time, relative to now.
+func ResolveDate(value string, now time.Time) (time.Time, error) {
+	today := truncateDay(now)
+	switch strings.ToLower(value) {
+	case "today":
+		return today, nil
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	}
+	if d, err := time.ParseInLocation(parser.DateLayout, value, time.Local); err == nil {
+		return d, nil
+	}
+	if len(value) >= 2 && (value[0] == '+' || value[0] == '-') {
+		var n int
+		var unit string
+		if _, err := fmt.Sscanf(value[1:], "%d%s", &n, &unit); err == nil {
+			if value[0] == '-' {
+				n = -n
+			}
+			switch unit {
+			case "d":
+				return today.AddDate(0, 0, n), nil
+			case "w":
+				return today.AddDate(0, 0, 7*n), nil
+			case "m":
+				return today.AddDate(0, n, 0), nil
+			case "y":
+				return today.AddDate(n, 0, 0), nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+func truncateDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+}
+
+// Select walks the trees rooted at roots in document order and returns every
+// node that matches q.
+func Select(q *Query, roots []*parser.Todo, env Env) []*parser.Todo {
+	var out []*parser.Todo
+	var walk func(nodes []*parser.Todo)
+	walk = func(nodes []*parser.Todo) {
+		for _, n := range nodes {
+			if q.Match(n, env) {
+				out = append(out, n)
+			}
+			walk(n.Children)
+		}
+	}
+	walk(roots)
+	return out
+}
@@ -0,0 +1,130 @@
+package query_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"td-file/parser"
+	"td-file/query"
+)
+
+var now = time.Date(2024, 6, 10, 9, 30, 0, 0, time.Local)
+
+func sampleTree() []*parser.Todo {
+	blocks := [][]string{{
+		"- [ ] Release 1.2 #work",
+		"  - [ ] Deploy to staging due:2024-06-11",
+		"  - [x] Write changelog #docs",
+		"  - [ ] Deploy to prod due:2024-06-20 *",
+		"- [ ] Groceries #home due:2024-06-09",
+		"  - [-] Milk",
+		"- [>] Call plumber #home priority:high",
+	}}
+	return parser.BuildTree(parser.ParseTodos(blocks))
+}
+
+func run(t *testing.T, src string) []string {
+	t.Helper()
+	q, err := query.Parse(src)
+	if err != nil {
+		t.Fatalf("Parse(%q) failed: %v", src, err)
+	}
+	var got []string
+	for _, n := range query.Select(q, sampleTree(), query.Env{Now: now}) {
+		got = append(got, n.Text)
+	}
+	return got
+}
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"Release 1.2 #work", "Deploy to staging due:2024-06-11", "Write changelog #docs", "Deploy to prod due:2024-06-20", "Groceries #home due:2024-06-09", "Milk", "Call plumber #home priority:high"}},
+		{"state:completed", []string{"Write changelog #docs"}},
+		{"tag:home", []string{"Groceries #home due:2024-06-09", "Call plumber #home priority:high"}},
+		{`text~"deploy"`, []string{"Deploy to staging due:2024-06-11", "Deploy to prod due:2024-06-20"}},
+		{"deploy", []string{"Deploy to staging due:2024-06-11", "Deploy to prod due:2024-06-20"}},
+		{"due<+3d", []string{"Deploy to staging due:2024-06-11", "Groceries #home due:2024-06-09"}},
+		{"due>=today state:incomplete", []string{"Deploy to staging due:2024-06-11", "Deploy to prod due:2024-06-20"}},
+		{"due:2024-06-09", []string{"Groceries #home due:2024-06-09"}},
+		{"due:none state:incomplete", []string{"Release 1.2 #work"}},
+		{"tag:home or tag:docs", []string{"Write changelog #docs", "Groceries #home due:2024-06-09", "Call plumber #home priority:high"}},
+		{"tag:home and not state:pushed", []string{"Groceries #home due:2024-06-09"}},
+		{"-state:incomplete", []string{"Write changelog #docs", "Milk", "Call plumber #home priority:high"}},
+		{"state!=incomplete", []string{"Write changelog #docs", "Milk", "Call plumber #home priority:high"}},
+		{`under:"Release"`, []string{"Deploy to staging due:2024-06-11", "Write changelog #docs", "Deploy to prod due:2024-06-20"}},
+		{"under:(tag:home) state:cancelled", []string{"Milk"}},
+		{"has:(state:cancelled)", []string{"Groceries #home due:2024-06-09"}},
+		{"is:highlighted", []string{"Deploy to prod due:2024-06-20"}},
+		{"is:parent", []string{"Release 1.2 #work", "Groceries #home due:2024-06-09"}},
+		{"priority:high", []string{"Call plumber #home priority:high"}},
+		{"(tag:work or tag:home) is:root state:incomplete", []string{"Release 1.2 #work", "Groceries #home due:2024-06-09"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got := run(t, tt.query)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuery_FileDate(t *testing.T) {
+	q, err := query.Parse("date>=-7d date<today")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	todo := &parser.Todo{Text: "x"}
+	cases := map[string]bool{"2024-06-03": true, "2024-06-09": true, "2024-06-02": false, "2024-06-10": false}
+	for d, want := range cases {
+		fd, _ := time.ParseInLocation(parser.DateLayout, d, time.Local)
+		if got := q.Match(todo, query.Env{Now: now, FileDate: fd}); got != want {
+			t.Errorf("file date %s: got %v, want %v", d, got, want)
+		}
+	}
+	if q.Match(todo, query.Env{Now: now}) {
+		t.Error("todo without a file date should not match a date predicate")
+	}
+}
+
+func TestQuery_ParseErrors(t *testing.T) {
+	for _, src := range []string{
+		"(state:incomplete",
+		"state:bogus",
+		`text~"unterminated`,
+		"due<someday",
+		"tag:home or",
+		"is:weird",
+		"state<completed",
+	} {
+		if _, err := query.Parse(src); err == nil {
+			t.Errorf("expected error for %q", src)
+		}
+	}
+}
+
+func TestResolveDate(t *testing.T) {
+	cases := map[string]string{
+		"today":      "2024-06-10",
+		"tomorrow":   "2024-06-11",
+		"yesterday":  "2024-06-09",
+		"+3d":        "2024-06-13",
+		"-2w":        "2024-05-27",
+		"+1m":        "2024-07-10",
+		"2025-01-01": "2025-01-01",
+	}
+	for in, want := range cases {
+		got, err := query.ResolveDate(in, now)
+		if err != nil {
+			t.Errorf("ResolveDate(%q) failed: %v", in, err)
+			continue
+		}
+		if got.Format(parser.DateLayout) != want {
+			t.Errorf("ResolveDate(%q) = %s, want %s", in, got.Format(parser.DateLayout), want)
+		}
+	}
+}
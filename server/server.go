@@ -0,0 +1,464 @@
+// Package server exposes a todo file over a local HTTP/JSON API.
+//
+// Endpoints (all under /api, all requiring the bearer token):
+//
+//	GET    /api/todos              the file as an output.Document
+//	POST   /api/todos              add a todo: {"text", "state", "highlighted", "parent", "block"}
+//	PATCH  /api/todos/{line}       update: {"text", "state", "highlighted"} (all optional)
+//	POST   /api/todos/{line}/move  move with its subtree: {"parent", "index"}
+//	DELETE /api/todos/{line}       delete with its subtree
+//	GET    /api/events             Server-Sent Events stream of "change" events
+//
+// Todos are addressed by their line number within the :td blocks, as reported
+// in the document. Every response carries an ETag for the current list;
+// mutations sent with If-Match fail with 412 if the file has changed since,
+// which is how clients avoid acting on stale line numbers. Writes go through
+// sync.UpdateFile, so they are serialised with the TUI's saves.
+//
+// The token may be sent as "Authorization: Bearer TOKEN" or, for EventSource
+// clients that cannot set headers, as a ?token= query parameter.
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"td-file/output"
+	"td-file/parser"
+	filesync "td-file/sync"
+)
+
+// Server serves one todo file.
+type Server struct {
+	path  string
+	token string
+
+	mu       sync.Mutex
+	clients  map[chan []byte]struct{}
+	lastETag string
+}
+
+// New returns a server for the todo file at path. An empty token disables
+// authentication.
+func New(path, token string) *Server {
+	return &Server{path: path, token: token, clients: make(map[chan []byte]struct{})}
+}
+
+// Handler returns the HTTP handler for the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/todos", s.handleList)
+	mux.HandleFunc("POST /api/todos", s.handleAdd)
+	mux.HandleFunc("PATCH /api/todos/{line}", s.handleUpdate)
+	mux.HandleFunc("POST /api/todos/{line}/move", s.handleMove)
+	mux.HandleFunc("DELETE /api/todos/{line}", s.handleDelete)
+	mux.HandleFunc("GET /api/events", s.handleEvents)
+	return s.auth(mux)
+}
+
+// Watch broadcasts a change event for every notification on reload, such as
+// a FileSynchronizer's ReloadCh, until stop is closed.
+func (s *Server) Watch(reload <-chan struct{}, stop <-chan struct{}) {
+	for {
+		select {
+		case <-reload:
+			s.broadcast()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (s *Server) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if got == "" {
+				got = r.URL.Query().Get("token")
+			}
+			if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+				writeError(w, http.StatusUnauthorized, "missing or invalid token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// --- documents ---
+
+func (s *Server) load() (output.Document, string, error) {
+	blocks, warnings, err := parser.ExtractTdBlocksWithWarnings(s.path)
+	if err != nil {
+		return output.Document{}, "", err
+	}
+	todos, warn2 := parser.ParseTodosWithWarnings(blocks)
+	return output.NewDocument(s.path, todos, append(warnings, warn2...)), etag(todos), nil
+}
+
+// etag identifies a version of the todo list by its rendered content.
+func etag(todos []parser.Todo) string {
+	h := sha256.New()
+	for _, t := range todos {
+		fmt.Fprintf(h, "%d\x00%s\n", t.Block, parser.FormatTodo(t))
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil))[:16] + `"`
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	doc, tag, err := s.load()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeDoc(w, http.StatusOK, doc, tag)
+}
+
+// --- mutations ---
+
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+func errorf(status int, format string, args ...any) error {
+	return &httpError{status, fmt.Sprintf(format, args...)}
+}
+
+// mutate runs fn on the current tree under the file lock, writes the result
+// and responds with the new document. Handlers read and check the request
+// body before calling it, so a slow client never holds the lock.
+func (s *Server) mutate(w http.ResponseWriter, r *http.Request, status int, fn func(roots []*parser.Todo) ([]*parser.Todo, error)) {
+	ifMatch := r.Header.Get("If-Match")
+	err := filesync.UpdateFile(s.path, func(todos []parser.Todo) ([]parser.Todo, error) {
+		if ifMatch != "" && ifMatch != etag(todos) {
+			return nil, errorf(http.StatusPreconditionFailed, "todo file has changed; reload and retry")
+		}
+		roots, err := fn(parser.BuildTree(todos))
+		if err != nil {
+			return nil, err
+		}
+		return parser.Flatten(roots), nil
+	})
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	doc, tag, err := s.load()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeDoc(w, status, doc, tag)
+	s.broadcast()
+}
+
+// fail responds with err's status if it is an httpError, otherwise 500.
+func fail(w http.ResponseWriter, err error) {
+	var he *httpError
+	if errors.As(err, &he) {
+		writeError(w, he.status, he.msg)
+	} else {
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func decode(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return errorf(http.StatusBadRequest, "invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+func lookup(roots []*parser.Todo, r *http.Request) (*parser.Todo, error) {
+	line, err := strconv.Atoi(r.PathValue("line"))
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "invalid line %q", r.PathValue("line"))
+	}
+	n := parser.Find(roots, line)
+	if n == nil {
+		return nil, errorf(http.StatusNotFound, "no todo at line %d", line)
+	}
+	return n, nil
+}
+
+// detach removes n from its parent (or from roots) and returns the new roots.
+func detach(roots []*parser.Todo, n *parser.Todo) []*parser.Todo {
+	siblings := roots
+	if n.Parent != nil {
+		siblings = n.Parent.Children
+	}
+	for i, c := range siblings {
+		if c == n {
+			siblings = append(siblings[:i:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if n.Parent != nil {
+		n.Parent.Children = siblings
+		return roots
+	}
+	return siblings
+}
+
+func validText(text string) error {
+	if strings.TrimSpace(text) == "" {
+		return errorf(http.StatusBadRequest, "text must not be empty")
+	}
+	if !parser.SingleLine(text) {
+		return errorf(http.StatusBadRequest, "text must be a single line")
+	}
+	return nil
+}
+
+type addRequest struct {
+	Text        string `json:"text"`
+	State       string `json:"state"`
+	Highlighted bool   `json:"highlighted"`
+	Parent      int    `json:"parent"`
+	Block       int    `json:"block"`
+}
+
+func (s *Server) handleAdd(w http.ResponseWriter, r *http.Request) {
+	var req addRequest
+	if err := decode(r, &req); err != nil {
+		fail(w, err)
+		return
+	}
+	if err := validText(req.Text); err != nil {
+		fail(w, err)
+		return
+	}
+	state := parser.Incomplete
+	if req.State != "" {
+		var err error
+		if state, err = parser.ParseState(req.State); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	s.mutate(w, r, http.StatusCreated, func(roots []*parser.Todo) ([]*parser.Todo, error) {
+		t := &parser.Todo{Text: req.Text, Block: req.Block}
+		parser.SetHighlight(t, req.Highlighted)
+		parser.SetState(t, state)
+		if req.Parent == 0 {
+			return append(roots, t), nil
+		}
+		parent := parser.Find(roots, req.Parent)
+		if parent == nil {
+			return nil, errorf(http.StatusNotFound, "no todo at line %d", req.Parent)
+		}
+		t.Block = parent.Block
+		t.Parent = parent
+		parser.AddChild(parent, t)
+		return roots, nil
+	})
+}
+
+type updateRequest struct {
+	Text        *string `json:"text"`
+	State       *string `json:"state"`
+	Highlighted *bool   `json:"highlighted"`
+}
+
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	var req updateRequest
+	if err := decode(r, &req); err != nil {
+		fail(w, err)
+		return
+	}
+	if req.Text != nil {
+		if err := validText(*req.Text); err != nil {
+			fail(w, err)
+			return
+		}
+	}
+	var state parser.TodoState
+	if req.State != nil {
+		var err error
+		if state, err = parser.ParseState(*req.State); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	s.mutate(w, r, http.StatusOK, func(roots []*parser.Todo) ([]*parser.Todo, error) {
+		n, err := lookup(roots, r)
+		if err != nil {
+			return nil, err
+		}
+		if req.Text != nil {
+			n.Text = *req.Text
+		}
+		if req.State != nil {
+			parser.SetState(n, state)
+		}
+		if req.Highlighted != nil {
+			if *req.Highlighted && n.State != parser.Incomplete {
+				return nil, errorf(http.StatusConflict, "only incomplete todos can be highlighted")
+			}
+			parser.SetHighlight(n, *req.Highlighted)
+		}
+		return roots, nil
+	})
+}
+
+type moveRequest struct {
+	Parent int  `json:"parent"`
+	Index  *int `json:"index"`
+}
+
+func (s *Server) handleMove(w http.ResponseWriter, r *http.Request) {
+	var req moveRequest
+	if err := decode(r, &req); err != nil {
+		fail(w, err)
+		return
+	}
+	s.mutate(w, r, http.StatusOK, func(roots []*parser.Todo) ([]*parser.Todo, error) {
+		n, err := lookup(roots, r)
+		if err != nil {
+			return nil, err
+		}
+		var parent *parser.Todo
+		if req.Parent != 0 {
+			parent = parser.Find(roots, req.Parent)
+			if parent == nil {
+				return nil, errorf(http.StatusNotFound, "no todo at line %d", req.Parent)
+			}
+			for a := parent; a != nil; a = a.Parent {
+				if a == n {
+					return nil, errorf(http.StatusConflict, "cannot move a todo under itself")
+				}
+			}
+		}
+		roots = detach(roots, n)
+		siblings := roots
+		if parent != nil {
+			siblings = parent.Children
+		}
+		idx := len(siblings)
+		if req.Index != nil && *req.Index >= 0 && *req.Index < idx {
+			idx = *req.Index
+		}
+		siblings = append(siblings[:idx:idx], append([]*parser.Todo{n}, siblings[idx:]...)...)
+		n.Parent = parent
+		if parent != nil {
+			parent.Children = siblings
+			setBlock(n, parent.Block)
+			return roots, nil
+		}
+		return siblings, nil
+	})
+}
+
+func setBlock(n *parser.Todo, block int) {
+	n.Block = block
+	for _, c := range n.Children {
+		setBlock(c, block)
+	}
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	s.mutate(w, r, http.StatusOK, func(roots []*parser.Todo) ([]*parser.Todo, error) {
+		n, err := lookup(roots, r)
+		if err != nil {
+			return nil, err
+		}
+		return detach(roots, n), nil
+	})
+}
+
+// --- events ---
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	ch := make(chan []byte, 1)
+	s.mu.Lock()
+	s.clients[ch] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, ch)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if doc, tag, err := s.load(); err == nil {
+		if data, err := json.Marshal(doc); err == nil {
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
+		}
+		s.mu.Lock()
+		s.lastETag = tag
+		s.mu.Unlock()
+	}
+	flusher.Flush()
+	for {
+		select {
+		case data := <-ch:
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// broadcast sends the current document to every event stream, skipping
+// notifications that did not change the list (e.g. the watcher firing for a
+// write this server just made).
+func (s *Server) broadcast() {
+	doc, tag, err := s.load()
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tag == s.lastETag {
+		return
+	}
+	s.lastETag = tag
+	for ch := range s.clients {
+		// Replace any undelivered event: clients only need the latest state.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- data
+	}
+}
+
+// --- responses ---
+
+func writeDoc(w http.ResponseWriter, status int, doc output.Document, tag string) {
+	w.Header().Set("ETag", tag)
+	writeJSON(w, status, doc)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
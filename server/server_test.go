@@ -0,0 +1,256 @@
+package server_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"td-file/output"
+	"td-file/parser"
+	"td-file/server"
+)
+
+const token = "secret"
+
+func setup(t *testing.T, content string) (*httptest.Server, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "todos.md")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+	ts := httptest.NewServer(server.New(path, token).Handler())
+	t.Cleanup(ts.Close)
+	return ts, path
+}
+
+func do(t *testing.T, ts *httptest.Server, method, url, body string, header ...string) (*http.Response, output.Document) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	var doc output.Document
+	json.NewDecoder(resp.Body).Decode(&doc)
+	return resp, doc
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	return string(b)
+}
+
+func TestAuth(t *testing.T) {
+	ts, _ := setup(t, ":td\n- [ ] A\n:td\n")
+	resp, err := http.Get(ts.URL + "/api/todos")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", resp.StatusCode)
+	}
+	resp, err = http.Get(ts.URL + "/api/todos?token=" + token)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 with query token, got %d", resp.StatusCode)
+	}
+}
+
+func TestListAndMutations(t *testing.T) {
+	ts, path := setup(t, "# Today\n:td\n- [ ] A\n  - [ ] B\n- [ ] C\n:td\n")
+
+	resp, doc := do(t, ts, "GET", "/api/todos", "")
+	if resp.StatusCode != http.StatusOK || len(doc.Todos) != 2 || resp.Header.Get("ETag") == "" {
+		t.Fatalf("unexpected list response %d: %+v", resp.StatusCode, doc)
+	}
+
+	resp, _ = do(t, ts, "POST", "/api/todos", `{"text":"D","parent":3}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("add returned %d", resp.StatusCode)
+	}
+	if got := readFile(t, path); got != "# Today\n:td\n- [ ] A\n  - [ ] B\n- [ ] C\n  - [ ] D\n:td\n" {
+		t.Fatalf("unexpected file after add: %q", got)
+	}
+
+	resp, _ = do(t, ts, "PATCH", "/api/todos/2", `{"state":"completed","text":"B done"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update returned %d", resp.StatusCode)
+	}
+	resp, _ = do(t, ts, "PATCH", "/api/todos/1", `{"highlighted":true}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("highlight returned %d", resp.StatusCode)
+	}
+	if got := readFile(t, path); got != "# Today\n:td\n- [ ] A *\n  - [x] B done\n- [ ] C\n  - [ ] D\n:td\n" {
+		t.Fatalf("unexpected file after update: %q", got)
+	}
+
+	resp, _ = do(t, ts, "POST", "/api/todos/4/move", `{"parent":0,"index":0}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("move returned %d", resp.StatusCode)
+	}
+	if got := readFile(t, path); got != "# Today\n:td\n- [ ] D\n- [ ] A *\n  - [x] B done\n- [ ] C\n:td\n" {
+		t.Fatalf("unexpected file after move: %q", got)
+	}
+
+	resp, _ = do(t, ts, "DELETE", "/api/todos/2", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("delete returned %d", resp.StatusCode)
+	}
+	if got := readFile(t, path); got != "# Today\n:td\n- [ ] D\n- [ ] C\n:td\n" {
+		t.Fatalf("unexpected file after delete: %q", got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ts, _ := setup(t, ":td\n- [ ] A\n  - [ ] B\n:td\n")
+	cases := []struct {
+		method, url, body string
+		want              int
+	}{
+		{"PATCH", "/api/todos/9", `{"text":"x"}`, http.StatusNotFound},
+		{"PATCH", "/api/todos/1", `{"state":"bogus"}`, http.StatusBadRequest},
+		{"PATCH", "/api/todos/1", `{"text":"two\nlines"}`, http.StatusBadRequest},
+		{"POST", "/api/todos", `{"text":""}`, http.StatusBadRequest},
+		{"POST", "/api/todos", `not json`, http.StatusBadRequest},
+		{"POST", "/api/todos/1/move", `{"parent":2}`, http.StatusConflict},
+	}
+	for _, c := range cases {
+		resp, _ := do(t, ts, c.method, c.url, c.body)
+		if resp.StatusCode != c.want {
+			t.Errorf("%s %s %s: got %d, want %d", c.method, c.url, c.body, resp.StatusCode, c.want)
+		}
+	}
+}
+
+func TestAdd_StampsState(t *testing.T) {
+	defer parser.Configure(parser.Settings{})
+	defer func(now func() time.Time) { parser.Now = now }(parser.Now)
+	parser.Now = func() time.Time { return time.Date(2024, 6, 7, 12, 0, 0, 0, time.Local) }
+	if err := parser.Configure(parser.Settings{StampDone: true}); err != nil {
+		t.Fatal(err)
+	}
+	ts, path := setup(t, ":td\n- [ ] A\n:td\n")
+	resp, _ := do(t, ts, "POST", "/api/todos", `{"text":"B","state":"completed","highlighted":true}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("add returned %d", resp.StatusCode)
+	}
+	if got := readFile(t, path); got != ":td\n- [ ] A\n- [x] B done:2024-06-07\n:td\n" {
+		t.Errorf("unexpected file after add: %q", got)
+	}
+}
+
+func TestSlowBodyDoesNotHoldLock(t *testing.T) {
+	ts, path := setup(t, ":td\n- [ ] A\n:td\n")
+	body, stalled := io.Pipe()
+	defer stalled.Close()
+	req, _ := http.NewRequest("PATCH", ts.URL+"/api/todos/1", body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	go func() {
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	stalled.Write([]byte(`{"text":`))
+
+	done := make(chan int)
+	go func() {
+		resp, _ := do(t, ts, "PATCH", "/api/todos/1", `{"text":"A2"}`)
+		done <- resp.StatusCode
+	}()
+	select {
+	case code := <-done:
+		if code != http.StatusOK || readFile(t, path) != ":td\n- [ ] A2\n:td\n" {
+			t.Errorf("update returned %d, file %q", code, readFile(t, path))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("a stalled request body blocked another update")
+	}
+}
+
+func TestIfMatchConflict(t *testing.T) {
+	ts, path := setup(t, ":td\n- [ ] A\n:td\n")
+	resp, _ := do(t, ts, "GET", "/api/todos", "")
+	tag := resp.Header.Get("ETag")
+	// Someone else edits the file.
+	if err := os.WriteFile(path, []byte(":td\n- [ ] Z\n- [ ] A\n:td\n"), 0644); err != nil {
+		t.Fatalf("failed to rewrite file: %v", err)
+	}
+	resp, _ = do(t, ts, "PATCH", "/api/todos/1", `{"state":"completed"}`, "If-Match", tag)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale ETag, got %d", resp.StatusCode)
+	}
+	if got := readFile(t, path); got != ":td\n- [ ] Z\n- [ ] A\n:td\n" {
+		t.Errorf("file should be untouched after a conflict, got %q", got)
+	}
+	resp, _ = do(t, ts, "GET", "/api/todos", "")
+	resp, _ = do(t, ts, "PATCH", "/api/todos/2", `{"state":"completed"}`, "If-Match", resp.Header.Get("ETag"))
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 with fresh ETag, got %d", resp.StatusCode)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	ts, _ := setup(t, ":td\n- [ ] A\n:td\n")
+	req, _ := http.NewRequest("GET", ts.URL+"/api/events?token="+token, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("events request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	events := make(chan output.Document, 4)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				var doc output.Document
+				if json.Unmarshal([]byte(data), &doc) == nil {
+					events <- doc
+				}
+			}
+		}
+	}()
+	next := func() output.Document {
+		select {
+		case doc := <-events:
+			return doc
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for event")
+			return output.Document{}
+		}
+	}
+
+	if doc := next(); len(doc.Todos) != 1 {
+		t.Fatalf("expected initial snapshot with 1 todo, got %+v", doc)
+	}
+	do(t, ts, "POST", "/api/todos", `{"text":"B"}`)
+	if doc := next(); len(doc.Todos) != 2 || doc.Todos[1].Text != "B" {
+		t.Fatalf("expected change event with new todo, got %+v", doc)
+	}
+}
@@ -0,0 +1,142 @@
+// Package session remembers how the TUI was left for each todo file: which
+// todos and sections were collapsed, where the cursor was and the active
+// filter. States are kept as JSON under config.StateDir, one file per todo
+// file.
+//
+// Todos are identified by Key rather than by line number, so the state
+// survives edits made elsewhere in the file.
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"td-file/config"
+	"td-file/parser"
+)
+
+// State is the saved view of one todo file.
+type State struct {
+	// File is the absolute path of the todo file, used by Prune.
+	File      string   `json:"file"`
+	Collapsed []string `json:"collapsed,omitempty"`
+	// Sections are the collapsed :td blocks, by SectionKey.
+	Sections []string `json:"sections,omitempty"`
+	Cursor   string   `json:"cursor,omitempty"`
+	Filter   string   `json:"filter,omitempty"`
+}
+
+// Key identifies a todo within its file: its uid: field if it has one,
+// otherwise its block and the text of it and its ancestors.
+func Key(t *parser.Todo) string {
+	if uid := parser.ParseMetadata(t.Text).Fields["uid"]; uid != "" {
+		return "uid:" + uid
+	}
+	var path []string
+	for n := t; n != nil; n = n.Parent {
+		path = append([]string{parser.StripFields(n.Text, "done")}, path...)
+	}
+	return fmt.Sprintf("%d/%s", t.Block, strings.Join(path, "\x1f"))
+}
+
+// SectionKey identifies a :td block: by its label, or its position if it
+// has none.
+func SectionKey(b parser.Block, index int) string {
+	if label := b.Label(); label != "" {
+		return "name:" + label
+	}
+	return fmt.Sprintf("#%d", index)
+}
+
+func dir() (string, error) {
+	d, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d, "session"), nil
+}
+
+// Load returns the saved state for the todo file at path; a file with no
+// saved state gets the zero State.
+func Load(path string) (State, error) {
+	var s State
+	file, err := config.StateFile("session", path)
+	if err != nil {
+		return s, err
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return State{}, fmt.Errorf("session: corrupt state %s: %w", file, err)
+	}
+	return s, nil
+}
+
+// Save stores s as the state for the todo file at path.
+func Save(path string, s State) error {
+	file, err := config.StateFile("session", path)
+	if err != nil {
+		return err
+	}
+	if s.File, err = filepath.Abs(path); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Prune deletes saved states whose todo file no longer exists, and any
+// that cannot be read. It returns how many were deleted.
+func Prune() (int, error) {
+	d, err := dir()
+	if err != nil {
+		return 0, err
+	}
+	entries, err := os.ReadDir(d)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		file := filepath.Join(d, e.Name())
+		var s State
+		data, err := os.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(data, &s)
+		}
+		if err == nil && s.File != "" {
+			if _, err = os.Stat(s.File); !errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+		}
+		if os.Remove(file) == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
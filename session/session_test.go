@@ -0,0 +1,70 @@
+package session_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"td-file/parser"
+	"td-file/session"
+)
+
+func TestSaveLoad(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "todos.md")
+	os.WriteFile(path, []byte(":td\n:td\n"), 0644)
+
+	if s, err := session.Load(path); err != nil || !reflect.DeepEqual(s, session.State{}) {
+		t.Fatalf("a new file should have no state, got %+v, %v", s, err)
+	}
+	want := session.State{Collapsed: []string{"0/Plan"}, Sections: []string{"name:Home"}, Cursor: "uid:x", Filter: "tag:work"}
+	if err := session.Save(path, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := session.Load(path)
+	want.File, _ = filepath.Abs(path)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Load = %+v, %v; want %+v", got, err, want)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	state := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", state)
+	dir := t.TempDir()
+	keep, gone := filepath.Join(dir, "keep.md"), filepath.Join(dir, "gone.md")
+	os.WriteFile(keep, nil, 0644)
+	os.WriteFile(gone, nil, 0644)
+	session.Save(keep, session.State{Cursor: "a"})
+	session.Save(gone, session.State{Cursor: "b"})
+	os.Remove(gone)
+	os.WriteFile(filepath.Join(state, "td-file", "session", "junk.json"), []byte("{"), 0600)
+
+	if n, err := session.Prune(); err != nil || n != 2 {
+		t.Errorf("Prune = %d, %v; want 2 removed", n, err)
+	}
+	if s, _ := session.Load(keep); s.Cursor != "a" {
+		t.Errorf("state of an existing file was pruned")
+	}
+}
+
+func TestKey(t *testing.T) {
+	roots := parser.BuildTree(parser.ParseTodos([][]string{
+		{"- [ ] Plan", "  - [x] Book done:2024-06-01", "- [ ] Synced uid:abc"},
+		{"- [ ] Plan"},
+	}))
+	book := roots[0].Children[0]
+	if got := session.Key(book); got != "0/Plan\x1fBook" {
+		t.Errorf("Key = %q", got)
+	}
+	if got := session.Key(roots[1]); got != "uid:abc" {
+		t.Errorf("uid should win, got %q", got)
+	}
+	if session.Key(roots[0]) == session.Key(roots[2]) {
+		t.Error("same text in different blocks must differ")
+	}
+	if got := session.SectionKey(parser.Block{}, 2); got != "#2" {
+		t.Errorf("SectionKey = %q", got)
+	}
+}
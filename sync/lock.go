@@ -0,0 +1,123 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LockTimeout bounds how long a writer waits for another process to release
+// a todo file before giving up with ErrLockTimeout.
+var LockTimeout = 5 * time.Second
+
+// ErrLockTimeout is returned when a todo file stays locked by another process
+// for longer than LockTimeout.
+var ErrLockTimeout = errors.New("timed out waiting for file lock")
+
+// lockPollInterval is how often a busy lock is retried.
+const lockPollInterval = 10 * time.Millisecond
+
+// LockPath returns the sidecar lock file for a todo file: a hidden file next
+// to it, e.g. ".todos.md.lock". The todo file itself is never locked because
+// editors and WriteTodosToFile replace its contents.
+func LockPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".lock")
+}
+
+// fileLock is an acquired cross-process lock.
+type fileLock struct {
+	f *os.File
+}
+
+// lockFile takes the cross-process lock for path, waiting up to LockTimeout.
+// The holder's PID is recorded in the lock file for the timeout error only:
+// the kernel drops a flock when its holder exits, so a busy lock always has
+// a live holder, even when its PID means nothing here (another PID
+// namespace or a network share).
+func lockFile(path string) (*fileLock, error) {
+	lp := LockPath(path)
+	deadline := time.Now().Add(LockTimeout)
+	for {
+		f, err := os.OpenFile(lp, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if ok {
+			// Someone may have deleted the lock file between our open and
+			// lock; then we hold a lock on an orphaned inode.
+			if sameFile(f, lp) {
+				f.Truncate(0)
+				f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+				return &fileLock{f: f}, nil
+			}
+			unlock(f)
+			f.Close()
+			continue
+		}
+		holder := readHolder(f)
+		f.Close()
+		if time.Now().After(deadline) {
+			if holder > 0 {
+				return nil, fmt.Errorf("%w on %s (held by pid %d)", ErrLockTimeout, path, holder)
+			}
+			return nil, fmt.Errorf("%w on %s", ErrLockTimeout, path)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// release drops the lock. The lock file is left in place so that waiters
+// never lock a file that is about to disappear.
+func (l *fileLock) release() {
+	l.f.Truncate(0)
+	unlock(l.f)
+	l.f.Close()
+}
+
+func sameFile(f *os.File, path string) bool {
+	a, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	b, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(a, b)
+}
+
+// readHolder returns the PID recorded in a lock file, or 0 if there is none.
+func readHolder(f *os.File) int {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// lock takes both the in-process mutex and the cross-process file lock for
+// path and returns a function that releases them.
+func lock(path string) (func(), error) {
+	mu := lockFor(path)
+	mu.Lock()
+	fl, err := lockFile(path)
+	if err != nil {
+		mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		fl.release()
+		mu.Unlock()
+	}, nil
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package sync
+
+import "os"
+
+// Without flock, the lock file only serialises writers within this process
+// (via lockFor); cross-process locking is unix-only.
+
+func tryLock(f *os.File) (bool, error) { return true, nil }
+
+func unlock(f *os.File) {}
@@ -0,0 +1,146 @@
+//go:build unix
+
+package sync_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"td-file/parser"
+	"td-file/sync"
+)
+
+// TestHelperWriter is not a real test: TestUpdateFile_ConcurrentProcesses
+// re-executes the test binary to run it as a separate writer process.
+func TestHelperWriter(t *testing.T) {
+	path := os.Getenv("TD_FILE_TEST_WRITER")
+	if path == "" {
+		t.Skip("helper process")
+	}
+	name := os.Getenv("TD_FILE_TEST_WRITER_NAME")
+	for i := 0; i < 20; i++ {
+		err := sync.UpdateFile(path, func(todos []parser.Todo) ([]parser.Todo, error) {
+			return append(todos, parser.Todo{Text: fmt.Sprintf("%s-%d", name, i)}), nil
+		})
+		if err != nil {
+			t.Fatalf("UpdateFile failed: %v", err)
+		}
+	}
+}
+
+func TestUpdateFile_ConcurrentProcesses(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "todos.md")
+	if err := os.WriteFile(file, []byte("# Notes\n:td\n:td\n"), 0644); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+	const writers = 4
+	var cmds []*exec.Cmd
+	for w := 0; w < writers; w++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHelperWriter$")
+		cmd.Env = append(os.Environ(), "TD_FILE_TEST_WRITER="+file, "TD_FILE_TEST_WRITER_NAME=w"+strconv.Itoa(w))
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("failed to start writer: %v", err)
+		}
+		cmds = append(cmds, cmd)
+	}
+	// Write from this process too, through the synchronizer's locks.
+	for i := 0; i < 20; i++ {
+		sync.UpdateFile(file, func(todos []parser.Todo) ([]parser.Todo, error) {
+			return append(todos, parser.Todo{Text: fmt.Sprintf("main-%d", i)}), nil
+		})
+	}
+	for _, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			t.Fatalf("writer failed: %v", err)
+		}
+	}
+	blocks, err := parser.ExtractTdBlocks(file)
+	if err != nil {
+		t.Fatalf("failed to read result: %v", err)
+	}
+	todos := parser.ParseTodos(blocks)
+	if len(todos) != (writers+1)*20 {
+		t.Fatalf("expected %d todos, got %d: updates were lost", (writers+1)*20, len(todos))
+	}
+	seen := map[string]bool{}
+	for _, td := range todos {
+		seen[td.Text] = true
+	}
+	if len(seen) != len(todos) {
+		t.Errorf("expected every todo once, got duplicates")
+	}
+}
+
+// holdLock takes the sidecar lock for path as another process would and
+// records pid as its holder.
+func holdLock(t *testing.T, path string, pid int) *os.File {
+	t.Helper()
+	f, err := os.OpenFile(sync.LockPath(path), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("failed to open lock file: %v", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatalf("flock failed: %v", err)
+	}
+	f.WriteString(strconv.Itoa(pid) + "\n")
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestUpdateFile_LockTimeout(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "todos.md")
+	os.WriteFile(file, []byte(":td\n- [ ] A\n:td\n"), 0644)
+	old := sync.LockTimeout
+	sync.LockTimeout = 50 * time.Millisecond
+	defer func() { sync.LockTimeout = old }()
+
+	holdLock(t, file, os.Getpid())
+	err := sync.UpdateFile(file, func(todos []parser.Todo) ([]parser.Todo, error) { return nil, nil })
+	if !errors.Is(err, sync.ErrLockTimeout) || !strings.Contains(err.Error(), strconv.Itoa(os.Getpid())) {
+		t.Fatalf("expected lock timeout naming the holder, got %v", err)
+	}
+	if content, _ := os.ReadFile(file); string(content) != ":td\n- [ ] A\n:td\n" {
+		t.Errorf("file should be untouched, got %q", content)
+	}
+}
+
+func TestUpdateFile_RecordedPIDIsNotALock(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "todos.md")
+	os.WriteFile(file, []byte(":td\n- [ ] A\n:td\n"), 0644)
+	dead := exec.Command(os.Args[0], "-test.run=^$")
+	if err := dead.Run(); err != nil {
+		t.Fatalf("failed to run helper: %v", err)
+	}
+
+	// A lock file left behind by a writer that exited is not locked.
+	os.WriteFile(sync.LockPath(file), []byte(strconv.Itoa(dead.Process.Pid)+"\n"), 0644)
+	err := sync.UpdateFile(file, func(todos []parser.Todo) ([]parser.Todo, error) {
+		todos[0].State = parser.Completed
+		return todos, nil
+	})
+	if err != nil {
+		t.Fatalf("an unlocked lock file should not block writers, got %v", err)
+	}
+
+	// A held flock is never broken, whatever PID it records: the holder may
+	// live in another PID namespace.
+	old := sync.LockTimeout
+	sync.LockTimeout = 50 * time.Millisecond
+	defer func() { sync.LockTimeout = old }()
+	holdLock(t, file, dead.Process.Pid)
+	err = sync.UpdateFile(file, func(todos []parser.Todo) ([]parser.Todo, error) { return nil, nil })
+	if !errors.Is(err, sync.ErrLockTimeout) {
+		t.Fatalf("a held lock must not be broken, got %v", err)
+	}
+	if content, _ := os.ReadFile(file); string(content) != ":td\n- [x] A\n:td\n" {
+		t.Errorf("unexpected content: %q", content)
+	}
+}
@@ -1,6 +1,9 @@
 package sync
 
 import (
+	"errors"
+	"fmt"
+	"os"
 	"sync"
 
 	"td-file/parser"
@@ -12,8 +15,86 @@ type FileSynchronizer struct {
 	Path     string
 	ReloadCh chan struct{}
 	SaveCh   chan []parser.Todo
+	// ErrCh reports background failures: saves that could not be written
+	// (e.g. the file lock timed out) and anything passed to Report.
+	ErrCh chan error
+	// OnChange, if set, is called from the watcher goroutine with the path
+	// of the file whenever it is written, by this process or another one.
+	OnChange func(path string)
 	stopCh   chan struct{}
-	mu       sync.Mutex
+	pathCh   chan string
+	watcher  *fsnotify.Watcher
+}
+
+var (
+	locksMu sync.Mutex
+	locks   = map[string]*sync.Mutex{}
+)
+
+// lockFor returns the process-wide mutex guarding writes to path, so that the
+// synchronizer and one-off updates such as agenda actions never interleave.
+// Other processes are kept out by the sidecar file lock taken in lock.
+func lockFor(path string) *sync.Mutex {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	mu, ok := locks[path]
+	if !ok {
+		mu = &sync.Mutex{}
+		locks[path] = mu
+	}
+	return mu
+}
+
+// UpdateFile performs a read-modify-write cycle on the todos in path while
+// holding the same locks used by FileSynchronizer saves, including the
+// cross-process file lock. fn receives the parsed todos and returns the list
+// to write back; returning an error aborts the write.
+func UpdateFile(path string, fn func([]parser.Todo) ([]parser.Todo, error)) error {
+	unlock, err := lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	blocks, err := parser.ExtractTdBlocks(path)
+	if err != nil {
+		return err
+	}
+	todos, err := fn(parser.ParseTodos(blocks))
+	if err != nil {
+		return err
+	}
+	parser.WriteTodosToFile(path, todos)
+	return nil
+}
+
+// AppendBlock adds an empty :td block called name at the end of path, under
+// the same locks as UpdateFile.
+func AppendBlock(path, name string) error {
+	unlock, err := lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return parser.AppendBlock(path, name)
+}
+
+// EditFile rewrites the raw contents of path under the same locks as
+// UpdateFile, for changes outside the :td blocks. A missing file is passed
+// to fn as empty and created.
+func EditFile(path string, fn func(content []byte) ([]byte, error)) error {
+	unlock, err := lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if content, err = fn(content); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
 }
 
 func NewFileSynchronizer(path string) *FileSynchronizer {
@@ -21,6 +102,7 @@ func NewFileSynchronizer(path string) *FileSynchronizer {
 		Path:     path,
 		ReloadCh: make(chan struct{}, 1),
 		SaveCh:   make(chan []parser.Todo, 1),
+		ErrCh:    make(chan error, 1),
 		stopCh:   make(chan struct{}),
 	}
 }
@@ -31,8 +113,11 @@ func (fs *FileSynchronizer) Start() error {
 		return err
 	}
 	if err := watcher.Add(fs.Path); err != nil {
+		watcher.Close()
 		return err
 	}
+	fs.watcher = watcher
+	fs.pathCh = make(chan string)
 	go func() {
 		defer watcher.Close()
 		for {
@@ -43,24 +128,77 @@ func (fs *FileSynchronizer) Start() error {
 					case fs.ReloadCh <- struct{}{}:
 					default:
 					}
+					if fs.OnChange != nil {
+						fs.OnChange(event.Name)
+					}
 				}
 			case <-fs.stopCh:
 				return
 			}
 		}
 	}()
-	go func() {
+	go func(path string) {
 		for {
 			select {
 			case todos := <-fs.SaveCh:
-				fs.mu.Lock()
-				parser.WriteTodosToFile(fs.Path, todos)
-				fs.mu.Unlock()
+				fs.write(path, todos)
+			case newPath := <-fs.pathCh:
+				// Flush anything queued for the old file before switching.
+				select {
+				case todos := <-fs.SaveCh:
+					fs.write(path, todos)
+				default:
+				}
+				path = newPath
+				fs.pathCh <- path
 			case <-fs.stopCh:
 				return
 			}
 		}
-	}()
+	}(fs.Path)
+	return nil
+}
+
+func (fs *FileSynchronizer) write(path string, todos []parser.Todo) {
+	unlock, err := lock(path)
+	if err != nil {
+		fs.Report(fmt.Errorf("save failed: %w", err))
+		return
+	}
+	parser.WriteTodosToFile(path, todos)
+	unlock()
+}
+
+// Report queues err on ErrCh for display, dropping it if an earlier error is
+// still unread.
+func (fs *FileSynchronizer) Report(err error) {
+	select {
+	case fs.ErrCh <- err:
+	default:
+	}
+}
+
+// Changed passes a write to another file made alongside this one, such as
+// an agenda edit to a different daily file, on to OnChange.
+func (fs *FileSynchronizer) Changed(path string) {
+	if fs.OnChange != nil {
+		fs.OnChange(path)
+	}
+}
+
+// SetPath points a running synchronizer at a different file. Saves queued
+// before the call are written to the previous file; ReloadCh keeps
+// delivering notifications, now for the new file.
+func (fs *FileSynchronizer) SetPath(path string) error {
+	if fs.watcher != nil {
+		if err := fs.watcher.Add(path); err != nil {
+			return err
+		}
+		fs.watcher.Remove(fs.Path)
+		fs.pathCh <- path
+		<-fs.pathCh
+	}
+	fs.Path = path
 	return nil
 }
 
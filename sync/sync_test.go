@@ -53,3 +53,51 @@ func TestFileSynchronizer_SaveChWrites(t *testing.T) {
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || (len(s) > 0 && (contains(s[1:], substr) || contains(s[:len(s)-1], substr)))) || (len(substr) == 0)
 }
+
+func TestFileSynchronizer_SetPath(t *testing.T) {
+	tmp := t.TempDir()
+	first := filepath.Join(tmp, "a.md")
+	second := filepath.Join(tmp, "b.md")
+	for _, f := range []string{first, second} {
+		if err := os.WriteFile(f, []byte(":td\n- [ ] Old\n:td\n"), 0644); err != nil {
+			t.Fatalf("failed to write temp file: %v", err)
+		}
+	}
+	fs := sync.NewFileSynchronizer(first)
+	if err := fs.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer fs.Stop()
+	fs.SaveCh <- []parser.Todo{{Text: "For A", LineNumber: 1}}
+	if err := fs.SetPath(second); err != nil {
+		t.Fatalf("SetPath failed: %v", err)
+	}
+	fs.SaveCh <- []parser.Todo{{Text: "For B", LineNumber: 1}}
+	time.Sleep(100 * time.Millisecond)
+	a, _ := os.ReadFile(first)
+	b, _ := os.ReadFile(second)
+	if !contains(string(a), "For A") || contains(string(a), "For B") {
+		t.Errorf("unexpected content in first file: %q", a)
+	}
+	if !contains(string(b), "For B") {
+		t.Errorf("unexpected content in second file: %q", b)
+	}
+}
+
+func TestUpdateFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "todos.md")
+	if err := os.WriteFile(file, []byte(":td\n- [ ] A\n:td\n"), 0644); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+	err := sync.UpdateFile(file, func(todos []parser.Todo) ([]parser.Todo, error) {
+		todos[0].State = parser.Completed
+		return todos, nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateFile failed: %v", err)
+	}
+	content, _ := os.ReadFile(file)
+	if string(content) != ":td\n- [x] A\n:td\n" {
+		t.Errorf("unexpected content: %q", content)
+	}
+}
@@ -0,0 +1,201 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"td-file/agenda"
+	"td-file/config"
+	"td-file/control"
+	"td-file/hooks"
+	"td-file/parser"
+	"td-file/session"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// openAgenda scans the daily archive and switches to the agenda screen.
+func (m *Model) openAgenda() {
+	m.agendaOpen = true
+	m.loadAgenda()
+}
+
+func (m *Model) loadAgenda() {
+	var files []config.DatedFile
+	if m.cfg != nil {
+		found, err := config.ListTodoFiles(m.cfg)
+		if err != nil {
+			m.warnings = []string{err.Error()}
+		}
+		files = found
+	} else if m.sync != nil {
+		files = []config.DatedFile{{Path: m.sync.Path}}
+	}
+	groups, warnings, err := agenda.Build(files)
+	if err != nil {
+		m.warnings = []string{err.Error()}
+		return
+	}
+	m.agendaGroups = groups
+	m.warnings = warnings
+	if n := len(agenda.Items(groups)); m.agendaCursor >= n {
+		m.agendaCursor = n - 1
+	}
+	if m.agendaCursor < 0 {
+		m.agendaCursor = 0
+	}
+}
+
+func (m Model) updateAgenda(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	items := agenda.Items(m.agendaGroups)
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc", "g", "q":
+		m.agendaOpen = false
+		m.warnings = nil
+	case "j", "down":
+		if m.agendaCursor < len(items)-1 {
+			m.agendaCursor++
+		}
+	case "k", "up":
+		if m.agendaCursor > 0 {
+			m.agendaCursor--
+		}
+	case "x":
+		if len(items) > 0 {
+			it := items[m.agendaCursor]
+			done, err := agenda.Complete(it, m.agendaApply)
+			if err != nil {
+				m.warnings = []string{err.Error()}
+				return m, nil
+			}
+			m.hooks.StateChanged(it.File.Path, &done, it.Todo.State)
+			m.loadAgenda()
+		}
+	case "p":
+		if len(items) > 0 {
+			it := items[m.agendaCursor]
+			pulled, err := agenda.Pull(it, m.home, m.agendaApply)
+			if err != nil {
+				m.warnings = []string{err.Error()}
+				return m, nil
+			}
+			pushed := it.Todo
+			pushed.State = parser.Pushed
+			m.hooks.StateChanged(it.File.Path, &pushed, it.Todo.State)
+			m.hooks.Fire(hooks.Add, m.home, &pulled)
+			m.loadAgenda()
+		}
+	case "enter":
+		if len(items) > 0 {
+			if err := m.jumpTo(items[m.agendaCursor]); err != nil {
+				m.warnings = []string{err.Error()}
+				return m, nil
+			}
+			m.agendaOpen = false
+		}
+	}
+	return m, nil
+}
+
+// agendaApply performs agenda actions on the file in the main view through
+// the model, like any other edit, and writes other files directly: routing
+// them through this session's own control socket would block the update
+// loop.
+func (m *Model) agendaApply(req control.Request) error {
+	if samePath(req.Path, m.sync.Path) {
+		return m.apply(req)
+	}
+	if err := control.Direct(req); err != nil {
+		return err
+	}
+	m.sync.Changed(req.Path)
+	m.hooks.Fire(hooks.Save, req.Path, nil)
+	return nil
+}
+
+// jumpTo opens the item's source file in the main view (switching the
+// synchronizer if needed) and places the cursor on it.
+func (m *Model) jumpTo(it agenda.Item) error {
+	var saved session.State
+	if it.File.Path != m.sync.Path {
+		m.saveSession()
+		if err := m.sync.SetPath(it.File.Path); err != nil {
+			return err
+		}
+		m.collapsed = make(map[int]bool)
+		m.collapsedBlocks = nil
+		saved, _ = session.Load(it.File.Path)
+	}
+	m.filter = nil
+	m.setFocus(nil)
+	m.reload()
+	saved.Filter, saved.Cursor = "", ""
+	m.restoreSession(saved)
+	var target *parser.Todo
+	var walk func(nodes []*parser.Todo)
+	walk = func(nodes []*parser.Todo) {
+		for _, n := range nodes {
+			if n.LineNumber == it.Todo.LineNumber {
+				target = n
+				return
+			}
+			walk(n.Children)
+		}
+	}
+	walk(m.roots)
+	if target == nil {
+		return nil
+	}
+	for p := target.Parent; p != nil; p = p.Parent {
+		delete(m.collapsed, p.ID)
+	}
+	m.refreshTree()
+	m.cursor = m.flatIndex(target.ID)
+	return nil
+}
+
+func (m Model) agendaView() string {
+	var b strings.Builder
+	header := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("4"))
+	dateStyle := lipgloss.NewStyle().Bold(true)
+	contextStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	cursorStyle := lipgloss.NewStyle().Background(lipgloss.Color("7")).Foreground(lipgloss.Color("0"))
+
+	b.WriteString(header.Render("Agenda") + "\n")
+	for _, w := range m.warnings {
+		fmt.Fprintf(&b, "Warning: %s\n", w)
+	}
+	b.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Render(strings.Repeat("─", 40)) + "\n")
+	if len(m.agendaGroups) == 0 {
+		b.WriteString("Nothing on the agenda.\n")
+	}
+	i := 0
+	for _, g := range m.agendaGroups {
+		label := g.Label()
+		if g.File.Path == m.home {
+			label += " (today)"
+		}
+		b.WriteString(dateStyle.Render(label) + "\n")
+		for _, it := range g.Items {
+			icon := stateIcon(&it.Todo)
+			text := it.Todo.Text
+			if it.Todo.Highlighted {
+				text += " *"
+			}
+			line := fmt.Sprintf("  %s %s", icon, text)
+			if ctx := it.Context(); ctx != "" {
+				line += " " + contextStyle.Render("("+ctx+")")
+			}
+			if i == m.agendaCursor {
+				line = cursorStyle.Render(line)
+			}
+			b.WriteString(line + "\n")
+			i++
+		}
+	}
+	b.WriteString("\nenter jump · x complete · p pull into today · esc back\n")
+	return b.String()
+}
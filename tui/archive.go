@@ -0,0 +1,35 @@
+package tui
+
+import (
+	"time"
+
+	"td-file/archive"
+	"td-file/parser"
+)
+
+// archiveCurrent moves the finished todos in the subtree under the cursor
+// (the todo itself, if it is finished along with all its children) to the
+// archive. The archive is written first, so a failure leaves the todos in
+// place.
+func (m *Model) archiveCurrent() {
+	n := m.current()
+	if n == nil {
+		return
+	}
+	nodes := archive.Finished([]*parser.Todo{n})
+	if len(nodes) == 0 {
+		m.notices = append(m.notices, "archive: nothing finished under this todo")
+		return
+	}
+	path, heading := archive.Target(m.cfg, m.sync.Path)
+	if err := archive.Write(path, heading, archive.Lines(nodes, m.blocks, time.Now())); err != nil {
+		m.notices = append(m.notices, "archive: "+err.Error())
+		return
+	}
+	for _, a := range nodes {
+		m.remove(a)
+	}
+	m.todos = m.flattenForSync()
+	m.refreshTree()
+	m.save(m.todos)
+}
@@ -0,0 +1,95 @@
+package tui
+
+import (
+	"path/filepath"
+	"time"
+
+	"td-file/control"
+)
+
+// controlMsg carries a request received on the session's control socket into
+// the Bubbletea update loop, so it is applied to the model like a keypress.
+type controlMsg struct {
+	req   control.Request
+	reply chan control.Response
+	// claim holds a single token: the update loop takes it to apply the
+	// request, the socket handler to give up on it. Whoever is second
+	// knows the other has acted, so a request is never applied after its
+	// sender was told it failed.
+	claim chan struct{}
+}
+
+func newControlMsg(req control.Request) controlMsg {
+	msg := controlMsg{req: req, reply: make(chan control.Response, 1), claim: make(chan struct{}, 1)}
+	msg.claim <- struct{}{}
+	return msg
+}
+
+// take claims msg, reporting false if it was already claimed.
+func (msg controlMsg) take() bool {
+	select {
+	case <-msg.claim:
+		return true
+	default:
+		return false
+	}
+}
+
+// replyTimeout bounds how long the socket handler waits for the update loop.
+const replyTimeout = 3 * time.Second
+
+// listen starts the control socket for path. Requests are handed to send
+// (the running program) and answered once the model has applied them. A
+// request the model has not picked up within replyTimeout is dropped.
+func listen(path string, send func(controlMsg)) (*control.Listener, error) {
+	return control.Listen(path, func(req control.Request) control.Response {
+		msg := newControlMsg(req)
+		send(msg)
+		select {
+		case resp := <-msg.reply:
+			return resp
+		case <-time.After(replyTimeout):
+			if msg.take() {
+				return control.Response{Handled: true, Error: "td-file session did not respond; nothing was changed"}
+			}
+			return <-msg.reply
+		}
+	})
+}
+
+// handleControl applies a request aimed at the file currently on screen. A
+// request for any other file is left for the caller to write directly.
+func (m *Model) handleControl(req control.Request) control.Response {
+	if !samePath(req.Path, m.sync.Path) {
+		return control.Response{Handled: false}
+	}
+	// The sending process fires the event hooks; only the save is ours.
+	if err := m.apply(req); err != nil {
+		return control.Response{Handled: true, Error: err.Error()}
+	}
+	return control.Response{Handled: true}
+}
+
+// apply performs req on the model and saves the result.
+func (m *Model) apply(req control.Request) error {
+	todos, err := control.Apply(m.flattenForSync(), req)
+	if err != nil {
+		return err
+	}
+	for i := range todos {
+		if todos[i].ID == 0 {
+			todos[i].ID = m.nextID
+			m.nextID++
+		}
+	}
+	m.todos = todos
+	m.refreshTree()
+	m.save(m.todos)
+	return nil
+}
+
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
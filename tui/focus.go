@@ -0,0 +1,108 @@
+package tui
+
+import (
+	"strings"
+
+	"td-file/hooks"
+	"td-file/parser"
+	"td-file/session"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Focus ("hoisting") shows only the children of one todo, as if it were the
+// whole list. The rest of the tree stays loaded and is written back as is.
+// The focused todo is tracked by ID; its session.Key is only used to find it
+// again after a reload renumbers the todos.
+
+var breadcrumbStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+
+// viewRoots returns the nodes the view starts from: the focused todo's
+// children, or every root. A focus that no longer matches a todo is
+// dropped.
+func (m *Model) viewRoots() []*parser.Todo {
+	m.focused = nil
+	reloaded := m.focusReloaded
+	m.focusReloaded = false
+	if m.focusKey == "" {
+		return m.roots
+	}
+	var byID, byKey *parser.Todo
+	var walk func(nodes []*parser.Todo)
+	walk = func(nodes []*parser.Todo) {
+		for _, n := range nodes {
+			if n.ID == m.focusID {
+				byID = n
+			}
+			if byKey == nil && session.Key(n) == m.focusKey {
+				byKey = n
+			}
+			walk(n.Children)
+		}
+	}
+	walk(m.roots)
+	// After a reload IDs are line numbers again; trust one only if it still
+	// names the same todo.
+	m.focused = byKey
+	if byID != nil && (!reloaded || session.Key(byID) == m.focusKey) {
+		m.focused = byID
+	}
+	if m.focused == nil {
+		m.focusKey, m.focusID = "", 0
+		return m.roots
+	}
+	m.focusKey, m.focusID = session.Key(m.focused), m.focused.ID
+	return m.focused.Children
+}
+
+// setFocus makes n, or nothing if n is nil, the focus of the next refresh.
+func (m *Model) setFocus(n *parser.Todo) {
+	m.focusKey, m.focusID = "", 0
+	if n != nil {
+		m.focusKey, m.focusID = session.Key(n), n.ID
+	}
+}
+
+// focusOn makes n the root of the view, expanding it.
+func (m *Model) focusOn(n *parser.Todo) {
+	m.setFocus(n)
+	delete(m.collapsed, n.ID)
+	m.cursor = 0
+	m.refreshTree()
+}
+
+// zoomOut moves the focus to the parent of the focused todo, or clears it,
+// leaving the cursor on the todo that was focused.
+func (m *Model) zoomOut() {
+	n := m.focused
+	if n == nil {
+		return
+	}
+	m.setFocus(n.Parent)
+	m.refreshTree()
+	m.cursor = m.flatIndex(n.ID)
+}
+
+// addUnderFocus adds a first child to the focused todo when the focused
+// view is empty.
+func (m *Model) addUnderFocus() {
+	parent := m.focused
+	n := &parser.Todo{ID: m.nextID, Text: "New todo", State: parser.Incomplete, Block: parent.Block, Parent: parent}
+	m.nextID++
+	parser.Nest(parent, n)
+	parser.AddChild(parent, n)
+	m.todos = m.flattenForSync()
+	m.refreshTree()
+	m.save(m.todos)
+	m.cursor = m.flatIndex(n.ID)
+	m.hooks.Fire(hooks.Add, m.sync.Path, n)
+}
+
+// breadcrumb renders the path from the root down to the focused todo.
+func (m *Model) breadcrumb() string {
+	var path []string
+	for n := m.focused; n != nil; n = n.Parent {
+		path = append([]string{n.Text}, path...)
+	}
+	return breadcrumbStyle.Render("Focus: "+strings.Join(path, " › ")+"  (F to zoom out)") + "\n"
+}
@@ -0,0 +1,70 @@
+package tui
+
+import (
+	"td-file/parser"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Vim-style fold commands, typed as z followed by a second key:
+//
+//	zM  collapse everything      zR  expand everything
+//	zc  collapse the current subtree
+//	zo  expand the current subtree
+//	z1…z9  show that many levels and collapse the rest
+
+// updateFold handles the key after z.
+func (m Model) updateFold(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	m.foldPending = false
+	key := msg.String()
+	switch {
+	case key == "M":
+		m.refold(m.viewRoots(), func(*parser.Todo, int) bool { return true })
+	case key == "R":
+		m.refold(m.viewRoots(), func(*parser.Todo, int) bool { return false })
+	case key == "c" || key == "o":
+		if n := m.current(); n != nil {
+			m.refold([]*parser.Todo{n}, func(*parser.Todo, int) bool { return key == "c" })
+		}
+	case len(key) == 1 && key[0] >= '1' && key[0] <= '9':
+		level := int(key[0] - '0')
+		m.refold(m.viewRoots(), func(_ *parser.Todo, depth int) bool { return depth >= level-1 })
+	}
+	return m, nil
+}
+
+// refold sets the collapse state of every node with children under nodes
+// to fold(node, depth), then keeps the cursor on the todo it was on or, if
+// that is now hidden, its nearest visible ancestor.
+func (m *Model) refold(nodes []*parser.Todo, fold func(n *parser.Todo, depth int) bool) {
+	var path []int
+	for n := m.current(); n != nil; n = n.Parent {
+		path = append(path, n.ID)
+	}
+	depth := 0
+	if len(nodes) > 0 {
+		for p := nodes[0].Parent; p != nil && p != m.focused; p = p.Parent {
+			depth++
+		}
+	}
+	var walk func(nodes []*parser.Todo, depth int)
+	walk = func(nodes []*parser.Todo, depth int) {
+		for _, n := range nodes {
+			if len(n.Children) > 0 {
+				if fold(n, depth) {
+					m.collapsed[n.ID] = true
+				} else {
+					delete(m.collapsed, n.ID)
+				}
+			}
+			walk(n.Children, depth+1)
+		}
+	}
+	walk(nodes, depth)
+	m.refreshTree()
+	// Walk down from the outermost ancestor; each visible one moves the
+	// cursor, so it ends on the deepest.
+	for i := len(path) - 1; i >= 0; i-- {
+		m.cursor = m.flatIndex(path[i])
+	}
+}
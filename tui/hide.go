@@ -0,0 +1,106 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"td-file/parser"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Hiding finished todos, typed as H followed by a second key:
+//
+//	HH  hide the configured states (hide_states), or show everything again
+//	H<state key>  hide or show that state, e.g. Hx for completed todos
+//
+// Hidden todos stay in the file; a todo is only hidden together with all of
+// its descendants (see parser.Hidden).
+
+var hiddenStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Faint(true)
+
+// updateHide handles the key after H.
+func (m Model) updateHide(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	m.hidePending = false
+	key := msg.String()
+	if m.hideStates == nil {
+		m.hideStates, _ = m.cfg.Hidden() // StartTUI reports a bad hide_states
+	}
+	switch state, ok := parser.StateForKey(key); {
+	case key == "H" && len(m.hidden) > 0:
+		m.hidden = nil
+	case key == "H":
+		m.hidden = make(map[parser.TodoState]bool)
+		for s := range m.hideStates {
+			m.hidden[s] = true
+		}
+	case ok:
+		if m.hidden == nil {
+			m.hidden = make(map[parser.TodoState]bool)
+		}
+		if m.hidden[state] {
+			delete(m.hidden, state)
+		} else {
+			m.hidden[state] = true
+		}
+	default:
+		return m, nil
+	}
+	cur := m.current()
+	m.refreshTree()
+	if cur != nil {
+		m.cursor = m.flatIndex(cur.ID)
+	}
+	return m, nil
+}
+
+// hideRows drops the rows of hidden todos and records on each remaining row
+// how many of its children were hidden.
+func (m *Model) hideRows(rows []TreeNodeView) []TreeNodeView {
+	m.hiddenTop = 0
+	if len(m.hidden) == 0 {
+		return rows
+	}
+	hidden := parser.Hidden(m.roots, m.hidden)
+	out := rows[:0]
+	for _, row := range rows {
+		if row.Todo != nil {
+			if hidden[row.Todo] {
+				continue
+			}
+			row.Hidden = 0
+			for _, c := range row.Todo.Children {
+				if hidden[c] {
+					row.Hidden++
+				}
+			}
+		}
+		out = append(out, row)
+	}
+	view := m.roots
+	if m.focused != nil {
+		view = m.focused.Children
+	}
+	for _, r := range view {
+		if hidden[r] {
+			m.hiddenTop++
+		}
+	}
+	return out
+}
+
+// hiddenLine describes what is hidden, for the bottom of the view.
+func (m *Model) hiddenLine() string {
+	var names []string
+	for i, def := range parser.States() {
+		if m.hidden[parser.TodoState(i)] {
+			names = append(names, strings.ReplaceAll(def.Name, "_", " "))
+		}
+	}
+	line := "Hiding " + strings.Join(names, ", ")
+	if m.hiddenTop > 0 {
+		line += fmt.Sprintf(" (+%d hidden at top level)", m.hiddenTop)
+	}
+	return hiddenStyle.Render(line+"; HH to show all") + "\n"
+}
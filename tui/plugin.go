@@ -0,0 +1,71 @@
+package tui
+
+import (
+	"strings"
+
+	"td-file/parser"
+	"td-file/plugins"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// pluginMsg delivers the result of a plugin run started by a key binding.
+type pluginMsg struct {
+	name  string
+	todos []parser.Todo // the snapshot the plugin was given
+	resp  plugins.Response
+	err   error
+}
+
+// runPlugin starts the named plugin in the background with a snapshot of the
+// tree and the selected todo.
+func (m *Model) runPlugin(name string) tea.Cmd {
+	p, ok := plugins.Find(m.pluginDir, name)
+	if !ok {
+		m.notices = append(m.notices, "plugin "+name+" not found in "+m.pluginDir)
+		return nil
+	}
+	cursor := 0
+	if n := m.current(); n != nil {
+		cursor = n.LineNumber
+	}
+	todos := m.flattenForSync()
+	req := plugins.NewRequest(plugins.InvokedByKey, nil, m.sync.Path, todos, cursor)
+	return func() tea.Msg {
+		resp, err := p.Run(req)
+		return pluginMsg{name: name, todos: todos, resp: resp, err: err}
+	}
+}
+
+// handlePlugin applies a plugin's mutations and shows its panel.
+func (m *Model) handlePlugin(msg pluginMsg) {
+	if msg.err != nil {
+		m.notices = append(m.notices, msg.err.Error())
+		return
+	}
+	if len(msg.resp.Mutations) > 0 {
+		req, err := msg.resp.Request(m.sync.Path, msg.todos)
+		if err == nil {
+			err = m.apply(req)
+		}
+		if err != nil {
+			m.notices = append(m.notices, "plugin "+msg.name+": "+err.Error())
+			return
+		}
+	}
+	if msg.resp.Panel != "" {
+		m.panelTitle = msg.name
+		m.panel = strings.TrimRight(msg.resp.Panel, "\n")
+	}
+}
+
+func (m Model) panelView() string {
+	title := lipgloss.NewStyle().Bold(true).Render(m.panelTitle)
+	return lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color("8")).
+		Padding(0, 1).
+		MarginLeft(2).
+		Render(title + "\n" + m.panel + "\n\n(esc to close)")
+}
@@ -0,0 +1,63 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"td-file/parser"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// barWidth is the number of cells in a parent's progress bar.
+const barWidth = 6
+
+// pendingState is a state change waiting for the user to confirm it.
+type pendingState struct {
+	todo  *parser.Todo
+	state parser.TodoState
+	open  int // open descendants of todo
+}
+
+// progressLabel renders "[done/total]" and, if configured, a bar for a
+// todo with children.
+func (m *Model) progressLabel(n *parser.Todo) string {
+	p := parser.CountProgress(n.Children, m.cancelled)
+	if p.Total == 0 {
+		return ""
+	}
+	label := fmt.Sprintf("[%d/%d]", p.Done, p.Total)
+	if m.progress.Bar {
+		filled := p.Done * barWidth / p.Total
+		label += " " + strings.Repeat("▰", filled) + strings.Repeat("▱", barWidth-filled)
+	}
+	return label
+}
+
+// progressLine summarises progress over every todo in view.
+func (m *Model) progressLine() string {
+	p := parser.CountProgress(m.viewRoots(), m.cancelled)
+	if p.Total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Progress: %d/%d done (%d%%)\n", p.Done, p.Total, p.Percent())
+}
+
+// updateConfirm handles the answer to a pending state change.
+func (m Model) updateConfirm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	pending := m.confirm
+	m.confirm = nil
+	if msg.String() == "y" {
+		m.setState(pending.todo, pending.state)
+	}
+	return m, nil
+}
+
+// confirmLine asks about the pending state change.
+func (m *Model) confirmLine() string {
+	question := "Complete it anyway?"
+	if m.cascade.Down {
+		question = "Complete it and them?"
+	}
+	return fmt.Sprintf("\n%q has %d open todo(s) under it. %s (y/n)\n", m.confirm.todo.Text, m.confirm.open, question)
+}
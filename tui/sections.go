@@ -0,0 +1,222 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"td-file/hooks"
+	"td-file/parser"
+	"td-file/sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Sections: when a file has several :td blocks, or named ones, each block is
+// shown under a header row that can be collapsed, jumped to and moved into.
+
+var sectionStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("4"))
+
+// sectioned reports whether blocks are shown as sections.
+func (m *Model) sectioned() bool {
+	if len(m.blocks) > 1 {
+		return true
+	}
+	return len(m.blocks) == 1 && m.blocks[0].Name != ""
+}
+
+// blockOf returns the section a todo is shown in; todos whose block has
+// gone are shown in the last one, where the writer will put them.
+func (m *Model) blockOf(t *parser.Todo) int {
+	return min(max(t.Block, 0), len(m.blocks)-1)
+}
+
+// sectionRows lists each block's header followed by its todos, unless the
+// section is collapsed.
+func (m *Model) sectionRows() []TreeNodeView {
+	byBlock := make([][]*parser.Todo, len(m.blocks))
+	for _, r := range m.roots {
+		b := m.blockOf(r)
+		byBlock[b] = append(byBlock[b], r)
+	}
+	var rows []TreeNodeView
+	for i := range m.blocks {
+		rows = append(rows, TreeNodeView{Header: true, Block: i})
+		if !m.collapsedBlocks[i] {
+			rows = append(rows, flattenTree(byBlock[i], 0)...)
+		}
+	}
+	return rows
+}
+
+// current returns the todo under the cursor, or nil on a header or an empty
+// list.
+func (m *Model) current() *parser.Todo {
+	if m.cursor < 0 || m.cursor >= len(m.flat) {
+		return nil
+	}
+	return m.flat[m.cursor].Todo
+}
+
+// header returns the header row under the cursor, if there is one.
+func (m *Model) header() (TreeNodeView, bool) {
+	if m.cursor < 0 || m.cursor >= len(m.flat) || !m.flat[m.cursor].Header {
+		return TreeNodeView{}, false
+	}
+	return m.flat[m.cursor], true
+}
+
+// section returns the block the cursor is in, or -1 without sections.
+func (m *Model) section() int {
+	if !m.sectioned() || len(m.flat) == 0 {
+		return -1
+	}
+	for i := m.cursor; i >= 0; i-- {
+		if m.flat[i].Header {
+			return m.flat[i].Block
+		}
+	}
+	return 0
+}
+
+// headerRow returns the position of block's header in m.flat.
+func (m *Model) headerRow(block int) int {
+	for i, row := range m.flat {
+		if row.Header && row.Block == block {
+			return i
+		}
+	}
+	return m.cursor
+}
+
+func (m *Model) headerLine(block int) string {
+	icon := "▾"
+	if m.collapsedBlocks[block] {
+		icon = "▸"
+	}
+	label := m.blocks[block].Label()
+	if label == "" {
+		label = fmt.Sprintf("Block %d", block+1)
+	}
+	count := 0
+	for i := range m.todos {
+		if m.blockOf(&m.todos[i]) == block {
+			count++
+		}
+	}
+	return sectionStyle.Render(fmt.Sprintf("%s %s (%d)", icon, label, count))
+}
+
+func (m *Model) toggleSection(block int) {
+	if m.collapsedBlocks == nil {
+		m.collapsedBlocks = make(map[int]bool)
+	}
+	m.collapsedBlocks[block] = !m.collapsedBlocks[block]
+	m.refreshTree()
+	m.cursor = m.headerRow(block)
+}
+
+// jumpSection moves the cursor to the header of the section dir sections
+// away.
+func (m *Model) jumpSection(dir int) {
+	cur := m.section()
+	if cur < 0 {
+		return
+	}
+	if _, onHeader := m.header(); !onHeader && dir < 0 {
+		dir++ // the first press goes to the current section's header
+	}
+	target := min(max(cur+dir, 0), len(m.blocks)-1)
+	m.cursor = m.headerRow(target)
+}
+
+// insertRoot adds a root todo at the end of block, keeping m.roots in
+// document order.
+func (m *Model) insertRoot(n *parser.Todo, block int) {
+	at := len(m.roots)
+	for i, r := range m.roots {
+		if m.blockOf(r) > block {
+			at = i
+			break
+		}
+	}
+	m.roots = append(m.roots[:at], append([]*parser.Todo{n}, m.roots[at:]...)...)
+}
+
+// addToBlock adds a new todo at the end of block.
+func (m *Model) addToBlock(block int) {
+	n := &parser.Todo{ID: m.nextID, Text: "New todo", State: parser.Incomplete, Block: block}
+	m.nextID++
+	for _, r := range m.roots {
+		if m.blockOf(r) == block {
+			n.Marker = r.Marker
+		}
+	}
+	m.insertRoot(n, block)
+	delete(m.collapsedBlocks, block)
+	m.todos = m.flattenForSync()
+	m.refreshTree()
+	m.save(m.todos)
+	m.cursor = m.flatIndex(n.ID)
+	m.hooks.Fire(hooks.Add, m.sync.Path, n)
+}
+
+// moveToSection moves the todo under the cursor, with its children, to the
+// end of the section dir sections away.
+func (m *Model) moveToSection(dir int) {
+	n := m.current()
+	if n == nil || !m.sectioned() || m.focused != nil {
+		return // a focused view keeps moves inside the subtree
+	}
+	target := m.blockOf(n) + dir
+	if target < 0 || target >= len(m.blocks) {
+		return
+	}
+	if parent := m.findParent(n); parent != nil {
+		parser.DeleteNode(parent, m.findChildIdx(parent, n))
+	} else if idx := m.findRootIdx(n); idx >= 0 {
+		m.roots = append(m.roots[:idx], m.roots[idx+1:]...)
+	}
+	n.Parent = nil
+	var setBlock func(t *parser.Todo)
+	setBlock = func(t *parser.Todo) {
+		t.Block = target
+		for _, c := range t.Children {
+			setBlock(c)
+		}
+	}
+	setBlock(n)
+	m.insertRoot(n, target)
+	delete(m.collapsedBlocks, target)
+	m.todos = m.flattenForSync()
+	m.refreshTree()
+	m.save(m.todos)
+	m.cursor = m.flatIndex(n.ID)
+}
+
+// updateNaming handles keys while the name of a new block is typed.
+func (m Model) updateNaming(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.naming = false
+		name := strings.TrimSpace(m.nameBuffer)
+		if err := sync.AppendBlock(m.sync.Path, name); err != nil {
+			m.notices = append(m.notices, "add block: "+err.Error())
+			return m, nil
+		}
+		m.blocks = append(m.blocks, parser.Block{Name: name, Start: -1, End: -1})
+		m.refreshTree()
+		m.cursor = m.headerRow(len(m.blocks) - 1)
+	case tea.KeyEsc:
+		m.naming = false
+	case tea.KeyBackspace, tea.KeyCtrlH:
+		if len(m.nameBuffer) > 0 {
+			m.nameBuffer = m.nameBuffer[:len(m.nameBuffer)-1]
+		}
+	case tea.KeyRunes:
+		m.nameBuffer += msg.String()
+	case tea.KeySpace:
+		m.nameBuffer += " "
+	}
+	return m, nil
+}
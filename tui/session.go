@@ -0,0 +1,96 @@
+package tui
+
+import (
+	"td-file/parser"
+	"td-file/query"
+	"td-file/session"
+)
+
+// sessionState captures what is restored the next time the file is opened.
+func (m *Model) sessionState() session.State {
+	var s session.State
+	if m.filter != nil {
+		s.Filter = m.filterBuffer
+	}
+	var walk func(nodes []*parser.Todo)
+	walk = func(nodes []*parser.Todo) {
+		for _, n := range nodes {
+			if m.collapsed[n.ID] && len(n.Children) > 0 {
+				s.Collapsed = append(s.Collapsed, session.Key(n))
+			}
+			walk(n.Children)
+		}
+	}
+	walk(m.roots)
+	for i, b := range m.blocks {
+		if m.collapsedBlocks[i] {
+			s.Sections = append(s.Sections, session.SectionKey(b, i))
+		}
+	}
+	if row, ok := m.header(); ok {
+		s.Cursor = "section:" + session.SectionKey(m.blocks[row.Block], row.Block)
+	} else if n := m.current(); n != nil {
+		s.Cursor = session.Key(n)
+	}
+	return s
+}
+
+// restoreSession applies a saved state to the freshly loaded model. Keys
+// that no longer match anything are ignored.
+func (m *Model) restoreSession(s session.State) {
+	m.refreshTree()
+	collapsed := set(s.Collapsed)
+	var walk func(nodes []*parser.Todo)
+	walk = func(nodes []*parser.Todo) {
+		for _, n := range nodes {
+			if collapsed[session.Key(n)] {
+				m.collapsed[n.ID] = true
+			}
+			walk(n.Children)
+		}
+	}
+	walk(m.roots)
+	sections := set(s.Sections)
+	for i, b := range m.blocks {
+		if sections[session.SectionKey(b, i)] {
+			if m.collapsedBlocks == nil {
+				m.collapsedBlocks = make(map[int]bool)
+			}
+			m.collapsedBlocks[i] = true
+		}
+	}
+	if s.Filter != "" {
+		if q, err := query.Parse(s.Filter); err == nil {
+			m.filter, m.filterBuffer = q, s.Filter
+		}
+	}
+	m.refreshTree()
+	for i, row := range m.flat {
+		key := ""
+		if row.Header {
+			key = "section:" + session.SectionKey(m.blocks[row.Block], row.Block)
+		} else {
+			key = session.Key(row.Todo)
+		}
+		if key == s.Cursor {
+			m.cursor = i
+			break
+		}
+	}
+}
+
+// saveSession stores the state of the current file, reporting failures as
+// notices.
+func (m *Model) saveSession() {
+	if err := session.Save(m.sync.Path, m.sessionState()); err != nil {
+		m.notices = append(m.notices, "save session: "+err.Error())
+	}
+}
+
+func set(keys []string) map[string]bool {
+	out := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		out[k] = true
+	}
+	return out
+}
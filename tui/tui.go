@@ -2,9 +2,15 @@ package tui
 
 import (
 	"fmt"
+	"os"
 	"strings"
 
+	"td-file/agenda"
+	"td-file/config"
+	"td-file/hooks"
 	"td-file/parser"
+	"td-file/query"
+	"td-file/session"
 	"td-file/sync"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -13,9 +19,18 @@ import (
 
 type reloadMsg struct{}
 
+// syncErrMsg reports a background failure from the synchronizer, such as a
+// save that could not be written or a git conflict.
+type syncErrMsg struct{ err error }
+
 type TreeNodeView struct {
 	Todo  *parser.Todo
 	Depth int
+	// Header rows stand for a whole :td block and have no Todo.
+	Header bool
+	Block  int
+	// Hidden is the number of the todo's children left out by hiding.
+	Hidden int
 }
 
 type Model struct {
@@ -27,17 +42,67 @@ type Model struct {
 	editBuffer string
 	sync       *sync.FileSynchronizer
 	warnings   []string
+	notices    []string // background errors; unlike warnings they survive reloads until esc
 	errMsg     string
 	help       bool
 	collapsed  map[int]bool
 	nextID     int
+
+	blocks          []parser.Block
+	collapsedBlocks map[int]bool
+	naming          bool // typing the name of a new block
+	nameBuffer      string
+	foldPending     bool // z was pressed; the next key is a fold command
+
+	focusID       int          // ID of the focused todo, if any
+	focusKey      string       // its session.Key, to find it after a reload
+	focusReloaded bool         // IDs were renumbered since focusID was set
+	focused       *parser.Todo // resolved by refreshTree
+
+	hideStates  map[parser.TodoState]bool // what HH hides, from hide_states
+	hidden      map[parser.TodoState]bool // states hidden now
+	hidePending bool                      // H was pressed; the next key picks states
+	hiddenTop   int                       // hidden todos at the top of the view
+
+	progress  config.ProgressConfig
+	cancelled parser.CancelledMode // how cancelled todos count, from progress.cancelled
+	confirm   *pendingState        // a state change waiting for y/n
+	cascade   parser.Cascade
+
+	filtering    bool
+	filterBuffer string
+	filter       *query.Query
+
+	cfg          *config.Config
+	hooks        *hooks.Runner
+	pluginDir    string
+	pluginKeys   map[string]string // key -> plugin name
+	panel        string            // side panel rendered by the last plugin
+	panelTitle   string
+	home         string // today's file: the target of agenda pulls
+	agendaOpen   bool
+	agendaGroups []agenda.Group
+	agendaCursor int
 }
 
 // Modular lipgloss styles for todo states
 
 func (m *Model) refreshTree() {
 	m.roots = buildTreeWithCollapse(m.todos, m.collapsed)
-	m.flat = flattenTree(m.roots, 0)
+	view := m.viewRoots()
+	switch {
+	case m.filter != nil:
+		env := query.Env{}
+		if m.sync != nil {
+			env.FileDate, _ = config.FileDate(m.cfg, m.sync.Path)
+		}
+		m.flat = filterTree(view, m.filter, env, 0)
+	case m.focused == nil && m.sectioned():
+		m.flat = m.sectionRows()
+	default:
+		m.flat = flattenTree(view, 0)
+	}
+	m.flat = m.hideRows(m.flat)
 	if m.cursor >= len(m.flat) {
 		m.cursor = len(m.flat) - 1
 	}
@@ -55,16 +120,21 @@ func (m Model) Init() tea.Cmd {
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case reloadMsg:
-		blocks, warnings, err := parser.ExtractTdBlocksWithWarnings(m.sync.Path)
-		if err != nil {
-			m.errMsg = err.Error()
-			return m, nil
+		m.reload()
+		if m.agendaOpen {
+			m.loadAgenda()
+		}
+		return m, nil
+	case syncErrMsg:
+		m.notices = append(m.notices, msg.err.Error())
+		return m, nil
+	case pluginMsg:
+		m.handlePlugin(msg)
+		return m, nil
+	case controlMsg:
+		if msg.take() {
+			msg.reply <- m.handleControl(msg.req)
 		}
-		todos, warn2 := parser.ParseTodosWithWarnings(blocks)
-		m.todos = todos
-		m.warnings = append(warnings, warn2...)
-		m.errMsg = ""
-		m.refreshTree()
 		return m, nil
 	case tea.KeyMsg:
 		if m.errMsg != "" {
@@ -80,11 +150,52 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.help = true
 			return m, nil
 		}
+		if m.agendaOpen {
+			return m.updateAgenda(msg)
+		}
+		if m.naming {
+			return m.updateNaming(msg)
+		}
+		if m.filtering {
+			switch msg.Type {
+			case tea.KeyEnter:
+				q, err := query.Parse(m.filterBuffer)
+				if err != nil {
+					m.warnings = []string{err.Error()}
+					return m, nil
+				}
+				m.warnings = nil
+				m.filter = q
+				if strings.TrimSpace(m.filterBuffer) == "" {
+					m.filter = nil
+				}
+				m.filtering = false
+				m.cursor = 0
+				m.refreshTree()
+				return m, nil
+			case tea.KeyEsc:
+				m.filtering = false
+				m.filterBuffer = ""
+				return m, nil
+			case tea.KeyBackspace, tea.KeyCtrlH:
+				if len(m.filterBuffer) > 0 {
+					m.filterBuffer = m.filterBuffer[:len(m.filterBuffer)-1]
+				}
+				return m, nil
+			case tea.KeyRunes:
+				m.filterBuffer += msg.String()
+				return m, nil
+			case tea.KeySpace:
+				m.filterBuffer += " "
+				return m, nil
+			default:
+				return m, nil
+			}
+		}
 		if m.editing {
 			switch msg.Type {
 			case tea.KeyEnter:
-				if len(m.flat) > 0 {
-					n := m.flat[m.cursor].Todo
+				if n := m.current(); n != nil {
 					n.Text = m.editBuffer
 					for i := range m.todos {
 						if m.todos[i].ID == n.ID {
@@ -92,7 +203,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 							break
 						}
 					}
-					m.sync.SaveCh <- m.flattenForSync()
+					m.save(m.flattenForSync())
 				}
 				m.editing = false
 				m.editBuffer = ""
@@ -117,9 +228,26 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 		}
+		if m.confirm != nil {
+			return m.updateConfirm(msg)
+		}
+		if m.foldPending {
+			return m.updateFold(msg)
+		}
+		if m.hidePending {
+			return m.updateHide(msg)
+		}
 		switch msg.Type {
 		case tea.KeyCtrlC:
 			return m, tea.Quit
+		case tea.KeyEsc:
+			m.notices = nil
+			m.panel = ""
+			if m.filter != nil {
+				m.filter = nil
+				m.filterBuffer = ""
+				m.refreshTree()
+			}
 		case tea.KeyDown:
 			if m.cursor < len(m.flat)-1 {
 				m.cursor++
@@ -129,8 +257,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.cursor--
 			}
 		case tea.KeyEnter:
-			if len(m.flat) > 0 {
-				n := m.flat[m.cursor].Todo
+			if row, ok := m.header(); ok {
+				m.toggleSection(row.Block)
+			} else if n := m.current(); n != nil {
 				if len(n.Children) > 0 {
 					m.collapsed[n.ID] = !n.Collapsed
 					m.refreshTree()
@@ -149,59 +278,17 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if m.cursor > 0 {
 					m.cursor--
 				}
-			case 'x':
-				if len(m.flat) > 0 {
-					n := m.flat[m.cursor].Todo
-					if n.State == parser.Completed {
-						parser.SetState(n, parser.Incomplete)
-					} else {
-						parser.SetState(n, parser.Completed)
-					}
-					m.sync.SaveCh <- m.flattenForSync()
-					m.todos = m.flattenForSync()
-					m.refreshTree()
-				}
-			case '-':
-				if len(m.flat) > 0 {
-					n := m.flat[m.cursor].Todo
-					if n.State == parser.Cancelled {
-						parser.SetState(n, parser.Incomplete)
-					} else {
-						parser.SetState(n, parser.Cancelled)
-					}
-					m.sync.SaveCh <- m.flattenForSync()
-					m.todos = m.flattenForSync()
-					m.refreshTree()
-				}
-			case '>':
-				if len(m.flat) > 0 {
-					n := m.flat[m.cursor].Todo
-					if n.State == parser.Pushed {
-						parser.SetState(n, parser.Incomplete)
-					} else {
-						parser.SetState(n, parser.Pushed)
-					}
-					m.sync.SaveCh <- m.flattenForSync()
-					m.todos = m.flattenForSync()
-					m.refreshTree()
-				}
-			case ' ':
-				if len(m.flat) > 0 {
-					n := m.flat[m.cursor].Todo
-					parser.SetState(n, parser.Incomplete)
-					m.sync.SaveCh <- m.flattenForSync()
-					m.todos = m.flattenForSync()
-					m.refreshTree()
-				}
 			case 'e':
-				if len(m.flat) > 0 {
+				if n := m.current(); n != nil {
 					m.editing = true
-					m.editBuffer = m.flat[m.cursor].Todo.Text
+					m.editBuffer = n.Text
 				}
 			case 'a':
-				if len(m.flat) > 0 {
+				if row, ok := m.header(); ok {
+					m.addToBlock(row.Block)
+				} else if m.current() != nil {
 					flat := m.flattenForSync()
-					curIdx := m.cursor
+					curIdx := m.syncIndex(m.flat[m.cursor].Todo)
 					curIndent := flat[curIdx].IndentLevel
 					lastDescendantIdx := curIdx
 					for i := curIdx + 1; i < len(flat); i++ {
@@ -215,34 +302,41 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						Text:        "New todo",
 						State:       parser.Incomplete,
 						IndentLevel: curIndent,
+						Block:       flat[curIdx].Block,
+						Marker:      flat[curIdx].Marker,
+						Indent:      flat[curIdx].Indent,
 					}
 					m.nextID++
 					// Insert after last descendant
 					flat = append(flat[:lastDescendantIdx+1], append([]parser.Todo{newTodo}, flat[lastDescendantIdx+1:]...)...)
 					m.todos = flat
 					m.refreshTree()
-					m.sync.SaveCh <- m.todos
-					m.cursor = lastDescendantIdx + 1
-				} else {
+					m.save(m.todos)
+					m.cursor = m.flatIndex(newTodo.ID)
+					m.hooks.Fire(hooks.Add, m.sync.Path, &newTodo)
+				} else if m.focused != nil {
+					m.addUnderFocus()
+				} else if m.filter == nil {
 					m.todos = []parser.Todo{{ID: m.nextID, Text: "New todo", State: parser.Incomplete}}
 					m.nextID++
 					m.refreshTree()
-					m.sync.SaveCh <- m.todos
+					m.save(m.todos)
 					m.cursor = 0
+					m.hooks.Fire(hooks.Add, m.sync.Path, &m.todos[0])
 				}
 			case 'A':
-				if len(m.flat) > 0 {
-					cur := m.flat[m.cursor]
-					parent := cur.Todo
+				if parent := m.current(); parent != nil {
 					newChild := &parser.Todo{
-						ID:          m.nextID,
-						Text:        "New child todo",
-						State:       parser.Incomplete,
-						IndentLevel: parent.IndentLevel + 2,
-						Parent:      parent,
+						ID:     m.nextID,
+						Text:   "New child todo",
+						State:  parser.Incomplete,
+						Block:  parent.Block,
+						Parent: parent,
 					}
+					parser.Nest(parent, newChild)
 					m.nextID++
 					parser.AddChild(parent, newChild)
+					m.hooks.Fire(hooks.Add, m.sync.Path, newChild)
 					m.todos = m.flattenForSync()
 					m.refreshTree()
 					for i, node := range m.flat {
@@ -251,62 +345,131 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 							break
 						}
 					}
-					m.sync.SaveCh <- m.todos
+					m.save(m.todos)
 				}
 			case 'd':
-				if len(m.flat) > 0 {
+				if m.current() != nil {
 					cur := m.flat[m.cursor]
-					delete(m.collapsed, cur.Todo.ID)
-					if cur.Depth == 0 {
-						idx := m.findRootIdx(cur.Todo)
-						if idx >= 0 {
-							m.roots = append(m.roots[:idx], m.roots[idx+1:]...)
-						}
-					} else {
-						parent := m.findParent(cur.Todo)
-						if parent != nil {
-							idx := m.findChildIdx(parent, cur.Todo)
-							if idx >= 0 {
-								parser.DeleteNode(parent, idx)
-							}
-						}
-					}
+					m.hooks.Fire(hooks.Delete, m.sync.Path, cur.Todo)
+					m.remove(cur.Todo)
 					m.todos = m.flattenForSync()
 					m.refreshTree()
-					m.sync.SaveCh <- m.todos
+					m.save(m.todos)
 					if m.cursor >= len(m.flat) && m.cursor > 0 {
 						m.cursor--
 					}
 				}
+			case 'X':
+				m.archiveCurrent()
 			case '*':
-				if len(m.flat) > 0 {
-					n := m.flat[m.cursor].Todo
+				if n := m.current(); n != nil {
 					if n.State == parser.Incomplete {
 						parser.SetHighlight(n, !n.Highlighted)
-						m.sync.SaveCh <- m.flattenForSync()
+						m.save(m.flattenForSync())
 						m.todos = m.flattenForSync()
 						m.refreshTree()
 					}
 				}
+			case 'z':
+				m.foldPending = true
+			case 'H':
+				m.hidePending = true
+			case 'f':
+				if n := m.current(); n != nil {
+					m.focusOn(n)
+				}
+			case 'F':
+				m.zoomOut()
+			case ']':
+				m.jumpSection(1)
+			case '[':
+				m.jumpSection(-1)
+			case 'm':
+				m.moveToSection(1)
+			case 'M':
+				m.moveToSection(-1)
+			case 'B':
+				m.naming = true
+				m.nameBuffer = ""
+			case 'g':
+				m.openAgenda()
+			case '/':
+				m.filtering = true
 			case '?':
 				m.help = true
+			default:
+				return m, m.unboundKey(msg.String())
 			}
+		default:
+			return m, m.unboundKey(msg.String())
 		}
 		return m, nil
 	}
 	return m, nil
 }
 
+// unboundKey runs the plugin or toggles the state bound to key. It is only
+// consulted for keys without a built-in binding, so a plugin or state bound
+// to j or q cannot take over those commands.
+func (m *Model) unboundKey(key string) tea.Cmd {
+	if name, ok := m.pluginKeys[key]; ok {
+		return m.runPlugin(name)
+	}
+	if state, ok := parser.StateForKey(key); ok {
+		if n := m.current(); n != nil {
+			m.toggleState(n, state)
+		}
+	}
+	return nil
+}
+
+// toggleState puts n into state, or back to incomplete if it is already
+// there.
+func (m *Model) toggleState(n *parser.Todo, state parser.TodoState) {
+	if n.State == state {
+		state = parser.Incomplete
+	}
+	if state == parser.Completed && m.progress.ConfirmParent {
+		if p := parser.CountProgress(n.Children, m.cancelled); p.Done < p.Total {
+			m.confirm = &pendingState{todo: n, state: state, open: p.Total - p.Done}
+			return
+		}
+	}
+	m.setState(n, state)
+}
+
+// setState puts n into state, applies the cascade rules and, with
+// progress.auto_complete, completes the ancestors it was the last open todo
+// of.
+func (m *Model) setState(n *parser.Todo, state parser.TodoState) {
+	changes := parser.SetStateCascade(n, state, m.cascade)
+	if m.progress.AutoComplete && n.State.Closed() {
+		changes = append(changes, parser.CompleteAncestors(n, m.cancelled)...)
+	}
+	for _, c := range changes {
+		m.hooks.StateChanged(m.sync.Path, c.Todo, c.Prev)
+	}
+	m.save(m.flattenForSync())
+	m.todos = m.flattenForSync()
+	m.refreshTree()
+}
+
 func (m Model) View() string {
 	if m.help {
 		return helpScreen()
 	}
+	if m.agendaOpen {
+		return m.agendaView()
+	}
 	var b strings.Builder
 	if m.errMsg != "" {
 		fmt.Fprintf(&b, "Error: %s\n\n", m.errMsg)
 	}
-	if len(m.warnings) > 0 {
-		for _, w := range m.warnings {
+	if m.home != "" && m.sync != nil && m.sync.Path != m.home {
+		fmt.Fprintf(&b, "Viewing %s (g for agenda)\n", m.sync.Path)
+	}
+	if len(m.warnings)+len(m.notices) > 0 {
+		for _, w := range append(append([]string{}, m.notices...), m.warnings...) {
 			fmt.Fprintf(&b, "Warning: %s\n", w)
 		}
 		b.WriteString("\n")
@@ -319,19 +482,26 @@ func (m Model) View() string {
 	}
 
 	// Create styles with dynamic width
-	incompleteStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("7")).Width(width)
-	completedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("3")).Faint(true).Width(width)
-	pushedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Faint(true).Width(width)
-	cancelledStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Strikethrough(true).Width(width)
 	cursorStyle := lipgloss.NewStyle().Background(lipgloss.Color("7")).Foreground(lipgloss.Color("0")).Width(width)
 	highlightStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("12")).Bold(true).Width(width)
 
 	border := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Render(strings.Repeat("─", 40))
+	if m.focused != nil {
+		b.WriteString(m.breadcrumb())
+	}
 	b.WriteString(border + "\n")
 	if len(m.flat) == 0 {
 		b.WriteString("No todos found.\n")
 	} else {
 		for i, node := range m.flat {
+			if node.Header {
+				line := m.headerLine(node.Block)
+				if i == m.cursor {
+					line = cursorStyle.Render(line)
+				}
+				b.WriteString(line + "\n")
+				continue
+			}
 			indent := strings.Repeat("  ", node.Depth)
 			icon := "  "
 			if len(node.Todo.Children) > 0 {
@@ -342,37 +512,28 @@ func (m Model) View() string {
 				}
 			}
 
-			var (
-				stateIcon string
-				style     lipgloss.Style
-			)
-			switch node.Todo.State {
-			case parser.Completed:
-				stateIcon = "✔"
-				style = completedStyle
-			case parser.Pushed:
-				stateIcon = "➤"
-				style = pushedStyle
-			case parser.Cancelled:
-				stateIcon = "✗"
-				style = cancelledStyle
-			default:
-				stateIcon = "○"
-				if node.Todo.Highlighted {
-					style = highlightStyle
-				} else {
-					style = incompleteStyle
-				}
+			def := node.Todo.State.Def()
+			style := stateStyle(def.Style).Width(width)
+			if node.Todo.Highlighted && node.Todo.State == parser.Incomplete {
+				style = highlightStyle
 			}
 
 			text := node.Todo.Text
 			if node.Todo.Highlighted {
 				text = text + " *"
 			}
+			if len(node.Todo.Children) > 0 {
+				if label := m.progressLabel(node.Todo); label != "" {
+					text += " " + label
+				}
+			}
+			if node.Hidden > 0 {
+				text += fmt.Sprintf("  +%d hidden", node.Hidden)
+			}
 			if m.editing && i == m.cursor {
 				text = m.editBuffer + "|"
 			}
-			line := fmt.Sprintf("%s%s%s %s", indent, icon, stateIcon, text)
+			line := fmt.Sprintf("%s%s%s %s", indent, icon, stateIcon(node.Todo), text)
 			line = style.Render(line)
 			if i == m.cursor {
 				line = cursorStyle.Render(line)
@@ -380,29 +541,93 @@ func (m Model) View() string {
 			b.WriteString(line + "\n")
 		}
 	}
-	if m.editing {
+	b.WriteString(m.progressLine())
+	if len(m.hidden) > 0 {
+		b.WriteString(m.hiddenLine())
+	}
+	if m.confirm != nil {
+		b.WriteString(m.confirmLine())
+	} else if m.filtering {
+		b.WriteString("\nFilter: " + m.filterBuffer + "|\n")
+	} else if m.editing {
 		b.WriteString("\nEditing: type to edit, enter to save, esc to cancel\n")
+	} else if m.naming {
+		b.WriteString("\nNew block name: " + m.nameBuffer + "|\n")
+	} else if m.filter != nil {
+		b.WriteString("\nFilter: " + m.filter.String() + " (esc to clear, '?' for help)\n")
 	} else {
 		b.WriteString("\nPress '?' for help\n")
 	}
+	if m.panel != "" {
+		return lipgloss.JoinHorizontal(lipgloss.Top, b.String(), m.panelView())
+	}
 	return b.String()
 }
 
+// stateStyle turns a state's configured style into a lipgloss style.
+// stateIcon is the registry icon for t's state, or its box as written for
+// an unknown marker.
+func stateIcon(t *parser.Todo) string {
+	if t.State == parser.Unknown && t.Box != "" {
+		return "[" + t.Box + "]"
+	}
+	return t.State.Def().Icon
+}
+
+func stateStyle(s parser.Style) lipgloss.Style {
+	style := lipgloss.NewStyle().Bold(s.Bold).Faint(s.Faint).Strikethrough(s.Strikethrough)
+	if s.Color != "" {
+		style = style.Foreground(lipgloss.Color(s.Color))
+	}
+	return style
+}
+
+// stateHelp lists the state keys for the help screen.
+func stateHelp() []string {
+	var rows []string
+	for _, def := range parser.States() {
+		if def.Key == "" {
+			continue
+		}
+		key := def.Key
+		if key == " " {
+			key = "␣"
+		}
+		rows = append(rows, fmt.Sprintf("%-16sToggle %s %s [%s]", key, def.Icon, strings.ReplaceAll(def.Name, "_", " "), def.Marker))
+	}
+	return rows
+}
+
 func helpScreen() string {
 	header := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("4")).Render("Todo TUI - Help")
 	sep := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Render(strings.Repeat("─", 40))
 	rows := []string{
 		"j / k / ↑ / ↓   Move cursor up/down",
-		"enter           Collapse/expand tree node",
-		"x / - / > / ␣   Complete, cancel, push, uncomplete",
+		"enter           Collapse/expand tree node or section",
+		"zM / zR         Collapse/expand everything",
+		"zc / zo         Collapse/expand the current subtree",
+		"z1 … z9         Show that many levels",
+		"f / F           Focus on the current todo / zoom out",
+		"X               Archive the finished todos in the current subtree",
+		"HH              Hide/show finished todos (hide_states)",
+		"H + state key   Hide/show todos in that state",
+		"] / [           Next/previous section",
+		"m / M           Move todo to the next/previous section",
+		"B               Add a new :td block",
+	}
+	rows = append(rows, stateHelp()...)
+	rows = append(rows,
 		"*               Toggle highlight (incomplete only)",
 		"e               Edit todo text",
 		"a               Add sibling todo",
 		"A               Add child todo",
 		"d               Delete todo",
+		"g               Agenda across all daily files",
+		"/               Filter todos (e.g. state:incomplete tag:work)",
+		"esc             Clear filter, dismiss warnings and plugin panel",
 		"q / ctrl+c      Quit",
 		"? / esc         Toggle help screen",
-	}
+	)
 	rowStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("7"))
 	var body strings.Builder
 	for _, row := range rows {
@@ -423,13 +648,20 @@ func buildTreeWithCollapse(flat []parser.Todo, collapsed map[int]bool) []*parser
 			State:       flat[i].State,
 			IndentLevel: flat[i].IndentLevel,
 			LineNumber:  flat[i].LineNumber,
+			Block:       flat[i].Block,
 			Collapsed:   collapsed[flat[i].ID],
 			Highlighted: flat[i].Highlighted,
+			Marker:      flat[i].Marker,
+			Indent:      flat[i].Indent,
+			Box:         flat[i].Box,
 		}
 	}
 	var roots []*parser.Todo
 	var stack []*parser.Todo
 	for _, t := range treeNodes {
+		if len(stack) > 0 && stack[0].Block != t.Block {
+			stack = nil // todos never nest across blocks
+		}
 		for len(stack) > 0 && t.IndentLevel <= stack[len(stack)-1].IndentLevel {
 			stack = stack[:len(stack)-1]
 		}
@@ -459,26 +691,85 @@ func flattenTree(nodes []*parser.Todo, depth int) []TreeNodeView {
 	return out
 }
 
+// filterTree flattens the full tree (ignoring collapse) keeping only nodes that
+// match q and the ancestors needed to show them in context.
+func filterTree(nodes []*parser.Todo, q *query.Query, env query.Env, depth int) []TreeNodeView {
+	var out []TreeNodeView
+	for _, n := range nodes {
+		children := filterTree(n.Children, q, env, depth+1)
+		if len(children) > 0 || q.Match(n, env) {
+			out = append(out, TreeNodeView{Todo: n, Depth: depth})
+			out = append(out, children...)
+		}
+	}
+	return out
+}
+
+// syncIndex returns the position of node in flattenForSync order.
+func (m *Model) syncIndex(node *parser.Todo) int {
+	idx, found := 0, false
+	var walk func(nodes []*parser.Todo)
+	walk = func(nodes []*parser.Todo) {
+		for _, n := range nodes {
+			if found {
+				return
+			}
+			if n == node {
+				found = true
+				return
+			}
+			idx++
+			walk(n.Children)
+		}
+	}
+	walk(m.roots)
+	return idx
+}
+
+// flatIndex returns the cursor position of the todo with the given ID, or the
+// current cursor if it is not visible.
+func (m *Model) flatIndex(id int) int {
+	for i, node := range m.flat {
+		if node.Todo != nil && node.Todo.ID == id {
+			return i
+		}
+	}
+	return m.cursor
+}
+
 // flattenForSync flattens the tree to a []parser.Todo for file writing
 func (m *Model) flattenForSync() []parser.Todo {
 	var out []parser.Todo
-	var walk func(nodes []*parser.Todo, indent int)
-	walk = func(nodes []*parser.Todo, indent int) {
+	var walk func(nodes []*parser.Todo, parent *parser.Todo, indent int)
+	walk = func(nodes []*parser.Todo, parent *parser.Todo, indent int) {
 		for _, n := range nodes {
 			t := *n
-			t.IndentLevel = indent
+			t.IndentLevel = parser.ChildIndent(parent, n, indent)
 			t.Children = nil
 			out = append(out, t)
 			if len(n.Children) > 0 {
 				children := n.Children
-				walk(children, indent+2)
+				walk(children, n, t.IndentLevel)
 			}
 		}
 	}
-	walk(m.roots, 0)
+	walk(m.roots, nil, 0)
 	return out
 }
 
+// remove takes n and its subtree out of the tree. Callers re-flatten and
+// save.
+func (m *Model) remove(n *parser.Todo) {
+	delete(m.collapsed, n.ID)
+	if parent := m.findParent(n); parent != nil {
+		if idx := m.findChildIdx(parent, n); idx >= 0 {
+			parser.DeleteNode(parent, idx)
+		}
+	} else if idx := m.findRootIdx(n); idx >= 0 {
+		m.roots = append(m.roots[:idx], m.roots[idx+1:]...)
+	}
+}
+
 func (m *Model) findParent(child *parser.Todo) *parser.Todo {
 	var parent *parser.Todo
 	var walk func(nodes []*parser.Todo)
@@ -516,16 +807,107 @@ func (m *Model) findRootIdx(node *parser.Todo) int {
 	return -1
 }
 
-// StartTUI launches the Bubbletea program with the given model and synchronizer
-func StartTUI(todos []parser.Todo, sync *sync.FileSynchronizer, maxID int) error {
-	mdl := Model{todos: todos, sync: sync, collapsed: make(map[int]bool), nextID: maxID + 1}
-	mdl.refreshTree()
-	p := tea.NewProgram(mdl)
+// save queues todos for writing and fires the on_save hook.
+func (m *Model) save(todos []parser.Todo) {
+	m.sync.SaveCh <- todos
+	m.hooks.Fire(hooks.Save, m.sync.Path, nil)
+}
+
+// reload re-reads the synchronizer's file into the model.
+func (m *Model) reload() {
+	blocks, warnings, err := parser.ExtractBlocks(m.sync.Path)
+	if err != nil {
+		m.errMsg = err.Error()
+		return
+	}
+	todos, warn2 := parser.ParseTodosWithWarnings(parser.BlockLines(blocks))
+	m.todos = todos
+	// IDs are line numbers: new todos must number above this file's,
+	// which may be longer than the one nextID was set for.
+	for _, t := range todos {
+		if t.ID >= m.nextID {
+			m.nextID = t.ID + 1
+		}
+	}
+	m.confirm = nil // its todo is gone from the rebuilt tree
+	m.focusReloaded = true
+	m.blocks = blocks
+	m.warnings = append(warnings, warn2...)
+	m.errMsg = ""
+	m.refreshTree()
+}
+
+// StartTUI launches the Bubbletea program with the given model and synchronizer.
+// cfg is used to locate the daily archive for the agenda screen and may be nil.
+func StartTUI(todos []parser.Todo, sync *sync.FileSynchronizer, maxID int, cfg *config.Config) error {
+	mdl := Model{todos: todos, sync: sync, collapsed: make(map[int]bool), nextID: maxID + 1, cfg: cfg, home: sync.Path}
+	if cfg != nil {
+		mdl.hooks = hooks.New(cfg.Hooks, sync.Report)
+		if cfg.Plugins != nil {
+			mdl.pluginKeys = cfg.Plugins.Keys
+		}
+	}
+	mdl.pluginDir, _ = config.PluginDir(cfg)
+	if cfg != nil {
+		mdl.cascade = cfg.Cascade
+		if cfg.Progress != nil {
+			mdl.progress = *cfg.Progress
+		}
+	}
+	if mode, err := parser.ParseCancelledMode(mdl.progress.Cancelled); err != nil {
+		mdl.notices = append(mdl.notices, err.Error())
+	} else {
+		mdl.cancelled = mode
+	}
+	if hidden, err := cfg.Hidden(); err != nil {
+		mdl.notices = append(mdl.notices, err.Error())
+	} else {
+		mdl.hideStates = hidden
+		if cfg != nil && cfg.HideDone {
+			mdl.hidden = make(map[parser.TodoState]bool)
+			for s := range hidden {
+				mdl.hidden[s] = true
+			}
+		}
+	}
+	mdl.blocks, _, _ = parser.ExtractBlocks(sync.Path)
+	session.Prune()
+	if state, err := session.Load(sync.Path); err != nil {
+		mdl.notices = append(mdl.notices, err.Error())
+		mdl.refreshTree()
+	} else {
+		mdl.restoreSession(state)
+	}
+	// Other td-file processes route their edits through this session while
+	// it runs; see package control.
+	var p *tea.Program
+	ready := make(chan struct{})
+	ln, err := listen(sync.Path, func(msg controlMsg) {
+		<-ready
+		p.Send(msg)
+	})
+	if err != nil {
+		mdl.notices = append(mdl.notices, "control socket: "+err.Error())
+	} else {
+		defer ln.Close()
+	}
+	p = tea.NewProgram(mdl)
+	close(ready)
 	go func() {
 		for range sync.ReloadCh {
 			p.Send(reloadMsg{})
 		}
 	}()
-	_, err := p.Run()
+	go func() {
+		for err := range sync.ErrCh {
+			p.Send(syncErrMsg{err})
+		}
+	}()
+	final, err := p.Run()
+	if m, ok := final.(Model); ok && err == nil {
+		if err := session.Save(m.sync.Path, m.sessionState()); err != nil {
+			fmt.Fprintln(os.Stderr, "td-file: save session:", err)
+		}
+	}
 	return err
 }
@@ -1,10 +1,18 @@
 package tui
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"td-file/config"
+	"td-file/control"
+	"td-file/hooks"
 	"td-file/parser"
+	"td-file/query"
+	"td-file/session"
 	"td-file/sync"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -263,3 +271,764 @@ func TestRegression_AddChildOnlyAffectsParent(t *testing.T) {
 		t.Fatalf("expected B to remain as root, got %+v", m2.roots[1])
 	}
 }
+
+func TestModel_Filter(t *testing.T) {
+	flat := []parser.Todo{
+		{ID: 1, Text: "Release", IndentLevel: 0},
+		{ID: 2, Text: "Deploy #work", IndentLevel: 2},
+		{ID: 3, Text: "Docs", IndentLevel: 2},
+		{ID: 4, Text: "Groceries", IndentLevel: 0},
+	}
+	m := Model{todos: flat, collapsed: make(map[int]bool), nextID: 5}
+	m.refreshTree()
+	model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'/'}})
+	m = model.(Model)
+	if !m.filtering {
+		t.Fatal("expected filter prompt after '/'")
+	}
+	for _, r := range "tag:work" {
+		model, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		m = model.(Model)
+	}
+	model, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = model.(Model)
+	if len(m.flat) != 2 || m.flat[0].Todo.Text != "Release" || m.flat[1].Todo.Text != "Deploy #work" {
+		t.Fatalf("expected match with its ancestor, got %+v", m.flat)
+	}
+	if !strings.Contains(m.View(), "Filter: tag:work") {
+		t.Errorf("expected active filter in view")
+	}
+	model, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = model.(Model)
+	if m.filter != nil || len(m.flat) != 4 {
+		t.Errorf("expected esc to clear the filter, got %d rows", len(m.flat))
+	}
+}
+
+func TestModel_FilterFileDate(t *testing.T) {
+	cfg := &config.Config{FilePattern: "todos-{YYYY-MM-DD}.md"}
+	fs := &sync.FileSynchronizer{Path: "/notes/todos-2024-06-01.md"}
+	m := Model{todos: []parser.Todo{{ID: 1, Text: "Old"}}, sync: fs, cfg: cfg, collapsed: make(map[int]bool)}
+	for src, want := range map[string]int{"date:2024-06-01": 1, "date:2024-06-02": 0} {
+		q, err := query.Parse(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.filter = q
+		m.refreshTree()
+		if len(m.flat) != want {
+			t.Errorf("%s matched %d rows, want %d", src, len(m.flat), want)
+		}
+	}
+}
+
+func TestModel_AgendaEditsCurrentFileThroughModel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todos.md")
+	os.WriteFile(path, []byte(":td\n- [ ] Task\n- [/] Doing\n:td\n"), 0644)
+	fs := &sync.FileSynchronizer{Path: path, ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+	m := Model{collapsed: make(map[int]bool), sync: fs, nextID: 3}
+	m.reload()
+	for _, r := range "gx" {
+		model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		m = model.(Model)
+	}
+	if m.todos[0].State != parser.Completed {
+		t.Errorf("the model should hold the completed todo, got %v", m.todos[0].State)
+	}
+	if view := m.View(); !strings.Contains(view, "◐ Doing") {
+		t.Errorf("agenda should use the registry icon for in-progress todos:\n%s", view)
+	}
+	if len(fs.SaveCh) != 1 {
+		t.Fatalf("expected one save through the model, got %d", len(fs.SaveCh))
+	}
+	if got, _ := os.ReadFile(path); string(got) != ":td\n- [ ] Task\n- [/] Doing\n:td\n" {
+		t.Errorf("the agenda should not write the current file behind the model's back:\n%s", got)
+	}
+}
+
+func TestModel_AgendaJumpAcrossFiles(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	dir := t.TempDir()
+	cfg := &config.Config{BaseDir: dir, FilePattern: "todos-{YYYY-MM-DD}.md"}
+	oldPath := dir + "/todos-2024-06-01.md"
+	todayPath := dir + "/todos-2024-06-02.md"
+	if err := os.WriteFile(oldPath, []byte(":td\n- [x] Parent\n  - [ ] Leftover\n:td\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.WriteFile(todayPath, []byte(":td\n- [x] Done today\n:td\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	fs := &sync.FileSynchronizer{Path: todayPath, ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+	m := Model{collapsed: make(map[int]bool), sync: fs, cfg: cfg, home: todayPath, nextID: 2}
+	m.reload()
+
+	model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'g'}})
+	m = model.(Model)
+	if !m.agendaOpen || !strings.Contains(m.View(), "Leftover") {
+		t.Fatalf("expected agenda with leftover item, got:\n%s", m.View())
+	}
+	model, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = model.(Model)
+	if m.agendaOpen || m.sync.Path != oldPath {
+		t.Fatalf("expected to jump to %s, now at %s", oldPath, m.sync.Path)
+	}
+	if m.flat[m.cursor].Todo.Text != "Leftover" {
+		t.Errorf("expected cursor on Leftover, got %q", m.flat[m.cursor].Todo.Text)
+	}
+	model, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'a'}})
+	m = model.(Model)
+	ids := map[int]bool{}
+	for _, td := range m.todos {
+		if ids[td.ID] {
+			t.Fatalf("todo added after the jump reuses ID %d", td.ID)
+		}
+		ids[td.ID] = true
+	}
+}
+
+func TestModel_ControlRequest(t *testing.T) {
+	path := t.TempDir() + "/todos.md"
+	fs := &sync.FileSynchronizer{Path: path, ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+	todos := parser.ParseTodos([][]string{{"- [ ] A", "- [ ] B"}})
+	m := Model{todos: todos, sync: fs, collapsed: make(map[int]bool), nextID: 3}
+	m.refreshTree()
+
+	add := control.Request{Op: control.OpAdd, Path: path, Parent: 1, Todos: []parser.Todo{{Text: "Child"}}}
+	msg := newControlMsg(add)
+	model, _ := m.Update(msg)
+	m = model.(Model)
+	if resp := <-msg.reply; !resp.Handled || resp.Error != "" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if len(m.flat) != 3 || m.flat[1].Todo.Text != "Child" || m.flat[1].Depth != 1 || m.flat[1].Todo.ID != 3 {
+		t.Fatalf("expected child added under A, got %+v", m.flat)
+	}
+	if saved := <-fs.SaveCh; len(saved) != 3 {
+		t.Errorf("expected one save with 3 todos, got %d", len(saved))
+	}
+
+	other := add
+	other.Path = t.TempDir() + "/other.md"
+	msg = newControlMsg(other)
+	model, _ = m.Update(msg)
+	m = model.(Model)
+	if resp := <-msg.reply; resp.Handled || len(m.flat) != 3 {
+		t.Errorf("expected request for another file to be declined, got %+v", resp)
+	}
+
+	// A request the socket handler gave up on is never applied late.
+	msg = newControlMsg(add)
+	msg.take()
+	model, _ = m.Update(msg)
+	m = model.(Model)
+	if len(m.flat) != 3 || len(msg.reply) != 0 {
+		t.Errorf("a timed-out request was applied: %+v", m.flat)
+	}
+}
+
+func TestModel_Hooks(t *testing.T) {
+	dir := t.TempDir()
+	fs := &sync.FileSynchronizer{Path: dir + "/todos.md", ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+	m := Model{todos: parser.ParseTodos([][]string{{"- [ ] A"}}), sync: fs, collapsed: make(map[int]bool), nextID: 2}
+	var failures []string
+	m.hooks = hooks.New(&config.HooksConfig{
+		OnComplete: `echo "$TD_FILE_TEXT" >> ` + dir + `/completed`,
+		OnDelete:   "exit 1",
+	}, func(err error) { failures = append(failures, err.Error()) })
+	m.refreshTree()
+
+	model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'x'}})
+	m = model.(Model)
+	model, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'d'}})
+	m = model.(Model)
+	m.hooks.Wait()
+
+	if got, _ := os.ReadFile(dir + "/completed"); string(got) != "A\n" {
+		t.Errorf("on_complete log = %q", got)
+	}
+	if len(failures) != 1 || !strings.Contains(failures[0], "on_delete") {
+		t.Errorf("expected on_delete failure to be reported, got %v", failures)
+	}
+}
+
+func TestModel_AddHookWithFilter(t *testing.T) {
+	dir := t.TempDir()
+	fs := &sync.FileSynchronizer{Path: dir + "/todos.md", ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+	m := Model{todos: parser.ParseTodos([][]string{{"- [ ] alpha", "- [ ] beta"}}), sync: fs, collapsed: make(map[int]bool), nextID: 3}
+	m.hooks = hooks.New(&config.HooksConfig{OnAdd: `echo "$TD_FILE_TEXT" >> ` + dir + `/added`}, nil)
+	q, _ := query.Parse("alpha")
+	m.filter = q
+	m.refreshTree()
+
+	model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'a'}})
+	m = model.(Model)
+	m.hooks.Wait()
+	if got, _ := os.ReadFile(dir + "/added"); string(got) != "New todo\n" {
+		t.Errorf("on_add got %q, want the new todo even when the filter hides it", got)
+	}
+}
+
+func TestModel_PluginKey(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncat > " + dir + "/request.json\n" +
+		`echo '{"mutations":[{"op":"set_state","line":1,"state":"completed"}],"panel":"1 of 1 done"}'` + "\n"
+	if err := os.WriteFile(dir+"/finish", []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fs := &sync.FileSynchronizer{Path: dir + "/todos.md", ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+	m := Model{
+		todos:      parser.ParseTodos([][]string{{"- [ ] A"}}),
+		sync:       fs,
+		collapsed:  make(map[int]bool),
+		nextID:     2,
+		pluginDir:  dir,
+		pluginKeys: map[string]string{"S": "finish", "j": "finish"},
+	}
+	m.refreshTree()
+
+	model, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'j'}})
+	m = model.(Model)
+	if cmd != nil {
+		t.Fatal("a plugin bound to j must not take over the built-in command")
+	}
+	model, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'S'}})
+	m = model.(Model)
+	if cmd == nil {
+		t.Fatal("expected the plugin to run in the background")
+	}
+	model, _ = m.Update(cmd())
+	m = model.(Model)
+
+	if m.todos[0].State != parser.Completed {
+		t.Errorf("mutation not applied: %+v", m.todos[0])
+	}
+	if len(fs.SaveCh) != 1 {
+		t.Errorf("expected the mutation to be saved")
+	}
+	if !strings.Contains(m.View(), "1 of 1 done") {
+		t.Errorf("panel not shown:\n%s", m.View())
+	}
+	if req, _ := os.ReadFile(dir + "/request.json"); !strings.Contains(string(req), `"cursor":1`) {
+		t.Errorf("request should carry the cursor, got %s", req)
+	}
+	model, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	if strings.Contains(model.(Model).View(), "1 of 1 done") {
+		t.Errorf("esc should close the panel")
+	}
+}
+
+func TestModel_CompleteStampsDoneDate(t *testing.T) {
+	if err := parser.Configure(parser.Settings{Syntax: "obsidian", StampDone: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer parser.Configure(parser.Settings{})
+	fs := &sync.FileSynchronizer{Path: "dummy.md", ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+	m := Model{todos: parser.ParseTodos([][]string{{"- [ ] Water plants 🔁 every week"}}), sync: fs, collapsed: make(map[int]bool)}
+	m.refreshTree()
+
+	model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'x'}})
+	m = model.(Model)
+	want := "Water plants 🔁 every week ✅ " + parser.Now().Format(parser.DateLayout)
+	if saved := <-fs.SaveCh; saved[0].Text != want {
+		t.Errorf("saved %q, want %q", saved[0].Text, want)
+	}
+	m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'x'}})
+	if saved := <-fs.SaveCh; saved[0].Text != "Water plants 🔁 every week" {
+		t.Errorf("reopening should drop the date, saved %q", saved[0].Text)
+	}
+}
+
+func TestModel_AddKeepsListStyle(t *testing.T) {
+	for _, tc := range []struct {
+		key    rune
+		cursor int
+		want   string
+	}{
+		{'A', 0, "* [ ] Trip\n\t1. [ ] Pack\n\t1. [ ] New child todo"},
+		{'a', 1, "* [ ] Trip\n\t1. [ ] Pack\n\t1. [ ] New todo"},
+	} {
+		fs := &sync.FileSynchronizer{Path: "dummy.md", ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+		m := Model{todos: parser.ParseTodos([][]string{{"* [ ] Trip", "\t1. [ ] Pack"}}), sync: fs, collapsed: make(map[int]bool), nextID: 10}
+		m.refreshTree()
+		m.cursor = tc.cursor
+		m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{tc.key}})
+		var lines []string
+		for _, todo := range <-fs.SaveCh {
+			lines = append(lines, parser.FormatTodo(todo))
+		}
+		if got := strings.Join(lines, "\n"); got != tc.want {
+			t.Errorf("%c saved:\n%s", tc.key, got)
+		}
+	}
+}
+
+func TestModel_StateRegistry(t *testing.T) {
+	if err := parser.Configure(parser.Settings{States: []parser.StateDef{{Name: "blocked", Marker: "b", Icon: "⛔", Key: "b"}, {Name: "jammed", Marker: "J", Key: "j"}}}); err != nil {
+		t.Fatal(err)
+	}
+	defer parser.Configure(parser.Settings{})
+	fs := &sync.FileSynchronizer{Path: "dummy.md", ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+	m := Model{todos: parser.ParseTodos([][]string{{"- [ ] Deploy", "- [z] Odd one"}}), sync: fs, collapsed: make(map[int]bool)}
+	m.refreshTree()
+
+	if view := m.View(); !strings.Contains(view, "[z] Odd one") {
+		t.Errorf("unknown marker should be shown as written:\n%s", view)
+	}
+	model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'b'}})
+	m = model.(Model)
+	saved := <-fs.SaveCh
+	if got := parser.FormatTodo(saved[0]); got != "- [b] Deploy" {
+		t.Errorf("saved %q", got)
+	}
+	if got := parser.FormatTodo(saved[1]); got != "- [z] Odd one" {
+		t.Errorf("unknown marker lost: %q", got)
+	}
+	if view := m.View(); !strings.Contains(view, "⛔ Deploy") {
+		t.Errorf("custom icon missing:\n%s", view)
+	}
+	// A state bound to a command key does not take the key over.
+	model, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'j'}})
+	m = model.(Model)
+	if m.cursor != 1 || len(fs.SaveCh) != 0 {
+		t.Errorf("j should still move down, cursor %d, %d saves", m.cursor, len(fs.SaveCh))
+	}
+	model, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'?'}})
+	if help := model.(Model).View(); !strings.Contains(help, "Toggle ⛔ blocked [b]") || !strings.Contains(help, "Toggle ◐ in progress [/]") {
+		t.Errorf("help should list the state keys:\n%s", help)
+	}
+}
+
+func TestModel_Sections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todos.md")
+	os.WriteFile(path, []byte(":td Work\n- [ ] Report\n  - [ ] Charts\n:td\n\n:td Home\n- [ ] Laundry\n:td\n"), 0644)
+	fs := &sync.FileSynchronizer{Path: path, ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+	m := Model{sync: fs, collapsed: make(map[int]bool), nextID: 100}
+	m.reload()
+	key := func(r rune) {
+		model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		m = model.(Model)
+	}
+
+	view := m.View()
+	if !strings.Contains(view, "▾ Work (2)") || !strings.Contains(view, "▾ Home (1)") {
+		t.Fatalf("section headers missing:\n%s", view)
+	}
+	key(']')
+	if row, ok := m.header(); !ok || row.Block != 1 {
+		t.Fatalf("] should jump to the Home header, cursor %d", m.cursor)
+	}
+	model, _ := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = model.(Model)
+	if strings.Contains(m.View(), "Laundry") || !strings.Contains(m.View(), "▸ Home (1)") {
+		t.Errorf("enter on a header should collapse the section:\n%s", m.View())
+	}
+
+	m.cursor = 1 // Report
+	key('m')
+	saved := <-fs.SaveCh
+	var got []string
+	for _, todo := range saved {
+		got = append(got, fmt.Sprintf("%d:%s", todo.Block, parser.FormatTodo(todo)))
+	}
+	if want := "1:- [ ] Laundry|1:- [ ] Report|1:  - [ ] Charts"; strings.Join(got, "|") != want {
+		t.Errorf("move saved %s, want %s", strings.Join(got, "|"), want)
+	}
+	if n := m.current(); n == nil || n.Text != "Report" {
+		t.Errorf("cursor should follow the moved todo")
+	}
+
+	m.cursor = 0
+	key('a')
+	if saved := <-fs.SaveCh; saved[0].Block != 0 || saved[0].Text != "New todo" {
+		t.Errorf("a on a header should add to that block, saved %+v", saved[0])
+	}
+
+	key('B')
+	for _, r := range "Errands" {
+		key(r)
+	}
+	model, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = model.(Model)
+	if row, ok := m.header(); !ok || row.Block != 2 || !strings.Contains(m.View(), "▾ Errands (0)") {
+		t.Errorf("new block not shown:\n%s", m.View())
+	}
+	if data, _ := os.ReadFile(path); !strings.HasSuffix(string(data), ":td\n\n:td Errands\n:td\n") {
+		t.Errorf("block not appended:\n%s", data)
+	}
+}
+
+func TestModel_SessionRestore(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "todos.md")
+	os.WriteFile(path, []byte(":td Work\n- [ ] Report\n  - [ ] Charts\n- [ ] Email #work\n:td\n:td Home\n- [ ] Laundry\n:td\n"), 0644)
+	open := func() Model {
+		fs := &sync.FileSynchronizer{Path: path, ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+		m := Model{sync: fs, collapsed: make(map[int]bool)}
+		m.reload()
+		return m
+	}
+
+	m := open()
+	m.cursor = 1 // Report
+	model, _ := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = model.(Model)
+	m.toggleSection(1)
+	m.cursor = 2 // Email
+	m.saveSession()
+
+	// Another edit shifts every line before the next launch.
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, []byte(strings.Replace(string(data), ":td Work\n", ":td Work\n- [ ] Inserted\n", 1)), 0644)
+
+	m = open()
+	state, err := session.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.restoreSession(state)
+	view := m.View()
+	if strings.Contains(view, "Charts") || !strings.Contains(view, "▸ Home") {
+		t.Errorf("collapse state not restored:\n%s", view)
+	}
+	if n := m.current(); n == nil || n.Text != "Email #work" {
+		t.Errorf("cursor not restored: %+v", n)
+	}
+
+	m.filterBuffer = "tag:work"
+	m.filter, _ = query.Parse(m.filterBuffer)
+	m.saveSession()
+	m = open()
+	state, _ = session.Load(path)
+	m.restoreSession(state)
+	if m.filter == nil || len(m.flat) != 1 {
+		t.Errorf("filter not restored, %d rows", len(m.flat))
+	}
+}
+
+func TestModel_Folds(t *testing.T) {
+	fs := &sync.FileSynchronizer{Path: "dummy.md", ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+	m := Model{todos: parser.ParseTodos([][]string{{
+		"- [ ] A",
+		"  - [ ] A1",
+		"    - [ ] A1a",
+		"- [ ] B",
+		"  - [ ] B1",
+	}}), sync: fs, collapsed: make(map[int]bool)}
+	m.refreshTree()
+	fold := func(keys string) []string {
+		for _, r := range keys {
+			model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+			m = model.(Model)
+		}
+		var rows []string
+		for _, row := range m.flat {
+			rows = append(rows, row.Todo.Text)
+		}
+		return rows
+	}
+
+	m.cursor = 2 // A1a
+	if got := strings.Join(fold("zM"), ","); got != "A,B" {
+		t.Errorf("zM shows %s", got)
+	}
+	if n := m.current(); n.Text != "A" {
+		t.Errorf("cursor should move to the visible ancestor, on %s", n.Text)
+	}
+	if got := strings.Join(fold("zo"), ","); got != "A,A1,A1a,B" {
+		t.Errorf("zo shows %s", got)
+	}
+	if got := strings.Join(fold("zR"), ","); got != "A,A1,A1a,B,B1" {
+		t.Errorf("zR shows %s", got)
+	}
+	if got := strings.Join(fold("z2"), ","); got != "A,A1,B,B1" {
+		t.Errorf("z2 shows %s", got)
+	}
+	m.cursor = 0
+	if got := strings.Join(fold("zc"), ","); got != "A,B,B1" {
+		t.Errorf("zc shows %s", got)
+	}
+	if got := strings.Join(fold("zo"), ","); got != "A,A1,A1a,B,B1" {
+		t.Errorf("zo should expand recursively, shows %s", got)
+	}
+	if fold("zq"); m.foldPending || m.cursor != 0 {
+		t.Error("an unknown fold key should be ignored")
+	}
+}
+
+func TestModel_Focus(t *testing.T) {
+	fs := &sync.FileSynchronizer{Path: "dummy.md", ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+	m := Model{todos: parser.ParseTodos([][]string{{
+		"- [ ] A",
+		"  - [ ] A1",
+		"    - [ ] A1a",
+		"  - [ ] A2",
+		"- [ ] B",
+	}}), sync: fs, collapsed: make(map[int]bool)}
+	m.nextID = len(m.todos) + 1
+	m.refreshTree()
+	press := func(keys string) {
+		for _, r := range keys {
+			model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+			m = model.(Model)
+		}
+	}
+	rows := func() string {
+		var out []string
+		for _, row := range m.flat {
+			out = append(out, row.Todo.Text)
+		}
+		return strings.Join(out, ",")
+	}
+
+	m.cursor = 0
+	press("f")
+	if got := rows(); got != "A1,A1a,A2" {
+		t.Fatalf("focus on A shows %s", got)
+	}
+	if !strings.Contains(m.View(), "Focus: A") {
+		t.Error("the view should show a breadcrumb")
+	}
+	if m.flat[0].Depth != 0 {
+		t.Error("the focused todo's children should be the view's roots")
+	}
+
+	press("f") // on A1
+	if got := rows(); got != "A1a" {
+		t.Fatalf("focus on A1 shows %s", got)
+	}
+	m.cursor = 0
+	press("d")
+	if got := rows(); got != "" {
+		t.Fatalf("delete should stay in the focused subtree, shows %s", got)
+	}
+	press("a")
+	if got := rows(); got != "New todo" {
+		t.Fatalf("add in an empty focus shows %s", got)
+	}
+	var texts []string
+	for _, td := range m.todos {
+		texts = append(texts, fmt.Sprintf("%d:%s", td.IndentLevel, td.Text))
+	}
+	if got := strings.Join(texts, ","); got != "0:A,2:A1,4:New todo,2:A2,0:B" {
+		t.Errorf("the rest of the tree should be untouched, got %s", got)
+	}
+
+	press("F")
+	if got := rows(); got != "A1,New todo,A2" {
+		t.Errorf("zoom out shows %s", got)
+	}
+	if n := m.current(); n == nil || n.Text != "A1" {
+		t.Error("zoom out should leave the cursor on the previously focused todo")
+	}
+	press("F")
+	if got := rows(); got != "A,A1,New todo,A2,B" || m.focused != nil {
+		t.Errorf("zooming out of a root should clear the focus, shows %s", got)
+	}
+}
+
+func TestModel_Hide(t *testing.T) {
+	fs := &sync.FileSynchronizer{Path: "dummy.md", ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+	m := Model{todos: parser.ParseTodos([][]string{{
+		"- [ ] A",
+		"  - [x] A1",
+		"  - [-] A2",
+		"  - [ ] A3",
+		"- [x] B",
+		"- [>] C",
+	}}), sync: fs, collapsed: make(map[int]bool)}
+	m.refreshTree()
+	press := func(keys string) {
+		for _, r := range keys {
+			model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+			m = model.(Model)
+		}
+	}
+	rows := func() string {
+		var out []string
+		for _, row := range m.flat {
+			out = append(out, row.Todo.Text)
+		}
+		return strings.Join(out, ",")
+	}
+
+	m.cursor = 3 // A3
+	press("HH")
+	if got := rows(); got != "A,A3" {
+		t.Fatalf("HH shows %s", got)
+	}
+	if m.flat[0].Hidden != 2 || m.hiddenTop != 2 {
+		t.Errorf("hidden counts: A %d, top level %d", m.flat[0].Hidden, m.hiddenTop)
+	}
+	if n := m.current(); n == nil || n.Text != "A3" {
+		t.Error("the cursor should stay on its todo")
+	}
+	if v := m.View(); !strings.Contains(v, "+2 hidden") || !strings.Contains(v, "Hiding completed, cancelled, pushed") {
+		t.Errorf("view should mark hidden todos:\n%s", v)
+	}
+	press("H-")
+	if got := rows(); got != "A,A2,A3" {
+		t.Errorf("H- should show cancelled todos again, shows %s", got)
+	}
+	press("HH")
+	if got := rows(); got != "A,A1,A2,A3,B,C" {
+		t.Errorf("HH should show everything again, shows %s", got)
+	}
+	press("Hx")
+	if got := rows(); got != "A,A2,A3,C" {
+		t.Errorf("Hx shows %s", got)
+	}
+	if len(m.todos) != 6 {
+		t.Error("hiding must not remove todos")
+	}
+}
+
+func TestModel_Archive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todos.md")
+	os.WriteFile(path, []byte(":td\n- [ ] Release\n  - [x] Build done:2024-06-01\n  - [ ] Deploy\n:td\n"), 0644)
+	fs := &sync.FileSynchronizer{Path: path, ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+	m := Model{sync: fs, collapsed: make(map[int]bool), nextID: 100}
+	m.reload()
+	press := func(r rune) {
+		model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		m = model.(Model)
+	}
+
+	m.cursor = 2 // Deploy
+	press('X')
+	if len(m.notices) == 0 || len(m.todos) != 3 {
+		t.Error("archiving an open todo should do nothing but say so")
+	}
+	m.notices = nil
+	m.cursor = 0 // Release: only Build is finished
+	press('X')
+	if len(m.todos) != 2 || m.todos[1].Text != "Deploy" {
+		t.Fatalf("todos after archive: %+v", m.todos)
+	}
+	parser.WriteTodosToFile(path, <-fs.SaveCh)
+	want := ":td\n- [ ] Release\n  - [ ] Deploy\n:td\n\n## Archive\n\n- [x] Build done:2024-06-01 (in Release)\n"
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Errorf("file after archive:\n%s", got)
+	}
+}
+
+func TestModel_Progress(t *testing.T) {
+	fs := &sync.FileSynchronizer{Path: "dummy.md", ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+	m := Model{todos: parser.ParseTodos([][]string{{
+		"- [ ] Release",
+		"  - [x] Build",
+		"  - [-] Announce",
+		"  - [ ] Deploy",
+		"- [ ] Other",
+	}}), sync: fs, collapsed: make(map[int]bool)}
+	m.progress = config.ProgressConfig{Bar: true, AutoComplete: true, ConfirmParent: true}
+	m.refreshTree()
+	press := func(r rune) {
+		model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		m = model.(Model)
+	}
+
+	view := m.View()
+	if !strings.Contains(view, "Release [1/2] ▰▰▰▱▱▱") {
+		t.Errorf("parent should show its progress:\n%s", view)
+	}
+	if !strings.Contains(view, "Progress: 1/4 done (25%)") {
+		t.Errorf("status line missing:\n%s", view)
+	}
+
+	m.cursor = 0
+	press('x')
+	if m.confirm == nil || m.flat[0].Todo.State != parser.Incomplete {
+		t.Fatal("completing a parent with open children should ask first")
+	}
+	if !strings.Contains(m.View(), "1 open todo(s)") {
+		t.Error("the view should show the question")
+	}
+	press('n')
+	if m.confirm != nil || m.flat[0].Todo.State != parser.Incomplete {
+		t.Fatal("n should cancel")
+	}
+
+	m.cursor = 3 // Deploy, the last open child
+	press('x')
+	if m.flat[0].Todo.State != parser.Completed {
+		t.Error("the parent should be completed once all its children are")
+	}
+	if m.flat[4].Todo.State != parser.Incomplete {
+		t.Error("auto-complete must not touch other roots")
+	}
+
+	m.cursor = 0
+	press('x') // reopen: no question needed
+	m.cursor = 3
+	press(' ')
+	m.cursor = 0
+	press('x')
+	press('y')
+	if m.flat[0].Todo.State != parser.Completed {
+		t.Error("y should complete the parent")
+	}
+}
+
+func TestModel_Cascade(t *testing.T) {
+	fs := &sync.FileSynchronizer{Path: "dummy.md", ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+	m := Model{todos: parser.ParseTodos([][]string{{
+		"- [ ] Release",
+		"  - [ ] Build",
+		"  - [-] Announce",
+	}}), sync: fs, collapsed: make(map[int]bool)}
+	m.cascade = parser.Cascade{Down: true, Reopen: true}
+	m.refreshTree()
+	press := func(r rune) {
+		model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		m = model.(Model)
+	}
+	markers := func() string {
+		var out string
+		for _, td := range m.todos {
+			out += td.State.Def().Marker
+		}
+		return out
+	}
+
+	m.cursor = 0
+	press('x')
+	if got := markers(); got != "xx-" {
+		t.Errorf("completing the parent: %q", got)
+	}
+	m.cursor = 1
+	press('x') // toggles Build back to incomplete
+	if got := markers(); got != "  -" {
+		t.Errorf("reopening a child: %q", got)
+	}
+	if saved := <-fs.SaveCh; len(saved) != 3 {
+		t.Error("cascaded changes should be saved")
+	}
+}
+
+func TestModel_FocusDuplicateText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todos.md")
+	os.WriteFile(path, []byte(":td\n- [ ] Notes\n  - [ ] first\n- [ ] Notes\n  - [ ] second\n:td\n"), 0644)
+	fs := &sync.FileSynchronizer{Path: path, ReloadCh: make(chan struct{}, 1), SaveCh: make(chan []parser.Todo, 10)}
+	m := Model{sync: fs, collapsed: make(map[int]bool), nextID: 100}
+	m.reload()
+	m.refreshTree()
+	rows := func() string {
+		var out []string
+		for _, row := range m.flat {
+			out = append(out, row.Todo.Text)
+		}
+		return strings.Join(out, ",")
+	}
+
+	m.cursor = 2 // the second Notes
+	model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'f'}})
+	m = model.(Model)
+	if got := rows(); got != "second" {
+		t.Fatalf("focusing the second Notes shows %s", got)
+	}
+	m.reload()
+	m.refreshTree()
+	if got := rows(); got != "second" {
+		t.Errorf("after a reload the focus shows %s", got)
+	}
+}